@@ -1,10 +1,18 @@
 package cmd
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/AntonyIS/notelify-users-service/config"
 	"github.com/AntonyIS/notelify-users-service/internal/adapters/app"
+	"github.com/AntonyIS/notelify-users-service/internal/adapters/mailer"
+	"github.com/AntonyIS/notelify-users-service/internal/adapters/notifier"
+	"github.com/AntonyIS/notelify-users-service/internal/adapters/oauth"
+	"github.com/AntonyIS/notelify-users-service/internal/adapters/repository/memory"
 	"github.com/AntonyIS/notelify-users-service/internal/adapters/repository/postgres"
 	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
 	"github.com/AntonyIS/notelify-users-service/internal/core/services"
 )
 
@@ -27,9 +35,225 @@ func RunService() {
 		panic(err)
 	}
 
+	// Provider access tokens, TOTP secrets and signing keys are encrypted
+	// before they are stored
+	encrypter, err := newEncrypter(*conf, databaseRepo, newLoggerService)
+	if err != nil {
+		panic(err)
+	}
+	// Initialize roles and permissions
+	roleService := services.NewRoleManagementService(databaseRepo, newLoggerService)
+	// Users may only modify their own account unless a role allows more, and
+	// admins may act as other users, with every request audited
+	policy := services.NewAuthorizationPolicy(roleService, databaseRepo, newLoggerService)
+	// Initialize personal access tokens for API and CLI clients
+	patService := services.NewPersonalAccessTokenManagementService(databaseRepo, roleService, newLoggerService, conf.PAT_DEFAULT_TTL, conf.PAT_MAX_TTL)
 	// Initialize the article service
-	articleService := services.NewUserManagementService(databaseRepo, newLoggerService)
+	articleService, err := newUserService(*conf, databaseRepo, policy, encrypter, newLoggerService)
+	if err != nil {
+		panic(err)
+	}
+	// Select where revoked tokens are tracked
+	var revocationStore ports.RevocationStore = databaseRepo
+	if conf.REVOCATION_STORE == "memory" {
+		revocationStore = memory.NewRevocationStore()
+	}
+	// Initialize the token service
+	tokenService := services.NewTokenManagementService(databaseRepo, revocationStore, newLoggerService, conf.ACCESS_TOKEN_TTL, conf.REFRESH_TOKEN_TTL)
+	// Select where failed login attempts are counted
+	var loginAttemptStore ports.LoginAttemptStore = databaseRepo
+	if conf.LOGIN_ATTEMPT_STORE == "memory" {
+		loginAttemptStore = memory.NewLoginAttemptStore()
+	}
+	// Record signed-in devices, optionally emailing users about new ones
+	var loginNotifier ports.LoginNotifier
+	if conf.NEW_DEVICE_ALERTS {
+		mailService, err := newMailer(*conf)
+		if err != nil {
+			panic(err)
+		}
+		loginNotifier = notifier.NewMailNotifier(mailService, conf.FRONTEND_URL)
+	}
+	sessionService := services.NewSessionManagementService(databaseRepo, databaseRepo, tokenService, loginNotifier, newLoggerService, conf.REFRESH_TOKEN_TTL)
+	loginService := services.NewLoginProtectionService(loginAttemptStore, newLoggerService, *conf)
+	// Initialize the signing key ring and rotate it in the background
+	keyService, err := services.NewKeyManagementService(databaseRepo, newLoggerService, conf.JWT_SIGNING_ALGORITHM, conf.KEY_ROTATION_INTERVAL, longestSignedTokenTTL(*conf))
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		newLoggerService.LogError(logEntry)
+		panic(err)
+	}
+	keyService.ScheduleKeyRotation(time.Minute)
+	// Initialize service clients and sign outbound requests to other services
+	serviceClientService := services.NewServiceClientManagementService(databaseRepo, keyService, newLoggerService, conf.SERVICE_CLIENT_ID, conf.SERVICE_TOKEN_TTL)
+	databaseRepo.UseServiceTokens(serviceClientService)
+	// Register the social and OpenID Connect login providers
+	providers, err := oauth.NewRegistry(*conf)
+	if err != nil {
+		panic(err)
+	}
 	// Run HTTP Server
-	app.InitGinRoutes(articleService, newLoggerService, *conf)
+	app.InitGinRoutes(articleService, tokenService, keyService, roleService, patService, serviceClientService, sessionService, policy, loginService, providers, newLoggerService, *conf)
+
+}
+
+// ReencryptProviderTokens encrypts stored provider access tokens, TOTP
+// secrets and signing keys with the current key-encryption key. Run it after
+// rotating TOKEN_KEK_ID and before removing the old key from TOKEN_KEKS.
+func ReencryptProviderTokens() {
+	conf, err := config.NewConfig()
+	if err != nil {
+		panic(err)
+	}
+	newLoggerService := services.NewLoggingManagementService(conf.LOGGER_URL)
+
+	databaseRepo, err := postgres.NewPostgresClient(*conf)
+	if err != nil {
+		panic(err)
+	}
+
+	encrypter, err := newEncrypter(*conf, databaseRepo, newLoggerService)
+	if err != nil {
+		panic(err)
+	}
+	roleService := services.NewRoleManagementService(databaseRepo, newLoggerService)
+	policy := services.NewAuthorizationPolicy(roleService, databaseRepo, newLoggerService)
+	userService, err := newUserService(*conf, databaseRepo, policy, encrypter, newLoggerService)
+	if err != nil {
+		panic(err)
+	}
+	updated, err := userService.ReencryptProviderTokens()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Re-encrypted %d provider access tokens\n", updated)
+
+	updated, err = userService.ReencryptTOTPSecrets()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Re-encrypted %d TOTP secrets\n", updated)
+
+	updated, err = databaseRepo.ReencryptSigningKeys()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Re-encrypted %d signing keys\n", updated)
+}
+
+// GrantRole assigns a role to the user with the given email. It is how the
+// first admin is created, since the role endpoints require an admin.
+func GrantRole(email, role string) {
+	conf, err := config.NewConfig()
+	if err != nil {
+		panic(err)
+	}
+	newLoggerService := services.NewLoggingManagementService(conf.LOGGER_URL)
+
+	databaseRepo, err := postgres.NewPostgresClient(*conf)
+	if err != nil {
+		panic(err)
+	}
+
+	user, err := databaseRepo.ReadUserWithEmail(email)
+	if err != nil {
+		panic(err)
+	}
+	roleService := services.NewRoleManagementService(databaseRepo, newLoggerService)
+	if err := roleService.AssignRole(user.UserId, role); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Granted %s to user with ID [%s]\n", role, user.UserId)
+}
+
+// RegisterServiceClient registers another Notelify service for the client
+// credentials grant and prints its credentials. The secret cannot be shown
+// again.
+func RegisterServiceClient(name string, scopes []string) {
+	conf, err := config.NewConfig()
+	if err != nil {
+		panic(err)
+	}
+	newLoggerService := services.NewLoggingManagementService(conf.LOGGER_URL)
+
+	databaseRepo, err := postgres.NewPostgresClient(*conf)
+	if err != nil {
+		panic(err)
+	}
 
+	serviceClientService := services.NewServiceClientManagementService(databaseRepo, nil, newLoggerService, conf.SERVICE_CLIENT_ID, conf.SERVICE_TOKEN_TTL)
+	client, secret, err := serviceClientService.RegisterClient(name, scopes)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("client_id: %s\nclient_secret: %s\n", client.ClientId, secret)
+}
+
+// newMailer selects how outgoing mail is delivered.
+func newMailer(conf config.Config) (ports.Mailer, error) {
+	if conf.MAILER == "outbox" {
+		return mailer.NewOutboxMailer(conf.MAIL_OUTBOX_DIR, conf.MAIL_FROM)
+	}
+	return mailer.NewSMTPMailer(conf.SMTP_HOST, conf.SMTP_PORT, conf.SMTP_USERNAME, conf.SMTP_PASSWORD, conf.MAIL_FROM), nil
+}
+
+// newEncrypter creates the envelope encrypter for stored secrets and has the
+// database use it for signing keys. Without TOKEN_KEKS, signing keys are
+// stored unencrypted and a warning is logged.
+func newEncrypter(conf config.Config, databaseRepo *postgres.PostgresDBClient, logger ports.LoggingService) (*services.EnvelopeEncryptionService, error) {
+	encrypter, err := services.NewEnvelopeEncryptionService(conf.TOKEN_KEKS, conf.TOKEN_KEK_ID)
+	if err != nil {
+		return nil, err
+	}
+	if conf.TOKEN_KEKS == "" {
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  "TOKEN_KEKS is not set, signing keys are stored unencrypted",
+		}
+		logger.LogWarning(logEntry)
+		return encrypter, nil
+	}
+	databaseRepo.UseSecretEncrypter(encrypter)
+	return encrypter, nil
+}
+
+// longestSignedTokenTTL is how long retired signing keys must keep
+// verifying: the lifetime of the longest lived token they sign.
+func longestSignedTokenTTL(conf config.Config) time.Duration {
+	longest := conf.ACCESS_TOKEN_TTL
+	for _, ttl := range []time.Duration{conf.SERVICE_TOKEN_TTL, conf.IMPERSONATION_TTL, conf.MFA_TOKEN_TTL} {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+func newUserService(conf config.Config, databaseRepo *postgres.PostgresDBClient, policy *services.AuthorizationPolicy, encrypter ports.SecretEncrypter, logger ports.LoggingService) (*services.UserManagementService, error) {
+	mailService, err := newMailer(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordPolicy, err := services.NewPasswordPolicy(conf.PASSWORD_MIN_LENGTH, conf.PASSWORD_BLOCKLIST)
+	if err != nil {
+		return nil, err
+	}
+	argon2Params := services.Argon2Params{
+		Memory:      uint32(conf.ARGON2_MEMORY),
+		Iterations:  uint32(conf.ARGON2_ITERATIONS),
+		Parallelism: uint8(conf.ARGON2_PARALLELISM),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	passwordHasher, err := services.NewPasswordHashService(conf.PASSWORD_HASHER, argon2Params, conf.BCRYPT_COST)
+	if err != nil {
+		return nil, err
+	}
+	return services.NewUserManagementService(databaseRepo, databaseRepo, databaseRepo, databaseRepo, mailService, logger, passwordPolicy, passwordHasher, encrypter, policy, conf), nil
 }
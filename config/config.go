@@ -1,7 +1,12 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -22,10 +27,78 @@ type Config struct {
 	GITHUB_CLIENT_SECRET  string
 	GITHUB_REDIRECT_URL   string
 	LINKEDIN_REDIRECT_URL string
+
+	LINKEDIN_CLIENT_ID     string
+	LINKEDIN_CLIENT_SECRET string
+	LINKEDIN_ISSUER        string
+	GITHUB_API_URL         string
+	OIDC_PROVIDERS         []OIDCProviderConfig
+
+	OAUTH_STATE_TTL       time.Duration
+	ACCESS_TOKEN_TTL      time.Duration
+	REFRESH_TOKEN_TTL     time.Duration
+	REVOCATION_STORE      string
+	JWT_SIGNING_ALGORITHM string
+	KEY_ROTATION_INTERVAL time.Duration
+	FRONTEND_URL          string
+	CORS_ALLOWED_ORIGINS  []string
+	COOKIE_DOMAIN         string
+	COOKIE_SECURE         bool
+	COOKIE_SAMESITE       http.SameSite
+	PASSWORD_RESET_TTL    time.Duration
+	RESET_LINK_COOLDOWN   time.Duration
+	PASSWORD_MIN_LENGTH   int
+	PASSWORD_BLOCKLIST    string
+	PASSWORD_HASHER       string
+	ARGON2_MEMORY         int
+	ARGON2_ITERATIONS     int
+	ARGON2_PARALLELISM    int
+	BCRYPT_COST           int
+	TOKEN_KEKS            string
+	TOKEN_KEK_ID          string
+	EMAIL_VERIFY_TTL      time.Duration
+	EMAIL_VERIFY_COOLDOWN time.Duration
+	MAGIC_LINK_TTL        time.Duration
+	MAGIC_LINK_COOLDOWN   time.Duration
+	MFA_TOKEN_TTL         time.Duration
+	IMPERSONATION_TTL     time.Duration
+	PAT_DEFAULT_TTL       time.Duration
+	PAT_MAX_TTL           time.Duration
+	SERVICE_CLIENT_ID     string
+	SERVICE_TOKEN_TTL     time.Duration
+	NEW_DEVICE_ALERTS     bool
+	TOTP_ISSUER           string
+	LOGIN_ATTEMPT_STORE   string
+	LOGIN_ATTEMPT_WINDOW  time.Duration
+	LOGIN_DELAY_BASE      time.Duration
+	LOGIN_LOCK_THRESHOLD  int
+	LOGIN_IP_THRESHOLD    int
+	LOGIN_LOCK_DURATION   time.Duration
+	MAILER                string
+	MAIL_FROM             string
+	MAIL_OUTBOX_DIR       string
+	SMTP_HOST             string
+	SMTP_PORT             string
+	SMTP_USERNAME         string
+	SMTP_PASSWORD         string
 	DEBUG                 bool
 	TEST                  bool
 }
 
+// OIDCProviderConfig configures an OpenID Connect login provider. Endpoints
+// are discovered from the issuer. Claims maps user fields (subject, email,
+// email_verified, firstname, lastname, name, handle, profile_image) to claim
+// names, overriding the standard OIDC claims.
+type OIDCProviderConfig struct {
+	Name         string            `json:"name"`
+	Issuer       string            `json:"issuer"`
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"client_secret"`
+	RedirectURL  string            `json:"redirect_url"`
+	Scopes       []string          `json:"scopes"`
+	Claims       map[string]string `json:"claims"`
+}
+
 func NewConfig() (*Config, error) {
 	ENV := os.Getenv("ENV")
 	switch ENV {
@@ -41,6 +114,11 @@ func NewConfig() (*Config, error) {
 		POSTGRES_PASSWORD     = os.Getenv("POSTGRES_PASSWORD")
 		GITHUB_CLIENT_ID      = os.Getenv("GITHUB_CLIENT_ID")
 		GITHUB_CLIENT_SECRET  = os.Getenv("GITHUB_CLIENT_SECRET")
+		SMTP_HOST             = os.Getenv("SMTP_HOST")
+		SMTP_USERNAME         = os.Getenv("SMTP_USERNAME")
+		TOKEN_KEKS            = os.Getenv("TOKEN_KEKS")
+		TOKEN_KEK_ID          = os.Getenv("TOKEN_KEK_ID")
+		SMTP_PASSWORD         = os.Getenv("SMTP_PASSWORD")
 		POSTGRES_USER         = "postgres"
 		POSTGRES_DB           = "postgres"
 		POSTGRES_HOST         = "postgres"
@@ -51,6 +129,55 @@ func NewConfig() (*Config, error) {
 		ARTICLE_SERVICE_URL   = "http://articles:8001/posts/v1"
 		GITHUB_REDIRECT_URL   = "http://users:3000/github/oauth2/callback"
 		LINKEDIN_REDIRECT_URL = "http://users:3000/linkedin/oauth2/callback"
+
+		LINKEDIN_CLIENT_ID     = os.Getenv("LINKEDIN_CLIENT_ID")
+		LINKEDIN_CLIENT_SECRET = os.Getenv("LINKEDIN_CLIENT_SECRET")
+		LINKEDIN_ISSUER        = "https://www.linkedin.com/oauth"
+		GITHUB_API_URL         = "https://api.github.com"
+		OIDC_PROVIDERS         = []OIDCProviderConfig{}
+
+		OAUTH_STATE_TTL       = time.Minute * 10
+		ACCESS_TOKEN_TTL      = time.Minute * 30
+		REFRESH_TOKEN_TTL     = time.Hour * 24 * 30
+		REVOCATION_STORE      = "postgres"
+		JWT_SIGNING_ALGORITHM = "RS256"
+		KEY_ROTATION_INTERVAL = time.Hour * 24 * 7
+		FRONTEND_URL          = "http://localhost:3000"
+		CORS_ALLOWED_ORIGINS  = []string{}
+		COOKIE_DOMAIN         = os.Getenv("COOKIE_DOMAIN")
+		COOKIE_SECURE         = true
+		COOKIE_SAMESITE       = http.SameSiteLaxMode
+		PASSWORD_RESET_TTL    = time.Hour
+		RESET_LINK_COOLDOWN   = time.Minute
+		PASSWORD_MIN_LENGTH   = 10
+		PASSWORD_BLOCKLIST    = os.Getenv("PASSWORD_BLOCKLIST")
+		PASSWORD_HASHER       = "argon2id"
+		ARGON2_MEMORY         = 64 * 1024
+		ARGON2_ITERATIONS     = 3
+		ARGON2_PARALLELISM    = 2
+		BCRYPT_COST           = 12
+		EMAIL_VERIFY_TTL      = time.Hour * 24
+		EMAIL_VERIFY_COOLDOWN = time.Minute * 5
+		MAGIC_LINK_TTL        = time.Minute * 15
+		MAGIC_LINK_COOLDOWN   = time.Minute
+		MFA_TOKEN_TTL         = time.Minute * 5
+		IMPERSONATION_TTL     = time.Minute * 15
+		PAT_DEFAULT_TTL       = time.Hour * 24 * 30
+		PAT_MAX_TTL           = time.Hour * 24 * 365
+		SERVICE_CLIENT_ID     = "users-service"
+		SERVICE_TOKEN_TTL     = time.Minute * 15
+		NEW_DEVICE_ALERTS     = os.Getenv("NEW_DEVICE_ALERTS") == "true"
+		TOTP_ISSUER           = "Notelify"
+		LOGIN_ATTEMPT_STORE   = "postgres"
+		LOGIN_ATTEMPT_WINDOW  = time.Minute * 15
+		LOGIN_DELAY_BASE      = time.Second
+		LOGIN_LOCK_THRESHOLD  = 10
+		LOGIN_IP_THRESHOLD    = 50
+		LOGIN_LOCK_DURATION   = time.Minute * 15
+		MAILER                = "smtp"
+		MAIL_FROM             = "Notelify <no-reply@notelify.com>"
+		MAIL_OUTBOX_DIR       = "outbox"
+		SMTP_PORT             = "587"
 		DEBUG                 = false
 		TEST                  = false
 	)
@@ -69,9 +196,11 @@ func NewConfig() (*Config, error) {
 
 	case "development":
 		TEST = true
+		COOKIE_SECURE = false
 		DEBUG = true
 		POSTGRES_HOST = "localhost"
 		USER_TABLE = "DevUsers"
+		MAILER = "outbox"
 		LOGGER_URL = "http://localhost:8002/logger/v1/users"
 		ARTICLE_SERVICE_URL = "http://localhost:8001/posts/v1"
 		GITHUB_REDIRECT_URL = "http://localhost:3000/github/oauth2/callback"
@@ -79,11 +208,13 @@ func NewConfig() (*Config, error) {
 
 	case "development_test":
 		TEST = true
+		COOKIE_SECURE = false
 		DEBUG = true
 		SECRET_KEY = "testsecret"
 		POSTGRES_PASSWORD = "pass1234"
 		POSTGRES_HOST = "localhost"
 		USER_TABLE = "TestUsers"
+		MAILER = "outbox"
 		LOGGER_URL = "http://localhost:8002/logger/v1/users"
 		ARTICLE_SERVICE_URL = "http://localhost:8001/posts/v1"
 		GITHUB_REDIRECT_URL = "http://localhost:3000/github/oauth2/callback"
@@ -91,17 +222,88 @@ func NewConfig() (*Config, error) {
 
 	case "docker":
 		TEST = true
+		COOKIE_SECURE = false
 		DEBUG = true
 		USER_TABLE = "DockerUsers"
 		LOGGER_URL = "http://logger:8002/logger/v1/users"
 
 	case "docker_test":
 		TEST = true
+		COOKIE_SECURE = false
 		DEBUG = true
 		USER_TABLE = "DockerUsers"
 		LOGGER_URL = "http://logger:8002/logger/v1/users"
 	}
 
+	if store := os.Getenv("REVOCATION_STORE"); store != "" {
+		REVOCATION_STORE = store
+	}
+
+	if store := os.Getenv("LOGIN_ATTEMPT_STORE"); store != "" {
+		LOGIN_ATTEMPT_STORE = store
+	}
+
+	if frontendURL := os.Getenv("FRONTEND_URL"); frontendURL != "" {
+		FRONTEND_URL = frontendURL
+	}
+
+	// CORS_ALLOWED_ORIGINS is a comma separated list of origins allowed to
+	// make credentialed requests. It defaults to the frontend.
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				CORS_ALLOWED_ORIGINS = append(CORS_ALLOWED_ORIGINS, origin)
+			}
+		}
+	} else {
+		CORS_ALLOWED_ORIGINS = []string{FRONTEND_URL}
+	}
+	for _, origin := range CORS_ALLOWED_ORIGINS {
+		if strings.Contains(origin, "*") {
+			return nil, errors.New("CORS_ALLOWED_ORIGINS must list origins explicitly")
+		}
+	}
+
+	if secure := os.Getenv("COOKIE_SECURE"); secure != "" {
+		COOKIE_SECURE = secure == "true"
+	}
+
+	switch sameSite := strings.ToLower(os.Getenv("COOKIE_SAMESITE")); sameSite {
+	case "":
+	case "lax":
+		COOKIE_SAMESITE = http.SameSiteLaxMode
+	case "strict":
+		COOKIE_SAMESITE = http.SameSiteStrictMode
+	case "none":
+		COOKIE_SAMESITE = http.SameSiteNoneMode
+	default:
+		return nil, errors.New("COOKIE_SAMESITE must be lax, strict or none")
+	}
+	// Browsers drop SameSite=None cookies that are not Secure.
+	if COOKIE_SAMESITE == http.SameSiteNoneMode && !COOKIE_SECURE {
+		return nil, errors.New("COOKIE_SAMESITE=none requires COOKIE_SECURE")
+	}
+
+	if algorithm := os.Getenv("JWT_SIGNING_ALGORITHM"); algorithm != "" {
+		JWT_SIGNING_ALGORITHM = algorithm
+	}
+
+	// OIDC_PROVIDERS is a JSON list of extra OpenID Connect providers, e.g.
+	// [{"name":"google","issuer":"https://accounts.google.com",...}]
+	if providers := os.Getenv("OIDC_PROVIDERS"); providers != "" {
+		if err := json.Unmarshal([]byte(providers), &OIDC_PROVIDERS); err != nil {
+			return nil, err
+		}
+	}
+
+	if clientId := os.Getenv("SERVICE_CLIENT_ID"); clientId != "" {
+		SERVICE_CLIENT_ID = clientId
+	}
+
+	if hasher := os.Getenv("PASSWORD_HASHER"); hasher != "" {
+		PASSWORD_HASHER = hasher
+	}
+
 	config := Config{
 		ENV:                   ENV,
 		SERVER_PORT:           SERVER_PORT,
@@ -120,6 +322,60 @@ func NewConfig() (*Config, error) {
 		GITHUB_CLIENT_SECRET:  GITHUB_CLIENT_SECRET,
 		GITHUB_REDIRECT_URL:   GITHUB_REDIRECT_URL,
 		LINKEDIN_REDIRECT_URL: LINKEDIN_REDIRECT_URL,
+
+		LINKEDIN_CLIENT_ID:     LINKEDIN_CLIENT_ID,
+		LINKEDIN_CLIENT_SECRET: LINKEDIN_CLIENT_SECRET,
+		LINKEDIN_ISSUER:        LINKEDIN_ISSUER,
+		GITHUB_API_URL:         GITHUB_API_URL,
+		OIDC_PROVIDERS:         OIDC_PROVIDERS,
+
+		OAUTH_STATE_TTL:       OAUTH_STATE_TTL,
+		ACCESS_TOKEN_TTL:      ACCESS_TOKEN_TTL,
+		REFRESH_TOKEN_TTL:     REFRESH_TOKEN_TTL,
+		REVOCATION_STORE:      REVOCATION_STORE,
+		JWT_SIGNING_ALGORITHM: JWT_SIGNING_ALGORITHM,
+		KEY_ROTATION_INTERVAL: KEY_ROTATION_INTERVAL,
+		FRONTEND_URL:          FRONTEND_URL,
+		CORS_ALLOWED_ORIGINS:  CORS_ALLOWED_ORIGINS,
+		COOKIE_DOMAIN:         COOKIE_DOMAIN,
+		COOKIE_SECURE:         COOKIE_SECURE,
+		COOKIE_SAMESITE:       COOKIE_SAMESITE,
+		PASSWORD_RESET_TTL:    PASSWORD_RESET_TTL,
+		RESET_LINK_COOLDOWN:   RESET_LINK_COOLDOWN,
+		PASSWORD_MIN_LENGTH:   PASSWORD_MIN_LENGTH,
+		PASSWORD_BLOCKLIST:    PASSWORD_BLOCKLIST,
+		PASSWORD_HASHER:       PASSWORD_HASHER,
+		ARGON2_MEMORY:         ARGON2_MEMORY,
+		ARGON2_ITERATIONS:     ARGON2_ITERATIONS,
+		ARGON2_PARALLELISM:    ARGON2_PARALLELISM,
+		BCRYPT_COST:           BCRYPT_COST,
+		TOKEN_KEKS:            TOKEN_KEKS,
+		TOKEN_KEK_ID:          TOKEN_KEK_ID,
+		EMAIL_VERIFY_TTL:      EMAIL_VERIFY_TTL,
+		EMAIL_VERIFY_COOLDOWN: EMAIL_VERIFY_COOLDOWN,
+		MAGIC_LINK_TTL:        MAGIC_LINK_TTL,
+		MAGIC_LINK_COOLDOWN:   MAGIC_LINK_COOLDOWN,
+		MFA_TOKEN_TTL:         MFA_TOKEN_TTL,
+		IMPERSONATION_TTL:     IMPERSONATION_TTL,
+		PAT_DEFAULT_TTL:       PAT_DEFAULT_TTL,
+		PAT_MAX_TTL:           PAT_MAX_TTL,
+		SERVICE_CLIENT_ID:     SERVICE_CLIENT_ID,
+		SERVICE_TOKEN_TTL:     SERVICE_TOKEN_TTL,
+		NEW_DEVICE_ALERTS:     NEW_DEVICE_ALERTS,
+		TOTP_ISSUER:           TOTP_ISSUER,
+		LOGIN_ATTEMPT_STORE:   LOGIN_ATTEMPT_STORE,
+		LOGIN_ATTEMPT_WINDOW:  LOGIN_ATTEMPT_WINDOW,
+		LOGIN_DELAY_BASE:      LOGIN_DELAY_BASE,
+		LOGIN_LOCK_THRESHOLD:  LOGIN_LOCK_THRESHOLD,
+		LOGIN_IP_THRESHOLD:    LOGIN_IP_THRESHOLD,
+		LOGIN_LOCK_DURATION:   LOGIN_LOCK_DURATION,
+		MAILER:                MAILER,
+		MAIL_FROM:             MAIL_FROM,
+		MAIL_OUTBOX_DIR:       MAIL_OUTBOX_DIR,
+		SMTP_HOST:             SMTP_HOST,
+		SMTP_PORT:             SMTP_PORT,
+		SMTP_USERNAME:         SMTP_USERNAME,
+		SMTP_PASSWORD:         SMTP_PASSWORD,
 	}
 
 	return &config, nil
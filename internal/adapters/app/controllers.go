@@ -1,55 +1,107 @@
 package app
 
 import (
-	"context"
-	"encoding/json"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/AntonyIS/notelify-users-service/config"
 	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
 	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+	"github.com/AntonyIS/notelify-users-service/internal/core/services"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/oauth2"
 )
 
 type GinHandler interface {
 	CreateUser(ctx *gin.Context)
 	ReadUser(ctx *gin.Context)
+	ReadUserProfile(ctx *gin.Context)
 	ReadUsers(ctx *gin.Context)
 	UpdateUser(ctx *gin.Context)
 	DeleteUser(ctx *gin.Context)
 	DeleteAllUsers(ctx *gin.Context)
 	Login(ctx *gin.Context)
+	LoginMFA(ctx *gin.Context)
+	RefreshToken(ctx *gin.Context)
+	EnrollTOTP(ctx *gin.Context)
+	ConfirmTOTP(ctx *gin.Context)
+	DisableTOTP(ctx *gin.Context)
+	UnlockAccount(ctx *gin.Context)
+	ReadRoles(ctx *gin.Context)
+	ReadUserRoles(ctx *gin.Context)
+	AssignUserRole(ctx *gin.Context)
+	RemoveUserRole(ctx *gin.Context)
+	ImpersonateUser(ctx *gin.Context)
+	CreatePersonalAccessToken(ctx *gin.Context)
+	ReadPersonalAccessTokens(ctx *gin.Context)
+	RevokePersonalAccessToken(ctx *gin.Context)
+	ServiceToken(ctx *gin.Context)
+	Introspect(ctx *gin.Context)
+	UserInfo(ctx *gin.Context)
+	ForgotPassword(ctx *gin.Context)
+	RequestMagicLink(ctx *gin.Context)
+	MagicLinkLogin(ctx *gin.Context)
+	ResetPassword(ctx *gin.Context)
+	SetPassword(ctx *gin.Context)
+	VerifyEmail(ctx *gin.Context)
+	ResendVerificationEmail(ctx *gin.Context)
 	GithubLogin(ctx *gin.Context)
 	GithubCallback(ctx *gin.Context)
+	LinkedinLogin(ctx *gin.Context)
+	LinkedinCallback(ctx *gin.Context)
+	OAuthLogin(ctx *gin.Context)
+	OAuthCallback(ctx *gin.Context)
+	LinkIdentity(ctx *gin.Context)
+	ConfirmIdentityLink(ctx *gin.Context)
+	UnlinkIdentity(ctx *gin.Context)
+	ReadUserIdentities(ctx *gin.Context)
 	Logout(ctx *gin.Context)
+	LogoutAllSessions(ctx *gin.Context)
+	ReadSessions(ctx *gin.Context)
+	RevokeSession(ctx *gin.Context)
 	HealthCheck(ctx *gin.Context)
+	JWKS(ctx *gin.Context)
 }
 
 type handler struct {
-	svc         ports.UserService
-	conf        config.Config
-	logger      ports.LoggingService
-	githubOauth *oauth2.Config
-}
-
-func NewGinHandler(svc ports.UserService, logger ports.LoggingService, conf config.Config) GinHandler {
-	oauthConfig := &oauth2.Config{
-		ClientID:     conf.GITHUB_CLIENT_ID,
-		ClientSecret: conf.GITHUB_CLIENT_SECRET,
-		RedirectURL:  conf.GITHUB_REDIRECT_URL,
-		Scopes:       []string{"user"},
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://github.com/login/oauth/authorize",
-			TokenURL: "https://github.com/login/oauth/access_token",
-		},
-	}
+	svc        ports.UserService
+	tokenSvc   ports.TokenService
+	keySvc     ports.KeyService
+	roleSvc    ports.RoleService
+	patSvc     ports.PersonalAccessTokenService
+	serviceSvc ports.ServiceClientService
+	sessionSvc ports.SessionService
+	policy     ports.ImpersonationPolicy
+	loginSvc   ports.LoginProtectionService
+	providers  ports.IdentityProviderRegistry
+	conf       config.Config
+	logger     ports.LoggingService
+	middleware *middleware
+}
+
+func NewGinHandler(svc ports.UserService, tokenSvc ports.TokenService, keySvc ports.KeyService, roleSvc ports.RoleService, patSvc ports.PersonalAccessTokenService, serviceSvc ports.ServiceClientService, sessionSvc ports.SessionService, policy ports.ImpersonationPolicy, loginSvc ports.LoginProtectionService, providers ports.IdentityProviderRegistry, logger ports.LoggingService, conf config.Config) GinHandler {
 	routerHandler := handler{
-		svc:         svc,
-		conf:        conf,
-		logger:      logger,
-		githubOauth: oauthConfig,
+		svc:        svc,
+		tokenSvc:   tokenSvc,
+		keySvc:     keySvc,
+		roleSvc:    roleSvc,
+		patSvc:     patSvc,
+		serviceSvc: serviceSvc,
+		sessionSvc: sessionSvc,
+		policy:     policy,
+		loginSvc:   loginSvc,
+		providers:  providers,
+		conf:       conf,
+		logger:     logger,
+		middleware: NewMiddleware(svc, tokenSvc, keySvc, roleSvc, patSvc, serviceSvc, policy, logger, conf),
 	}
 
 	return routerHandler
@@ -63,8 +115,19 @@ func (h handler) CreateUser(ctx *gin.Context) {
 		})
 		return
 	}
+	// Only a verification link can mark an email address as verified, and
+	// only an OAuth callback can link a provider account.
+	res.EmailVerified = false
+	res.GitHubId = ""
+	res.LinkedInId = ""
 
 	user, err := h.svc.CreateUser(&res)
+	if errors.Is(err, services.ErrWeakPassword) || errors.Is(err, services.ErrEmptyPassword) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -87,6 +150,21 @@ func (h handler) ReadUser(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, user)
 }
 
+// ReadUserProfile returns the public profile of a user. Unlike ReadUser,
+// which only other Notelify services may call, it leaves out the email
+// address and account settings.
+func (h handler) ReadUserProfile(ctx *gin.Context) {
+	user_id := ctx.Param("user_id")
+	user, err := h.svc.ReadUserWithId(user_id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"err": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, user.UserInfo())
+}
+
 func (h handler) ReadUsers(ctx *gin.Context) {
 	users, err := h.svc.ReadUsers()
 	if err != nil {
@@ -99,8 +177,8 @@ func (h handler) ReadUsers(ctx *gin.Context) {
 }
 
 func (h handler) UpdateUser(ctx *gin.Context) {
-	user_id := ctx.Param("id")
-	_, err := h.svc.ReadUserWithId(user_id)
+	user_id := ctx.Param("user_id")
+	res, err := h.svc.ReadUserWithId(user_id)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"err": err.Error(),
@@ -108,15 +186,43 @@ func (h handler) UpdateUser(ctx *gin.Context) {
 		return
 	}
 
-	var res *domain.User
-	if err := ctx.ShouldBindJSON(&res); err != nil {
+	// Only profile fields can be changed here, and fields missing from the
+	// body keep their current values.
+	var request struct {
+		Firstname    *string `json:"firstname"`
+		Lastname     *string `json:"lastname"`
+		Handle       *string `json:"handle"`
+		About        *string `json:"about"`
+		ProfileImage *string `json:"profile_image"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	res.UserId = user_id
-	user, err := h.svc.UpdateUser(res)
+	if request.Firstname != nil {
+		res.Firstname = *request.Firstname
+	}
+	if request.Lastname != nil {
+		res.Lastname = *request.Lastname
+	}
+	if request.Handle != nil {
+		res.Handle = *request.Handle
+	}
+	if request.About != nil {
+		res.About = *request.About
+	}
+	if request.ProfileImage != nil {
+		res.ProfileImage = *request.ProfileImage
+	}
+	user, err := h.svc.UpdateUser(actorFrom(ctx), res)
+	if err == services.ErrForbidden {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -127,8 +233,14 @@ func (h handler) UpdateUser(ctx *gin.Context) {
 }
 
 func (h handler) DeleteUser(ctx *gin.Context) {
-	user_id := ctx.Param("id")
-	message, err := h.svc.DeleteUser(user_id)
+	user_id := ctx.Param("user_id")
+	message, err := h.svc.DeleteUser(actorFrom(ctx), user_id)
+	if err == services.ErrForbidden {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -140,6 +252,14 @@ func (h handler) DeleteUser(ctx *gin.Context) {
 	})
 }
 
+// actorFrom returns the user authenticated by Authorize.
+func actorFrom(ctx *gin.Context) *domain.Actor {
+	return &domain.Actor{
+		UserId: ctx.GetString("user_id"),
+		Roles:  ctx.GetStringSlice("roles"),
+	}
+}
+
 func (h handler) Login(ctx *gin.Context) {
 	var user domain.User
 	if err := ctx.ShouldBind(&user); err != nil {
@@ -148,163 +268,1129 @@ func (h handler) Login(ctx *gin.Context) {
 		})
 		return
 	}
-	dbUser, err := h.svc.ReadUserWithEmail(user.Email)
+	if !h.checkLoginAllowed(ctx, user.Email) {
+		return
+	}
 
+	dbUser, err := h.svc.Authenticate(user.Email, user.Password)
 	if err != nil {
+		h.loginSvc.RecordLoginFailure(user.Email, ctx.ClientIP())
 		ctx.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
+			"error": "Invalid email or password",
 		})
 		return
 	}
 
-	if dbUser.CheckPasswordHarsh(user.Password) {
-		middleware := NewMiddleware(h.svc, h.logger, h.conf.SECRET_KEY)
-		tokenString, err := middleware.GenerateToken(dbUser.UserId)
+	h.loginSvc.RecordLoginSuccess(user.Email)
+	h.startSession(ctx, dbUser)
+}
 
-		if err != nil {
-			ctx.JSON(http.StatusNotFound, gin.H{
-				"error": err.Error(),
-			})
-			return
-		}
+// LoginMFA is the second login step for users with two-factor
+// authentication. It exchanges the mfa token from Login and a TOTP or
+// recovery code for regular tokens.
+func (h handler) LoginMFA(ctx *gin.Context) {
+	var request struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
-		ctx.SetSameSite(http.SameSiteLaxMode)
-		ctx.SetCookie("token", tokenString, 3600*24*30, "", "", false, true)
+	user_id, jti, expiresAt, err := h.middleware.ParseMFAToken(request.MFAToken)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
-		ctx.JSON(http.StatusOK, gin.H{
-			"accessToken": tokenString,
+	// Codes are guessed against the same counters as passwords.
+	user, err := h.svc.ReadUserWithId(user_id)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
 		})
+		return
+	}
+	if !h.checkLoginAllowed(ctx, user.Email) {
+		return
+	}
 
+	if err := h.svc.VerifyTOTP(user_id, request.Code); err != nil {
+		h.loginSvc.RecordLoginFailure(user.Email, ctx.ClientIP())
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
 		return
+	}
+	h.loginSvc.RecordLoginSuccess(user.Email)
 
-	} else {
-		ctx.JSON(http.StatusNotFound, gin.H{
-			"error": "Invalid email or password",
+	// The mfa token is single use.
+	if err := h.tokenSvc.RevokeAccessToken(jti, expiresAt); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
+
+	h.issueTokens(ctx, user_id)
 }
 
-func (h handler) Logout(ctx *gin.Context) {
-	tokenString := ctx.GetHeader("tokenString")
+func (h handler) EnrollTOTP(ctx *gin.Context) {
+	enrollment, err := h.svc.EnrollTOTP(ctx.GetString("user_id"))
+	if errors.Is(err, services.ErrEncryptionKeyNotSet) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "two-factor authentication is not available",
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, enrollment)
+}
+
+func (h handler) ConfirmTOTP(ctx *gin.Context) {
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
 
-	if tokenString == "" {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"error": "Authorization header is missing",
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
 
+	recoveryCodes, err := h.svc.ConfirmTOTP(ctx.GetString("user_id"), request.Code)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "Token invalidated successfuly",
+		"message":        "Two-factor authentication enabled successfuly",
+		"recovery_codes": recoveryCodes,
 	})
+}
+
+func (h handler) DisableTOTP(ctx *gin.Context) {
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
+	if err := h.svc.DisableTOTP(ctx.GetString("user_id"), request.Code); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Two-factor authentication disabled successfuly",
+	})
 }
 
-func (h handler) DeleteAllUsers(ctx *gin.Context) {
-	message, err := h.svc.DeleteAllUsers()
-	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{
+func (h handler) UnlockAccount(ctx *gin.Context) {
+	var request struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
+	if err := h.loginSvc.UnlockAccount(request.Email); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": message,
+		"message": "Account unlocked successfuly",
 	})
 }
 
-func (h handler) GithubLogin(ctx *gin.Context) {
-	url := h.githubOauth.AuthCodeURL("state")
-	ctx.Redirect(http.StatusTemporaryRedirect, url)
+func (h handler) ReadRoles(ctx *gin.Context) {
+	roles, err := h.roleSvc.ReadRoles()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, roles)
 }
 
-func (h handler) GithubCallback(ctx *gin.Context) {
+func (h handler) ReadUserRoles(ctx *gin.Context) {
+	roles, err := h.roleSvc.ReadUserRoles(ctx.Param("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"user_id": ctx.Param("user_id"),
+		"roles":   roles,
+	})
+}
+
+// AssignUserRole grants a role. Access tokens carry the roles they were
+// issued with, so the change applies from the user's next token.
+func (h handler) AssignUserRole(ctx *gin.Context) {
 	var request struct {
-		Code string `json:"code"`
+		Role string `json:"role" binding:"required"`
 	}
 
-	if err := ctx.BindJSON(&request); err != nil {
-		fmt.Println("ERROR 1!", err)
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
 		return
 	}
-	token, err := h.githubOauth.Exchange(context.Background(), request.Code)
 
-	if err != nil {
-		fmt.Println("ERROR 2!", err)
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Failed to exchange code for token"})
+	user_id := ctx.Param("user_id")
+	if _, err := h.svc.ReadUserWithId(user_id); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
 		return
 	}
 
-	user, err := getUserDetails(token.AccessToken)
+	err := h.roleSvc.AssignRole(user_id, request.Role)
+	if err == services.ErrUnknownRole {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	if err != nil {
-		fmt.Println("ERROR 3!", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user details"})
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
 		return
 	}
 
-	if user.GitHubId != "" {
-		dbUser, err := h.svc.ReadUserWithGithubId(user.GitHubId)
-		if err != nil {
-			if err.Error() == "sql: no rows in result set" {
-				newUser, err := h.svc.CreateUser(user)
-				if err != nil {
-					ctx.JSON(http.StatusInternalServerError, gin.H{
-						"error": err.Error(),
-					})
-					return
-				}
-				ctx.JSON(http.StatusOK, newUser)
-				return
-			}
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"error": err.Error(),
-			})
-			return
-		}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Role assigned successfuly",
+	})
+}
 
-		ctx.JSON(http.StatusCreated, dbUser)
+func (h handler) RemoveUserRole(ctx *gin.Context) {
+	err := h.roleSvc.RemoveRole(ctx.Param("user_id"), ctx.Param("role"))
+	if err == services.ErrLastAdmin {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
 		return
 	}
-}
 
-func (h handler) HealthCheck(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{
-		"status":  "success",
-		"message": "Server running",
+		"message": "Role removed successfuly",
 	})
 }
 
-func getUserDetails(accessToken string) (*domain.User, error) {
-	// GitHub API endpoint for authenticated user details
-	apiURL := "https://api.github.com/user"
+// CreatePersonalAccessToken responds with the new token. It cannot be read
+// again later.
+func (h handler) CreatePersonalAccessToken(ctx *gin.Context) {
+	var request struct {
+		Name          string   `json:"name" binding:"required"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 
-	// Create a new HTTP request
-	req, err := http.NewRequest("GET", apiURL, nil)
+	ttl := time.Duration(request.ExpiresInDays) * time.Hour * 24
+	token, tokenString, err := h.patSvc.CreateToken(ctx.GetString("user_id"), request.Name, request.Scopes, ttl)
+	if errors.Is(err, services.ErrScopeNotGranted) || errors.Is(err, services.ErrScopeNotAllowed) || err == services.ErrInvalidTokenExpiry {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	if err != nil {
-		return nil, err
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
-	// Set the Authorization header with the OAuth token
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	ctx.JSON(http.StatusCreated, gin.H{
+		"token":   tokenString,
+		"details": token,
+	})
+}
+
+func (h handler) ReadPersonalAccessTokens(ctx *gin.Context) {
+	tokens, err := h.patSvc.ReadTokens(ctx.GetString("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, tokens)
+}
 
-	// Make the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func (h handler) RevokePersonalAccessToken(ctx *gin.Context) {
+	err := h.patSvc.RevokeToken(ctx.GetString("user_id"), ctx.Param("token_id"))
+	if err == services.ErrPersonalAccessTokenMissing {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
 	if err != nil {
-		return nil, err
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Personal access token revoked successfuly",
+	})
+}
+
+// ServiceToken is the OAuth2 token endpoint for the client credentials
+// grant. Clients authenticate with HTTP basic auth or form parameters.
+func (h handler) ServiceToken(ctx *gin.Context) {
+	if ctx.PostForm("grant_type") != "client_credentials" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "unsupported_grant_type",
+		})
+		return
+	}
+
+	client_id, secret, ok := ctx.Request.BasicAuth()
+	if !ok {
+		client_id = ctx.PostForm("client_id")
+		secret = ctx.PostForm("client_secret")
 	}
-	defer resp.Body.Close()
 
-	// Read the response body
-	var githubUser domain.GithubUser
-	decoder := json.NewDecoder(resp.Body)
-	if err := decoder.Decode(&githubUser); err != nil {
-		return nil, err
+	token, err := h.serviceSvc.IssueToken(client_id, secret, strings.Fields(ctx.PostForm("scope")))
+	if err == services.ErrInvalidClient {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error":             "invalid_client",
+			"error_description": err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, services.ErrInvalidScope) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_scope",
+			"error_description": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
-	githubUser.AccessToken = accessToken
-	user := githubUser.InitGithubUser()
-	return &user, nil
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, gin.H{
+		"access_token": token.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(token.ExpiresAt).Seconds()),
+		"scope":        strings.Join(token.Scopes, " "),
+	})
+}
+
+// Introspect reports whether a token is active, following RFC 7662. Access
+// tokens are checked the same way Authorize checks them. Tokens that are not
+// active get no other details.
+func (h handler) Introspect(ctx *gin.Context) {
+	tokenString := ctx.PostForm("token")
+	inactive := gin.H{"active": false}
+
+	if strings.HasPrefix(tokenString, services.PersonalAccessTokenPrefix) {
+		token, err := h.patSvc.AuthenticateToken(tokenString)
+		if err != nil {
+			ctx.JSON(http.StatusOK, inactive)
+			return
+		}
+		roles, err := h.roleSvc.ReadUserRoles(token.UserId)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"active":         true,
+			"token_type":     "personal_access_token",
+			"sub":            token.UserId,
+			"scope":          strings.Join(token.Scopes, " "),
+			"roles":          roles,
+			"email_verified": true,
+			"iat":            token.CreatedAt.Unix(),
+			"exp":            token.ExpiresAt.Unix(),
+		})
+		return
+	}
+
+	if token, err := h.serviceSvc.VerifyToken(tokenString); err == nil {
+		ctx.JSON(http.StatusOK, gin.H{
+			"active":     true,
+			"token_type": "service",
+			"sub":        token.ClientId,
+			"client_id":  token.ClientId,
+			"scope":      strings.Join(token.Scopes, " "),
+			"exp":        token.ExpiresAt.Unix(),
+		})
+		return
+	}
+
+	claims, err := h.middleware.verifyAccessToken(tokenString)
+	if err != nil && !isTokenError(err) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusOK, inactive)
+		return
+	}
+	response := gin.H{
+		"active":         true,
+		"token_type":     "access_token",
+		"sub":            claims["sub"],
+		"roles":          claimRoles(claims),
+		"email_verified": claims["email_verified"],
+		"jti":            claims["jti"],
+		"iat":            claims["iat"],
+		"exp":            claims["exp"],
+	}
+	if act, ok := claims["act"]; ok {
+		response["act"] = act
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// UserInfo returns the public profile of the user the token was issued to.
+func (h handler) UserInfo(ctx *gin.Context) {
+	user, err := h.svc.ReadUserWithId(ctx.GetString("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, user.UserInfo())
+}
+
+// ImpersonateUser issues a short-lived token for an admin to act as another
+// user. It is returned in the body only, so the admin's own session cookies
+// are left alone.
+func (h handler) ImpersonateUser(ctx *gin.Context) {
+	var request struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user_id := ctx.Param("user_id")
+	if _, err := h.svc.ReadUserWithId(user_id); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	admin := actorFrom(ctx)
+	err := h.policy.AuthorizeImpersonation(admin, user_id, request.Reason)
+	if err == services.ErrForbidden {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tokenString, err := h.middleware.GenerateImpersonationToken(admin.UserId, user_id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"accessToken":   tokenString,
+		"expiresIn":     int(h.conf.IMPERSONATION_TTL.Seconds()),
+		"impersonating": user_id,
+	})
+}
+
+// checkLoginAllowed aborts with 429 and a Retry-After header when the
+// account or client IP is throttled or locked.
+func (h handler) checkLoginAllowed(ctx *gin.Context, email string) bool {
+	retryAfter, err := h.loginSvc.CheckLoginAllowed(email, ctx.ClientIP())
+	if err == nil {
+		return true
+	}
+	if retryAfter > 0 {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{
+			"error": err.Error(),
+		})
+		return false
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{
+		"error": err.Error(),
+	})
+	return false
+}
+
+func (h handler) RefreshToken(ctx *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// Browser sessions started by an OAuth redirect only hold the refresh
+	// token in a cookie.
+	_ = ctx.ShouldBindJSON(&request)
+	if request.RefreshToken == "" {
+		request.RefreshToken, _ = ctx.Cookie(refreshTokenCookie)
+		if request.RefreshToken != "" && !validCSRFToken(ctx) {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"error": errInvalidCSRFToken.Error(),
+			})
+			return
+		}
+	}
+	if request.RefreshToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": "refresh token is required",
+		})
+		return
+	}
+
+	token, refreshToken, err := h.tokenSvc.RotateRefreshToken(request.RefreshToken)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tokenString, err := h.middleware.GenerateToken(token.UserId, token.FamilyId)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	h.sessionSvc.TouchSession(token.FamilyId, ctx.ClientIP())
+
+	csrfToken, err := h.setSessionCookies(ctx, tokenString, refreshToken)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"accessToken":  tokenString,
+		"refreshToken": refreshToken,
+		"csrfToken":    csrfToken,
+		"expiresIn":    int(h.conf.ACCESS_TOKEN_TTL.Seconds()),
+	})
+}
+
+func (h handler) ForgotPassword(ctx *gin.Context) {
+	var request struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.svc.RequestPasswordReset(request.Email); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"message": "If an account exists for this email, a password reset link has been sent",
+	})
+}
+
+func (h handler) RequestMagicLink(ctx *gin.Context) {
+	var request struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.svc.RequestMagicLink(request.Email); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"message": "If an account exists for this email, a sign-in link has been sent",
+	})
+}
+
+// MagicLinkLogin signs in with the token from a sign-in link and starts a
+// session the same way as every other login method.
+func (h handler) MagicLinkLogin(ctx *gin.Context) {
+	var request struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.svc.LoginWithMagicLink(request.Token)
+	if err == services.ErrInvalidMagicLink {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.startSession(ctx, user)
+}
+
+func (h handler) ResetPassword(ctx *gin.Context) {
+	var request struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.svc.ResetPassword(request.Token, request.Password)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Sessions and personal access tokens created with the old password are
+	// no longer trusted.
+	if err := h.sessionSvc.RevokeAllSessions(user.UserId); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := h.patSvc.RevokeUserTokens(user.UserId); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Password reset successfuly",
+	})
+}
+
+func (h handler) SetPassword(ctx *gin.Context) {
+	var request struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	err := h.svc.SetPassword(ctx.GetString("user_id"), request.CurrentPassword, request.Password)
+	if err == services.ErrIncorrectPassword {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Password set successfuly",
+	})
+}
+
+func (h handler) VerifyEmail(ctx *gin.Context) {
+	var request struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	_, err := h.svc.VerifyEmail(request.Token)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Email verified successfuly",
+	})
+}
+
+func (h handler) ResendVerificationEmail(ctx *gin.Context) {
+	var request struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := h.svc.ResendVerificationEmail(request.Email); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, gin.H{
+		"message": "If the email belongs to an unverified account, a verification link has been sent",
+	})
+}
+
+func (h handler) Logout(ctx *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// The refresh token is optional; a client that only holds an access
+	// token can still log out.
+	_ = ctx.ShouldBindJSON(&request)
+	if request.RefreshToken == "" {
+		request.RefreshToken, _ = ctx.Cookie(refreshTokenCookie)
+	}
+
+	jti := ctx.GetString("jti")
+	expiresAt := ctx.GetTime("exp")
+	if err := h.tokenSvc.RevokeAccessToken(jti, expiresAt); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if session_id := ctx.GetString("sid"); session_id != "" {
+		err := h.sessionSvc.RevokeSession(ctx.GetString("user_id"), session_id)
+		if err != nil && err != services.ErrSessionMissing {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	if request.RefreshToken != "" {
+		if err := h.tokenSvc.RevokeRefreshToken(ctx.GetString("user_id"), request.RefreshToken); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	h.clearSessionCookies(ctx)
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Token invalidated successfuly",
+	})
+
+}
+
+func (h handler) LogoutAllSessions(ctx *gin.Context) {
+	user_id := ctx.GetString("user_id")
+	if err := h.sessionSvc.RevokeAllSessions(user_id); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.clearSessionCookies(ctx)
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "All sessions logged out successfuly",
+	})
+}
+
+// ReadSessions lists the user's active sessions and marks the one making the
+// request.
+func (h handler) ReadSessions(ctx *gin.Context) {
+	sessions, err := h.sessionSvc.ReadSessions(ctx.GetString("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionId == ctx.GetString("sid")
+	}
+	ctx.JSON(http.StatusOK, sessions)
+}
+
+func (h handler) RevokeSession(ctx *gin.Context) {
+	session_id := ctx.Param("session_id")
+	err := h.sessionSvc.RevokeSession(ctx.GetString("user_id"), session_id)
+	if err == services.ErrSessionMissing {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if session_id == ctx.GetString("sid") {
+		h.clearSessionCookies(ctx)
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Session revoked successfuly",
+	})
+}
+
+func (h handler) DeleteAllUsers(ctx *gin.Context) {
+	message, err := h.svc.DeleteAllUsers()
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": message,
+	})
+}
+
+func (h handler) GithubLogin(ctx *gin.Context) {
+	h.oauthLogin(ctx, "github")
+}
+
+func (h handler) GithubCallback(ctx *gin.Context) {
+	h.oauthCallback(ctx, "github")
+}
+
+func (h handler) LinkedinLogin(ctx *gin.Context) {
+	h.oauthLogin(ctx, "linkedin")
+}
+
+func (h handler) LinkedinCallback(ctx *gin.Context) {
+	h.oauthCallback(ctx, "linkedin")
+}
+
+// OAuthLogin starts a login with any registered identity provider.
+func (h handler) OAuthLogin(ctx *gin.Context) {
+	h.oauthLogin(ctx, ctx.Param("provider"))
+}
+
+func (h handler) OAuthCallback(ctx *gin.Context) {
+	h.oauthCallback(ctx, ctx.Param("provider"))
+}
+
+func (h handler) oauthLogin(ctx *gin.Context, name string) {
+	provider, ok := h.providers.Provider(name)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+	url, ok := h.beginOAuth(ctx, provider, "")
+	if !ok {
+		return
+	}
+	ctx.Redirect(http.StatusTemporaryRedirect, url)
+}
+
+func (h handler) oauthCallback(ctx *gin.Context, name string) {
+	provider, ok := h.providers.Provider(name)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	// The provider can redirect the browser here with the code in the
+	// query, or the frontend can post it on.
+	var request struct {
+		Code  string `json:"code" form:"code" binding:"required"`
+		State string `json:"state" form:"state"`
+	}
+
+	if err := ctx.ShouldBind(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	state, err := h.finishOAuth(ctx, name, request.State)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, identity, err := provider.Exchange(ctx.Request.Context(), request.Code, state.Verifier)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  fmt.Sprintf("%s login failed: %s", name, err.Error()),
+		}
+		h.logger.LogError(logEntry)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to sign in with %s", name)})
+		return
+	}
+
+	if state.LinkUserId != "" {
+		h.linkIdentity(ctx, state.LinkUserId, identity)
+		return
+	}
+
+	dbUser, _, err := h.svc.LoginWithIdentity(user, identity)
+	var linkRequired *services.IdentityLinkRequiredError
+	if errors.As(err, &linkRequired) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error":         err.Error(),
+			"link_required": true,
+			"link_token":    linkRequired.LinkToken,
+			"provider":      linkRequired.Provider,
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.startSession(ctx, dbUser)
+}
+
+// LinkIdentity starts linking a provider to the signed in user. The consent
+// page URL is returned rather than redirected to, since the request carries
+// the user's token; the provider then calls back to the usual callback.
+func (h handler) LinkIdentity(ctx *gin.Context) {
+	provider, ok := h.providers.Provider(ctx.Param("provider"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Unknown login provider"})
+		return
+	}
+
+	url, ok := h.beginOAuth(ctx, provider, ctx.GetString("user_id"))
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"authorization_url": url,
+	})
+}
+
+// ConfirmIdentityLink links a provider whose login matched the signed in
+// user's email, using the link_token from that login's response.
+func (h handler) ConfirmIdentityLink(ctx *gin.Context) {
+	var request struct {
+		LinkToken string `json:"link_token" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	identity, err := h.svc.ConfirmIdentityLink(ctx.GetString("user_id"), request.LinkToken)
+	if err != nil {
+		h.identityError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, identity)
+}
+
+func (h handler) UnlinkIdentity(ctx *gin.Context) {
+	err := h.svc.UnlinkIdentity(ctx.GetString("user_id"), ctx.Param("provider"))
+	if err != nil {
+		h.identityError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Identity unlinked successfully",
+	})
+}
+
+func (h handler) ReadUserIdentities(ctx *gin.Context) {
+	identities, err := h.svc.ReadUserIdentities(ctx.GetString("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, identities)
+}
+
+func (h handler) linkIdentity(ctx *gin.Context, user_id string, identity *domain.UserIdentity) {
+	if err := h.svc.LinkIdentity(user_id, identity); err != nil {
+		h.identityError(ctx, err)
+		return
+	}
+
+	if ctx.Request.Method == http.MethodGet {
+		ctx.Redirect(http.StatusSeeOther, h.conf.FRONTEND_URL)
+		return
+	}
+	ctx.JSON(http.StatusOK, identity)
+}
+
+func (h handler) identityError(ctx *gin.Context, err error) {
+	switch err {
+	case services.ErrIdentityInUse, services.ErrProviderAlreadyLinked, services.ErrLastLoginMethod:
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case services.ErrIdentityNotLinked:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case services.ErrInvalidLinkToken:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (h handler) HealthCheck(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Server running",
+	})
+}
+
+func (h handler) JWKS(ctx *gin.Context) {
+	keys, err := h.keySvc.PublicKeys()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	jwks := []gin.H{}
+	for _, key := range keys {
+		jwk := gin.H{
+			"kid": key.KeyId,
+			"alg": key.Algorithm,
+			"use": "sig",
+		}
+		switch publicKey := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(publicKey)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, gin.H{
+		"keys": jwks,
+	})
 }
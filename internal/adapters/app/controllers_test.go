@@ -1 +1,1653 @@
-package app
\ No newline at end of file
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/adapters/mailer"
+	"github.com/AntonyIS/notelify-users-service/internal/adapters/oauth"
+	"github.com/AntonyIS/notelify-users-service/internal/adapters/repository/memory"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+	"github.com/AntonyIS/notelify-users-service/internal/core/services"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// fakeUserService keeps users in memory. Methods the tests do not need are
+// left to the embedded nil interface.
+type fakeUserService struct {
+	ports.UserService
+	users  map[string]*domain.User
+	linked map[string]string
+}
+
+func (svc *fakeUserService) LoginWithIdentity(user *domain.User, identity *domain.UserIdentity) (*domain.User, bool, error) {
+	user_id := identity.Provider + ":" + identity.Subject
+	if dbUser, ok := svc.users[user_id]; ok {
+		return dbUser, false, nil
+	}
+	user.UserId = user_id
+	svc.users[user_id] = user
+	return user, true, nil
+}
+
+func (svc *fakeUserService) LinkIdentity(user_id string, identity *domain.UserIdentity) error {
+	svc.linked[identity.Provider+":"+identity.Subject] = user_id
+	return nil
+}
+
+func (svc *fakeUserService) UpdateUser(actor *domain.Actor, user *domain.User) (*domain.User, error) {
+	svc.users[user.UserId] = user
+	return user, nil
+}
+
+func (svc *fakeUserService) ReadUserWithId(user_id string) (*domain.User, error) {
+	user, ok := svc.users[user_id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
+type fakeTokenService struct {
+	ports.TokenService
+}
+
+func (fakeTokenService) IssueRefreshToken(user_id string) (*domain.RefreshToken, string, error) {
+	return &domain.RefreshToken{UserId: user_id, FamilyId: "session-" + user_id}, "refresh-" + user_id, nil
+}
+
+func (fakeTokenService) IsAccessTokenRevoked(jti, session_id, user_id string, issuedAt time.Time) (bool, error) {
+	return false, nil
+}
+
+type fakeSessionService struct {
+	ports.SessionService
+}
+
+func (fakeSessionService) RecordSession(user_id, session_id, userAgent, ip string) (*domain.Session, error) {
+	return &domain.Session{SessionId: session_id, UserId: user_id}, nil
+}
+
+// fakeSessionRepository keeps sessions in memory. Refresh token methods are
+// there for the token service, which revokes a session's token family.
+type fakeSessionRepository struct {
+	ports.SessionRepository
+	ports.RefreshTokenRepository
+	sessions map[string]*domain.Session
+	revoked  map[string]bool
+}
+
+func (repo fakeSessionRepository) CreateSession(session *domain.Session) (*domain.Session, error) {
+	repo.sessions[session.SessionId] = session
+	return session, nil
+}
+
+func (repo fakeSessionRepository) ReadUserDevices(user_id string) ([]string, error) {
+	devices := []string{}
+	for _, session := range repo.sessions {
+		if session.UserId == user_id {
+			devices = append(devices, session.Device)
+		}
+	}
+	return devices, nil
+}
+
+func (repo fakeSessionRepository) RevokeSession(user_id, session_id string) (bool, error) {
+	session, ok := repo.sessions[session_id]
+	if !ok || session.UserId != user_id || repo.revoked[session_id] {
+		return false, nil
+	}
+	repo.revoked[session_id] = true
+	return true, nil
+}
+
+func (repo fakeSessionRepository) ReadUserSessions(user_id string, activeSince time.Time) ([]domain.Session, error) {
+	sessions := []domain.Session{}
+	for session_id, session := range repo.sessions {
+		if session.UserId == user_id && !repo.revoked[session_id] {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
+func (repo fakeSessionRepository) RevokeUserSessions(user_id string) (string, error) {
+	for session_id, session := range repo.sessions {
+		if session.UserId == user_id {
+			repo.revoked[session_id] = true
+		}
+	}
+	return "", nil
+}
+
+func (repo fakeSessionRepository) RevokeRefreshTokenFamily(family_id string) (string, error) {
+	return "", nil
+}
+
+func (repo fakeSessionRepository) RevokeUserRefreshTokens(user_id string) (string, error) {
+	return "", nil
+}
+
+type fakeUserRepository struct {
+	ports.UserRepository
+	users map[string]*domain.User
+}
+
+func (repo fakeUserRepository) ReadUserWithId(user_id string) (*domain.User, error) {
+	if user, ok := repo.users[user_id]; ok {
+		return user, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func (repo fakeUserRepository) ReadUserWithEmail(email string) (*domain.User, error) {
+	for _, user := range repo.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (repo fakeUserRepository) CreateUser(user *domain.User) (*domain.User, error) {
+	repo.users[user.UserId] = user
+	return user, nil
+}
+
+func (repo fakeUserRepository) UpdateUserEmailVerified(user_id string, verified bool) (string, error) {
+	repo.users[user_id].EmailVerified = verified
+	return "", nil
+}
+
+// fakeIdentityRepository keeps linked identities in memory, keyed by
+// provider and subject.
+type fakeIdentityRepository struct {
+	identities map[string]*domain.UserIdentity
+}
+
+func (repo fakeIdentityRepository) CreateUserIdentity(identity *domain.UserIdentity) (*domain.UserIdentity, error) {
+	repo.identities[identity.Provider+":"+identity.Subject] = identity
+	return identity, nil
+}
+
+func (repo fakeIdentityRepository) ReadUserIdentity(provider, subject string) (*domain.UserIdentity, error) {
+	if identity, ok := repo.identities[provider+":"+subject]; ok {
+		return identity, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (repo fakeIdentityRepository) ReadUserIdentities(user_id string) ([]domain.UserIdentity, error) {
+	identities := []domain.UserIdentity{}
+	for _, identity := range repo.identities {
+		if identity.UserId == user_id {
+			identities = append(identities, *identity)
+		}
+	}
+	return identities, nil
+}
+
+func (repo fakeIdentityRepository) DeleteUserIdentity(user_id, provider string) (string, error) {
+	for key, identity := range repo.identities {
+		if identity.UserId == user_id && identity.Provider == provider {
+			delete(repo.identities, key)
+		}
+	}
+	return "", nil
+}
+
+// fakeOneTimeTokenRepository keeps one-time tokens in memory, keyed by hash.
+type fakeOneTimeTokenRepository struct {
+	tokens map[string]*domain.OneTimeToken
+	used   map[string]bool
+}
+
+func (repo fakeOneTimeTokenRepository) CreateOneTimeToken(token *domain.OneTimeToken) (*domain.OneTimeToken, error) {
+	repo.tokens[token.TokenHash] = token
+	return token, nil
+}
+
+func (repo fakeOneTimeTokenRepository) ReadOneTimeToken(token_hash, purpose string) (*domain.OneTimeToken, error) {
+	token, ok := repo.tokens[token_hash]
+	if !ok || token.Purpose != purpose || repo.used[token_hash] || time.Now().After(token.ExpiresAt) {
+		return nil, errors.New("token not found")
+	}
+	return token, nil
+}
+
+func (repo fakeOneTimeTokenRepository) ConsumeOneTimeToken(token_hash, purpose string) (*domain.OneTimeToken, error) {
+	token, err := repo.ReadOneTimeToken(token_hash, purpose)
+	if err != nil {
+		return nil, err
+	}
+	repo.used[token_hash] = true
+	return token, nil
+}
+
+func (repo fakeOneTimeTokenRepository) ReadLatestOneTimeToken(user_id, purpose string) (*domain.OneTimeToken, error) {
+	var latest *domain.OneTimeToken
+	for _, token := range repo.tokens {
+		if token.UserId == user_id && token.Purpose == purpose && (latest == nil || token.CreatedAt.After(latest.CreatedAt)) {
+			latest = token
+		}
+	}
+	if latest == nil {
+		return nil, errors.New("token not found")
+	}
+	return latest, nil
+}
+
+func (repo fakeOneTimeTokenRepository) DeleteOneTimeTokens(user_id, purpose string) (string, error) {
+	for hash, token := range repo.tokens {
+		if token.UserId == user_id && token.Purpose == purpose {
+			delete(repo.tokens, hash)
+		}
+	}
+	return "", nil
+}
+
+type fakeLoginNotifier chan string
+
+func (n fakeLoginNotifier) NotifyNewDevice(user *domain.User, session *domain.Session) error {
+	n <- session.Device
+	return nil
+}
+
+type fakeKeyService struct {
+	ports.KeyService
+	key *rsa.PrivateKey
+}
+
+func (svc fakeKeyService) SigningKey() (*domain.SigningKey, error) {
+	return &domain.SigningKey{KeyId: "test-key", Algorithm: "RS256", PrivateKey: svc.key, PublicKey: &svc.key.PublicKey}, nil
+}
+
+func (svc fakeKeyService) VerificationKey(kid string) (*domain.SigningKey, error) {
+	return svc.SigningKey()
+}
+
+type fakeServiceClientRepository struct {
+	clients map[string]*domain.ServiceClient
+}
+
+func (repo fakeServiceClientRepository) CreateServiceClient(client *domain.ServiceClient) (*domain.ServiceClient, error) {
+	repo.clients[client.ClientId] = client
+	return client, nil
+}
+
+func (repo fakeServiceClientRepository) ReadServiceClient(client_id string) (*domain.ServiceClient, error) {
+	if client, ok := repo.clients[client_id]; ok {
+		return client, nil
+	}
+	return nil, errors.New("service client not found")
+}
+
+type fakeRoleService struct {
+	ports.RoleService
+}
+
+func (fakeRoleService) ReadUserRoles(user_id string) ([]string, error) {
+	return []string{domain.RoleUser}, nil
+}
+
+// fakeRoleRepository serves the default roles and keeps role assignments in
+// memory.
+type fakeRoleRepository struct {
+	userRoles map[string][]string
+}
+
+func (repo fakeRoleRepository) ReadRoles() ([]domain.Role, error) {
+	return domain.DefaultRoles, nil
+}
+
+func (repo fakeRoleRepository) ReadUserRoles(user_id string) ([]string, error) {
+	return repo.userRoles[user_id], nil
+}
+
+func (repo fakeRoleRepository) AssignUserRole(user_id, role string) (string, error) {
+	repo.userRoles[user_id] = append(repo.userRoles[user_id], role)
+	return "", nil
+}
+
+func (repo fakeRoleRepository) RemoveUserRole(user_id, role string) (string, error) {
+	roles := []string{}
+	for _, r := range repo.userRoles[user_id] {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	repo.userRoles[user_id] = roles
+	return "", nil
+}
+
+func (repo fakeRoleRepository) CountUsersWithRole(role string) (int, error) {
+	count := 0
+	for _, roles := range repo.userRoles {
+		if containsRole(roles, role) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type fakePersonalAccessTokenService struct {
+	ports.PersonalAccessTokenService
+	tokens map[string]*domain.PersonalAccessToken
+}
+
+func (svc fakePersonalAccessTokenService) AuthenticateToken(token string) (*domain.PersonalAccessToken, error) {
+	if pat, ok := svc.tokens[token]; ok {
+		return pat, nil
+	}
+	return nil, services.ErrInvalidPersonalAccessToken
+}
+
+// fakeImpersonationPolicy lets admins impersonate anyone and records the
+// requests they make.
+type fakeImpersonationPolicy struct {
+	requests *[]string
+}
+
+func (p fakeImpersonationPolicy) AuthorizeImpersonation(admin *domain.Actor, target_id, reason string) error {
+	if !containsRole(admin.Roles, domain.RoleAdmin) {
+		return services.ErrForbidden
+	}
+	return nil
+}
+
+func (p fakeImpersonationPolicy) RecordImpersonatedRequest(admin_id, user_id, request string) {
+	*p.requests = append(*p.requests, admin_id+" as "+user_id+": "+request)
+}
+
+func containsRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeLogger struct{}
+
+func (fakeLogger) SendLog(domain.LogMessage)    {}
+func (fakeLogger) LogDebug(domain.LogMessage)   {}
+func (fakeLogger) LogInfo(domain.LogMessage)    {}
+func (fakeLogger) LogWarning(domain.LogMessage) {}
+func (fakeLogger) LogError(domain.LogMessage)   {}
+
+// fakeLinkedin mimics LinkedIn's OpenID Connect discovery, token, key set
+// and userinfo endpoints.
+type fakeLinkedin struct {
+	server        *httptest.Server
+	signingKey    *rsa.PrivateKey
+	publishedKey  *rsa.PrivateKey
+	codeChallenge string
+	nonce         string
+	tokenRequests int
+	// unverifiedEmail makes the ID token report the email as unverified.
+	unverifiedEmail bool
+}
+
+func newFakeLinkedin(t *testing.T) *fakeLinkedin {
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeLinkedin{signingKey: signingKey, publishedKey: signingKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 fake.issuer(),
+			"authorization_endpoint": fake.server.URL + "/oauth/v2/authorization",
+			"token_endpoint":         fake.server.URL + "/oauth/v2/accessToken",
+			"userinfo_endpoint":      fake.server.URL + "/v2/userinfo",
+			"jwks_uri":               fake.server.URL + "/oauth/openid/jwks",
+		})
+	})
+	mux.HandleFunc("/oauth/openid/jwks", func(w http.ResponseWriter, r *http.Request) {
+		publicKey := fake.publishedKey.PublicKey
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "linkedin-key",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/oauth/v2/accessToken", func(w http.ResponseWriter, r *http.Request) {
+		fake.tokenRequests++
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "valid-code" ||
+			r.PostForm.Get("client_id") != "linkedin-client" ||
+			r.PostForm.Get("client_secret") != "linkedin-secret" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != fake.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            fake.issuer(),
+			"aud":            "linkedin-client",
+			"sub":            "linkedin-sub",
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          fake.nonce,
+			"name":           "Ada Lovelace",
+			"given_name":     "Ada",
+			"family_name":    "Lovelace",
+			"email":          "ada@example.com",
+			"email_verified": !fake.unverifiedEmail,
+		})
+		idToken.Header["kid"] = "linkedin-key"
+		signedIDToken, err := idToken.SignedString(fake.signingKey)
+		if err != nil {
+			t.Error(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "linkedin-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     signedIDToken,
+		})
+	})
+	mux.HandleFunc("/v2/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer linkedin-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":     "linkedin-sub",
+			"picture": "https://media.example.com/ada.jpg",
+		})
+	})
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (fake *fakeLinkedin) issuer() string {
+	return fake.server.URL + "/oauth"
+}
+
+func newLinkedinTestRouter(t *testing.T, svc ports.UserService, fake *fakeLinkedin) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	conf := config.Config{
+		SECRET_KEY:             "test-secret",
+		OAUTH_STATE_TTL:        time.Minute,
+		LINKEDIN_CLIENT_ID:     "linkedin-client",
+		LINKEDIN_CLIENT_SECRET: "linkedin-secret",
+		LINKEDIN_REDIRECT_URL:  "http://localhost:3000/linkedin/oauth2/callback",
+		LINKEDIN_ISSUER:        fake.issuer(),
+		ACCESS_TOKEN_TTL:       time.Minute,
+		FRONTEND_URL:           "http://localhost:3000",
+	}
+	providers, err := oauth.NewRegistry(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewGinHandler(svc, fakeTokenService{}, fakeKeyService{key: key}, fakeRoleService{}, nil, nil, fakeSessionService{}, nil, nil, providers, fakeLogger{}, conf)
+
+	router := gin.New()
+	router.GET("/users/v1/linkedin/login", handler.LinkedinLogin)
+	router.GET("/users/v1/linkedin/login/callback", handler.LinkedinCallback)
+	router.POST("/users/v1/linkedin/login/callback", handler.LinkedinCallback)
+	router.POST("/users/v1/identities/:provider/link", func(ctx *gin.Context) {
+		ctx.Set("user_id", "signed-in-user")
+	}, handler.LinkIdentity)
+	return router
+}
+
+// startLinkedinLogin follows the login endpoint and returns the state sent
+// to LinkedIn along with the state cookie.
+func startLinkedinLogin(t *testing.T, router *gin.Engine, fake *fakeLinkedin) (string, *http.Cookie) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/v1/linkedin/login", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("login returned %d, want %d", w.Code, http.StatusTemporaryRedirect)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := location.Scheme+"://"+location.Host+location.Path, fake.server.URL+"/oauth/v2/authorization"; got != want {
+		t.Fatalf("redirected to %s, want %s", got, want)
+	}
+	query := location.Query()
+	if query.Get("scope") != "openid profile email" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", location.RawQuery)
+	}
+	fake.codeChallenge = query.Get("code_challenge")
+	fake.nonce = query.Get("nonce")
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oauthStateCookie {
+		t.Fatalf("login set cookies %v, want the %s cookie", cookies, oauthStateCookie)
+	}
+	return query.Get("state"), cookies[0]
+}
+
+func postLinkedinCallback(router *gin.Engine, code, state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"code": code, "state": state})
+	req := httptest.NewRequest(http.MethodPost, "/users/v1/linkedin/login/callback", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(cookie)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestLinkedinLoginCreatesUserOnFirstLogin(t *testing.T) {
+	fake := newFakeLinkedin(t)
+	svc := &fakeUserService{users: map[string]*domain.User{}}
+	router := newLinkedinTestRouter(t, svc, fake)
+
+	state, cookie := startLinkedinLogin(t, router, fake)
+	w := postLinkedinCallback(router, "valid-code", state, cookie)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first callback returned %d: %s", w.Code, w.Body.String())
+	}
+	var session struct {
+		AccessToken  string `json:"accessToken"`
+		RefreshToken string `json:"refreshToken"`
+		RedirectTo   string `json:"redirectTo"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.AccessToken == "" || session.RefreshToken != "refresh-linkedin:linkedin-sub" || session.RedirectTo != "http://localhost:3000" {
+		t.Errorf("callback returned session %+v", session)
+	}
+
+	user := svc.users["linkedin:linkedin-sub"]
+	if user == nil {
+		t.Fatalf("no user created, have %v", svc.users)
+	}
+	if user.Firstname != "Ada" || user.Lastname != "Lovelace" || user.Email != "ada@example.com" || !user.EmailVerified {
+		t.Errorf("profile mapped to %+v", user)
+	}
+	if user.ProfileImage != "https://media.example.com/ada.jpg" {
+		t.Errorf("profile image = %q", user.ProfileImage)
+	}
+
+	state, cookie = startLinkedinLogin(t, router, fake)
+	w = postLinkedinCallback(router, "valid-code", state, cookie)
+	if w.Code != http.StatusOK {
+		t.Fatalf("second callback returned %d: %s", w.Code, w.Body.String())
+	}
+	if len(svc.users) != 1 {
+		t.Errorf("second login created another user, have %d", len(svc.users))
+	}
+}
+
+func TestLinkedinLoginIgnoresUnverifiedEmail(t *testing.T) {
+	fake := newFakeLinkedin(t)
+	fake.unverifiedEmail = true
+	svc := &fakeUserService{users: map[string]*domain.User{}}
+	router := newLinkedinTestRouter(t, svc, fake)
+
+	state, cookie := startLinkedinLogin(t, router, fake)
+	if w := postLinkedinCallback(router, "valid-code", state, cookie); w.Code != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", w.Code, w.Body.String())
+	}
+	user := svc.users["linkedin:linkedin-sub"]
+	if user == nil {
+		t.Fatalf("no user created, have %v", svc.users)
+	}
+	if user.Email != "" || user.EmailVerified {
+		t.Errorf("unverified email stored on the user: %+v", user)
+	}
+}
+
+func TestOIDCProviderFromConfigCreatesUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fake := newFakeLinkedin(t)
+	conf := config.Config{
+		SECRET_KEY:       "test-secret",
+		OAUTH_STATE_TTL:  time.Minute,
+		ACCESS_TOKEN_TTL: time.Minute,
+		FRONTEND_URL:     "http://localhost:3000",
+		OIDC_PROVIDERS: []config.OIDCProviderConfig{{
+			Name:         "gitlab",
+			Issuer:       fake.issuer(),
+			ClientID:     "linkedin-client",
+			ClientSecret: "linkedin-secret",
+			RedirectURL:  "http://localhost:3000/oauth/gitlab/callback",
+		}},
+	}
+	providers, err := oauth.NewRegistry(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypter, err := services.NewEnvelopeEncryptionService("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := fakeUserRepository{users: map[string]*domain.User{}}
+	identities := fakeIdentityRepository{identities: map[string]*domain.UserIdentity{}}
+	svc := services.NewUserManagementService(users, nil, nil, identities, nil, fakeLogger{}, nil, nil, encrypter, nil, conf)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewGinHandler(svc, fakeTokenService{}, fakeKeyService{key: key}, fakeRoleService{}, nil, nil, fakeSessionService{}, nil, nil, providers, fakeLogger{}, conf)
+
+	router := gin.New()
+	router.GET("/users/v1/oauth/:provider/login", handler.OAuthLogin)
+	router.POST("/users/v1/oauth/:provider/login/callback", handler.OAuthCallback)
+	login := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/v1/oauth/gitlab/login", nil))
+		location, err := url.Parse(w.Header().Get("Location"))
+		if err != nil || w.Code != http.StatusTemporaryRedirect {
+			t.Fatalf("login returned %d to %q", w.Code, w.Header().Get("Location"))
+		}
+		fake.codeChallenge = location.Query().Get("code_challenge")
+		fake.nonce = location.Query().Get("nonce")
+
+		body, _ := json.Marshal(map[string]string{"code": "valid-code", "state": location.Query().Get("state")})
+		req := httptest.NewRequest(http.MethodPost, "/users/v1/oauth/gitlab/login/callback", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range w.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := login(); w.Code != http.StatusOK {
+		t.Fatalf("first login returned %d: %s", w.Code, w.Body.String())
+	}
+	if len(users.users) != 1 {
+		t.Fatalf("first login created %d users, want 1", len(users.users))
+	}
+	for _, user := range users.users {
+		if user.Email != "ada@example.com" || !user.EmailVerified || user.Password != "" {
+			t.Errorf("created user %+v", user)
+		}
+		if identity, ok := identities.identities["gitlab:linkedin-sub"]; !ok || identity.UserId != user.UserId {
+			t.Errorf("identity not linked to the new user: %v", identities.identities)
+		}
+	}
+
+	if w := login(); w.Code != http.StatusOK {
+		t.Fatalf("second login returned %d: %s", w.Code, w.Body.String())
+	}
+	if len(users.users) != 1 {
+		t.Errorf("second login created another user, have %d", len(users.users))
+	}
+
+	// Signing up directly still needs a password, whatever the body claims.
+	if _, err := svc.CreateUser(&domain.User{Firstname: "Eve", GitHubId: "42"}); err != services.ErrEmptyPassword {
+		t.Errorf("CreateUser without a password returned %v, want %v", err, services.ErrEmptyPassword)
+	}
+}
+
+func TestLinkedinRedirectCallbackStartsBrowserSession(t *testing.T) {
+	fake := newFakeLinkedin(t)
+	svc := &fakeUserService{users: map[string]*domain.User{}}
+	router := newLinkedinTestRouter(t, svc, fake)
+
+	state, cookie := startLinkedinLogin(t, router, fake)
+	query := url.Values{"code": {"valid-code"}, "state": {state}}
+	req := httptest.NewRequest(http.MethodGet, "/users/v1/linkedin/login/callback?"+query.Encode(), nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "http://localhost:3000" {
+		t.Fatalf("callback returned %d to %q, want a redirect to the frontend", w.Code, w.Header().Get("Location"))
+	}
+	cookies := map[string]string{}
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name], _ = url.QueryUnescape(c.Value)
+	}
+	if cookies[accessTokenCookie] == "" || cookies[refreshTokenCookie] != "refresh-linkedin:linkedin-sub" {
+		t.Errorf("callback set cookies %v", cookies)
+	}
+}
+
+func TestLinkedinCallbackRejectsBadState(t *testing.T) {
+	fake := newFakeLinkedin(t)
+	svc := &fakeUserService{users: map[string]*domain.User{}}
+	router := newLinkedinTestRouter(t, svc, fake)
+
+	_, cookie := startLinkedinLogin(t, router, fake)
+	for _, state := range []string{"", "forged-state"} {
+		w := postLinkedinCallback(router, "valid-code", state, cookie)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("state %q: callback returned %d, want %d", state, w.Code, http.StatusBadRequest)
+		}
+	}
+	if fake.tokenRequests != 0 {
+		t.Errorf("code exchanged %d times despite a bad state", fake.tokenRequests)
+	}
+	if len(svc.users) != 0 {
+		t.Errorf("user created despite a bad state")
+	}
+}
+
+func TestLinkedinCallbackRejectsInvalidCode(t *testing.T) {
+	fake := newFakeLinkedin(t)
+	svc := &fakeUserService{users: map[string]*domain.User{}}
+	router := newLinkedinTestRouter(t, svc, fake)
+
+	state, cookie := startLinkedinLogin(t, router, fake)
+	w := postLinkedinCallback(router, "stolen-code", state, cookie)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("callback returned %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(svc.users) != 0 {
+		t.Errorf("user created from an invalid code")
+	}
+}
+
+func TestLinkedinCallbackRejectsForgedIDToken(t *testing.T) {
+	fake := newFakeLinkedin(t)
+	forgedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.signingKey = forgedKey
+	svc := &fakeUserService{users: map[string]*domain.User{}}
+	router := newLinkedinTestRouter(t, svc, fake)
+
+	state, cookie := startLinkedinLogin(t, router, fake)
+	w := postLinkedinCallback(router, "valid-code", state, cookie)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("callback returned %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(svc.users) != 0 {
+		t.Errorf("user created from a forged id token")
+	}
+}
+
+func TestLinkedinCallbackLinksSignedInUser(t *testing.T) {
+	fake := newFakeLinkedin(t)
+	svc := &fakeUserService{users: map[string]*domain.User{}, linked: map[string]string{}}
+	router := newLinkedinTestRouter(t, svc, fake)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users/v1/identities/linkedin/link", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("link returned %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	location, err := url.Parse(response.AuthorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.codeChallenge = location.Query().Get("code_challenge")
+	fake.nonce = location.Query().Get("nonce")
+
+	w = postLinkedinCallback(router, "valid-code", location.Query().Get("state"), w.Result().Cookies()[0])
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", w.Code, w.Body.String())
+	}
+	if got := svc.linked["linkedin:linkedin-sub"]; got != "signed-in-user" {
+		t.Errorf("identity linked to %q, want signed-in-user", got)
+	}
+	if len(svc.users) != 0 {
+		t.Errorf("linking created a user")
+	}
+}
+
+func TestUpdateUserOnlyChangesProfileFields(t *testing.T) {
+	svc := &fakeUserService{users: map[string]*domain.User{
+		"user-1": {UserId: "user-1", Firstname: "Ada", Lastname: "Lovelace", Email: "ada@example.com", Password: "hash"},
+	}}
+	handler := NewGinHandler(svc, nil, nil, nil, nil, nil, nil, nil, nil, nil, fakeLogger{}, config.Config{})
+	router := gin.New()
+	router.PUT("/users/v1/:user_id", handler.UpdateUser)
+
+	body := `{"user_id": "user-2", "firstname": "Grace", "email": "grace@example.com", "email_verified": true, "password": "plain", "totp_enabled": true}`
+	req := httptest.NewRequest(http.MethodPut, "/users/v1/user-1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("update returned %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, ok := svc.users["user-2"]; ok {
+		t.Errorf("the body's user_id was used")
+	}
+	want := domain.User{UserId: "user-1", Firstname: "Grace", Lastname: "Lovelace", Email: "ada@example.com", Password: "hash"}
+	if user := svc.users["user-1"]; user.Firstname != want.Firstname || user.Lastname != want.Lastname || user.Email != want.Email || user.EmailVerified || user.Password != want.Password || user.TOTPEnabled {
+		t.Errorf("stored %+v, want %+v", *user, want)
+	}
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	patSvc := fakePersonalAccessTokenService{tokens: map[string]*domain.PersonalAccessToken{
+		"ntl_pat_profile": {TokenId: "pat-1", UserId: "user-1", Scopes: []string{domain.ScopeProfile}},
+		"ntl_pat_none":    {TokenId: "pat-2", UserId: "user-1"},
+		"ntl_pat_gone":    {TokenId: "pat-3", UserId: "deleted-user", Scopes: []string{domain.ScopeProfile}},
+		"ntl_pat_unsure":  {TokenId: "pat-4", UserId: "user-2", Scopes: []string{domain.ScopeProfile}},
+	}}
+	svc := &fakeUserService{users: map[string]*domain.User{
+		"user-1": {UserId: "user-1", EmailVerified: true},
+		"user-2": {UserId: "user-2"},
+	}}
+	middleware := NewMiddleware(svc, fakeTokenService{}, fakeKeyService{}, fakeRoleService{}, patSvc, nil, nil, fakeLogger{}, config.Config{})
+
+	router := gin.New()
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+	router.PUT("/users/v1/:user_id", middleware.Authorize, middleware.RequireScope(domain.ScopeProfile), ok)
+	router.POST("/users/v1/password", middleware.Authorize, middleware.RequireSession, ok)
+	router.GET("/users/v1/email-verified", middleware.Authorize, func(ctx *gin.Context) {
+		if ctx.GetBool("email_verified") {
+			ctx.Status(http.StatusOK)
+			return
+		}
+		ctx.Status(http.StatusForbidden)
+	})
+
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodPut, "/users/v1/user-1", "ntl_pat_profile", http.StatusOK},
+		{http.MethodPut, "/users/v1/user-1", "ntl_pat_none", http.StatusForbidden},
+		{http.MethodPut, "/users/v1/user-1", "ntl_pat_unknown", http.StatusUnauthorized},
+		{http.MethodPost, "/users/v1/password", "ntl_pat_profile", http.StatusForbidden},
+		{http.MethodPut, "/users/v1/deleted-user", "ntl_pat_gone", http.StatusUnauthorized},
+		// email_verified comes from the owner's account, not the token.
+		{http.MethodGet, "/users/v1/email-verified", "ntl_pat_profile", http.StatusOK},
+		{http.MethodGet, "/users/v1/email-verified", "ntl_pat_unsure", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.want {
+			t.Errorf("%s %s with %s returned %d, want %d", test.method, test.path, test.token, w.Code, test.want)
+		}
+	}
+}
+
+func TestServiceTokenGuardsInternalRoutes(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySvc := fakeKeyService{key: key}
+	serviceSvc := services.NewServiceClientManagementService(fakeServiceClientRepository{clients: map[string]*domain.ServiceClient{}}, keySvc, fakeLogger{}, "users-service", time.Minute)
+	client, secret, err := serviceSvc.RegisterClient("articles", []string{domain.ScopeInternalUsersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := config.Config{ACCESS_TOKEN_TTL: time.Minute}
+	handler := NewGinHandler(nil, fakeTokenService{}, keySvc, fakeRoleService{}, nil, serviceSvc, nil, nil, nil, nil, fakeLogger{}, conf)
+	middleware := NewMiddleware(nil, fakeTokenService{}, keySvc, fakeRoleService{}, nil, serviceSvc, nil, fakeLogger{}, conf)
+
+	router := gin.New()
+	router.POST("/users/v1/oauth/token", handler.ServiceToken)
+	router.GET("/users/v1/internal/users/:user_id", middleware.RequireServiceToken(domain.ScopeInternalUsersRead), func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString("client_id"))
+	})
+
+	requestToken := func(secret string) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {"client_credentials"}}
+		req := httptest.NewRequest(http.MethodPost, "/users/v1/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(client.ClientId, secret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	callInternal := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users/v1/internal/users/user-1", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := requestToken("wrong-secret"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("token with a wrong secret returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w := requestToken(secret)
+	if w.Code != http.StatusOK {
+		t.Fatalf("token returned %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		AccessToken string `json:"access_token"`
+		Scope       string `json:"scope"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Scope != domain.ScopeInternalUsersRead {
+		t.Errorf("token granted scope %q, want %q", response.Scope, domain.ScopeInternalUsersRead)
+	}
+
+	if w := callInternal(response.AccessToken); w.Code != http.StatusOK || w.Body.String() != client.ClientId {
+		t.Errorf("internal route returned %d %q, want %d %q", w.Code, w.Body.String(), http.StatusOK, client.ClientId)
+	}
+
+	userToken, err := middleware.GenerateMFAToken("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := callInternal(userToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("internal route with a user token returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	outbound, err := serviceSvc.ServiceToken(domain.ScopeArticlesRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := callInternal(outbound); w.Code != http.StatusForbidden {
+		t.Errorf("internal route without the scope returned %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestIntrospectAndUserInfo(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySvc := fakeKeyService{key: key}
+	svc := &fakeUserService{users: map[string]*domain.User{
+		"user-1": {UserId: "user-1", Firstname: "Ada", Lastname: "Lovelace", Handle: "ada", Email: "ada@example.com", EmailVerified: true},
+	}}
+	serviceSvc := services.NewServiceClientManagementService(fakeServiceClientRepository{clients: map[string]*domain.ServiceClient{}}, keySvc, fakeLogger{}, "users-service", time.Minute)
+	conf := config.Config{ACCESS_TOKEN_TTL: time.Minute}
+	handler := NewGinHandler(svc, fakeTokenService{}, keySvc, fakeRoleService{}, nil, serviceSvc, nil, nil, nil, nil, fakeLogger{}, conf)
+	middleware := NewMiddleware(svc, fakeTokenService{}, keySvc, fakeRoleService{}, nil, serviceSvc, nil, fakeLogger{}, conf)
+
+	router := gin.New()
+	router.POST("/users/v1/introspect", handler.Introspect)
+	router.GET("/users/v1/userinfo", middleware.Authorize, handler.UserInfo)
+
+	accessToken, err := middleware.GenerateToken("user-1", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	introspect := func(token string) map[string]interface{} {
+		form := url.Values{"token": {token}}
+		req := httptest.NewRequest(http.MethodPost, "/users/v1/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("introspect returned %d: %s", w.Code, w.Body.String())
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if response := introspect(accessToken); response["active"] != true || response["sub"] != "user-1" {
+		t.Errorf("introspecting an access token returned %v", response)
+	}
+	if response := introspect("not-a-token"); response["active"] != false || len(response) != 1 {
+		t.Errorf("introspecting an invalid token returned %v", response)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/v1/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("userinfo returned %d: %s", w.Code, w.Body.String())
+	}
+	var info map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info["sub"] != "user-1" || info["name"] != "Ada Lovelace" || info["preferred_username"] != "ada" {
+		t.Errorf("userinfo returned %v", info)
+	}
+	if _, ok := info["email"]; ok {
+		t.Errorf("userinfo exposed the email address: %v", info)
+	}
+}
+
+func TestRevokedSessionRejectsAccessToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &domain.User{UserId: "user-1", Firstname: "Ada", Email: "ada@example.com"}
+	repo := fakeSessionRepository{sessions: map[string]*domain.Session{}, revoked: map[string]bool{}}
+	tokenSvc := services.NewTokenManagementService(repo, memory.NewRevocationStore(), fakeLogger{}, time.Minute, time.Hour)
+	notifier := make(fakeLoginNotifier, 1)
+	sessionSvc := services.NewSessionManagementService(repo, fakeUserRepository{users: map[string]*domain.User{"user-1": user}}, tokenSvc, notifier, fakeLogger{}, time.Hour)
+
+	conf := config.Config{ACCESS_TOKEN_TTL: time.Minute}
+	svc := &fakeUserService{users: map[string]*domain.User{"user-1": user}}
+	middleware := NewMiddleware(svc, tokenSvc, fakeKeyService{key: key}, fakeRoleService{}, nil, nil, nil, fakeLogger{}, conf)
+	router := gin.New()
+	router.GET("/users/v1/userinfo", middleware.Authorize, func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString("sid"))
+	})
+
+	const firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"
+	const safari = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Version/17.0 Mobile/15E148 Safari/604.1"
+	if _, err := sessionSvc.RecordSession("user-1", "session-1", firefox, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sessionSvc.RecordSession("user-1", "session-2", safari, "192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case device := <-notifier:
+		if device != "Safari on iOS" {
+			t.Errorf("notified about %q, want Safari on iOS", device)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("sign-in from a new device was not notified")
+	}
+
+	call := func(session_id string) *httptest.ResponseRecorder {
+		token, err := middleware.GenerateToken("user-1", session_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/users/v1/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := call("session-1"); w.Code != http.StatusOK || w.Body.String() != "session-1" {
+		t.Fatalf("request before revoking returned %d %q", w.Code, w.Body.String())
+	}
+	if err := sessionSvc.RevokeSession("user-1", "session-1"); err != nil {
+		t.Fatal(err)
+	}
+	if w := call("session-1"); w.Code != http.StatusUnauthorized {
+		t.Errorf("request on a revoked session returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := call("session-2"); w.Code != http.StatusOK {
+		t.Errorf("request on another session returned %d, want %d", w.Code, http.StatusOK)
+	}
+	if err := sessionSvc.RevokeSession("user-1", "session-1"); err != services.ErrSessionMissing {
+		t.Errorf("revoking a revoked session returned %v, want %v", err, services.ErrSessionMissing)
+	}
+
+	// A token issued moments before signing out everywhere, even in the
+	// same second, is rejected. A later sign-in works.
+	token, err := middleware.GenerateToken("user-1", "session-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := sessionSvc.RevokeAllSessions("user-1"); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/users/v1/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("token issued before signing out everywhere returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if sessions, _ := sessionSvc.ReadSessions("user-1"); len(sessions) != 0 {
+		t.Errorf("%d sessions still active after signing out everywhere", len(sessions))
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := sessionSvc.RecordSession("user-1", "session-3", firefox, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if w := call("session-3"); w.Code != http.StatusOK {
+		t.Errorf("request after signing in again returned %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	outboxDir := t.TempDir()
+	outbox, err := mailer.NewOutboxMailer(outboxDir, "no-reply@notelify.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := fakeUserRepository{users: map[string]*domain.User{
+		"user-1": {UserId: "user-1", Firstname: "Ada", Email: "ada@example.com", EmailVerified: true},
+		"user-2": {UserId: "user-2", Firstname: "Grace", Email: "grace@example.com"},
+		"user-3": {UserId: "user-3", Firstname: "Joan", Email: "joan@example.com", EmailVerified: true},
+	}}
+	tokens := fakeOneTimeTokenRepository{tokens: map[string]*domain.OneTimeToken{}, used: map[string]bool{}}
+	conf := config.Config{
+		FRONTEND_URL:        "http://localhost:3000",
+		ACCESS_TOKEN_TTL:    time.Minute,
+		MAGIC_LINK_TTL:      time.Minute * 15,
+		MAGIC_LINK_COOLDOWN: time.Minute,
+	}
+	svc := services.NewUserManagementService(users, tokens, nil, nil, outbox, fakeLogger{}, nil, nil, nil, nil, conf)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewGinHandler(svc, fakeTokenService{}, fakeKeyService{key: key}, fakeRoleService{}, nil, nil, fakeSessionService{}, nil, nil, nil, fakeLogger{}, conf)
+
+	router := gin.New()
+	router.POST("/users/v1/login/magic-link", handler.RequestMagicLink)
+	router.POST("/users/v1/login/magic-link/verify", handler.MagicLinkLogin)
+	post := func(path string, body map[string]string) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	known := post("/users/v1/login/magic-link", map[string]string{"email": "ada@example.com"})
+	unknown := post("/users/v1/login/magic-link", map[string]string{"email": "nobody@example.com"})
+	if known.Code != http.StatusAccepted || known.Code != unknown.Code || known.Body.String() != unknown.Body.String() {
+		t.Fatalf("known email returned %d %s, unknown email returned %d %s", known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+	}
+	// Unverified addresses get the same response but no link.
+	if unverified := post("/users/v1/login/magic-link", map[string]string{"email": "grace@example.com"}); unverified.Code != known.Code || unverified.Body.String() != known.Body.String() {
+		t.Errorf("unverified email returned %d %s", unverified.Code, unverified.Body.String())
+	}
+	// A second request inside the cooldown is accepted but sends nothing.
+	post("/users/v1/login/magic-link", map[string]string{"email": "ada@example.com"})
+	post("/users/v1/login/magic-link", map[string]string{"email": "joan@example.com"})
+
+	var messages []string
+	for deadline := time.Now().Add(time.Second); len(messages) < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		messages, _ = filepath.Glob(filepath.Join(outboxDir, "*.eml"))
+	}
+	// Give an unexpected message time to arrive.
+	time.Sleep(50 * time.Millisecond)
+	messages, _ = filepath.Glob(filepath.Join(outboxDir, "*.eml"))
+	if len(messages) != 2 {
+		t.Fatalf("outbox has %d messages, want 2", len(messages))
+	}
+	links := map[string]string{}
+	for _, path := range messages {
+		message, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		match := regexp.MustCompile(`/login/magic\?token=(\S+)`).FindSubmatch(message)
+		if match == nil {
+			t.Fatalf("no sign-in link in message:\n%s", message)
+		}
+		token, err := url.QueryUnescape(string(match[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, email := range []string{"ada@example.com", "joan@example.com"} {
+			if strings.Contains(string(message), "To: "+email) {
+				links[email] = token
+			}
+		}
+	}
+	token := links["ada@example.com"]
+
+	if w := post("/users/v1/login/magic-link/verify", map[string]string{"token": token}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "accessToken") {
+		t.Fatalf("sign-in link returned %d: %s", w.Code, w.Body.String())
+	}
+	if w := post("/users/v1/login/magic-link/verify", map[string]string{"token": token}); w.Code != http.StatusUnauthorized {
+		t.Errorf("reused sign-in link returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// A link sent before the address stopped being verified is refused.
+	users.users["user-3"].EmailVerified = false
+	if w := post("/users/v1/login/magic-link/verify", map[string]string{"token": links["joan@example.com"]}); w.Code != http.StatusUnauthorized {
+		t.Errorf("sign-in link for an unverified email returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestImpersonationIsAuditedAndLimited(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &fakeUserService{users: map[string]*domain.User{
+		"admin-1": {UserId: "admin-1", Firstname: "Grace"},
+		"user-1":  {UserId: "user-1", Firstname: "Ada"},
+	}}
+	var requests []string
+	policy := fakeImpersonationPolicy{requests: &requests}
+	conf := config.Config{ACCESS_TOKEN_TTL: time.Minute, IMPERSONATION_TTL: time.Minute}
+	handler := NewGinHandler(svc, fakeTokenService{}, fakeKeyService{key: key}, fakeRoleService{}, nil, nil, nil, policy, nil, nil, fakeLogger{}, conf)
+	middleware := NewMiddleware(svc, fakeTokenService{}, fakeKeyService{key: key}, fakeRoleService{}, nil, nil, policy, fakeLogger{}, conf)
+
+	router := gin.New()
+	router.POST("/users/v1/admin/users/:user_id/impersonate", func(ctx *gin.Context) {
+		ctx.Set("user_id", "admin-1")
+		ctx.Set("roles", []string{domain.RoleAdmin})
+	}, handler.ImpersonateUser)
+	router.GET("/users/v1/whoami", middleware.Authorize, func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString("user_id")+" "+ctx.GetString("impersonator_id"))
+	})
+	router.DELETE("/users/v1/:user_id", middleware.Authorize, middleware.BlockImpersonation, func(ctx *gin.Context) {
+		ctx.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/users/v1/admin/users/user-1/impersonate", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("impersonating without a reason returned %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/users/v1/admin/users/user-1/impersonate", strings.NewReader(`{"reason":"support ticket 42"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("impersonate returned %d: %s", w.Code, w.Body.String())
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("impersonate replaced the admin's session cookies")
+	}
+	var response struct {
+		AccessToken string `json:"accessToken"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+response.AccessToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := call(http.MethodGet, "/users/v1/whoami"); w.Code != http.StatusOK || w.Body.String() != "user-1 admin-1" {
+		t.Errorf("impersonated request returned %d %q, want \"user-1 admin-1\"", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodDelete, "/users/v1/user-1"); w.Code != http.StatusForbidden {
+		t.Errorf("deleting while impersonating returned %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	want := []string{
+		"admin-1 as user-1: GET /users/v1/whoami 200",
+		"admin-1 as user-1: DELETE /users/v1/user-1 403",
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("audited requests %q, want %q", requests, want)
+	}
+}
+
+func TestCookieAuthRequiresCSRFToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &fakeUserService{users: map[string]*domain.User{"user-1": {UserId: "user-1", Firstname: "Ada"}}}
+	conf := config.Config{
+		ACCESS_TOKEN_TTL:  time.Minute,
+		REFRESH_TOKEN_TTL: time.Hour,
+		COOKIE_DOMAIN:     "notelify.test",
+		COOKIE_SECURE:     true,
+		COOKIE_SAMESITE:   http.SameSiteStrictMode,
+	}
+	handler := NewGinHandler(svc, fakeTokenService{}, fakeKeyService{key: key}, fakeRoleService{}, nil, nil, fakeSessionService{}, nil, nil, nil, fakeLogger{}, conf).(handler)
+	middleware := NewMiddleware(svc, fakeTokenService{}, fakeKeyService{key: key}, fakeRoleService{}, nil, nil, nil, fakeLogger{}, conf)
+
+	router := gin.New()
+	router.POST("/users/v1/login", func(ctx *gin.Context) {
+		handler.issueTokens(ctx, "user-1")
+	})
+	router.GET("/users/v1/userinfo", middleware.Authorize, func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString("user_id"))
+	})
+	router.PUT("/users/v1/:user_id", middleware.Authorize, func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString("user_id"))
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users/v1/login", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("login returned %d: %s", w.Code, w.Body.String())
+	}
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+		if cookie.Domain != "notelify.test" || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode {
+			t.Errorf("cookie %s has domain %q, secure %v, samesite %v", cookie.Name, cookie.Domain, cookie.Secure, cookie.SameSite)
+		}
+	}
+	access, csrf := cookies[accessTokenCookie], cookies[csrfTokenCookie]
+	if access == nil || csrf == nil || !access.HttpOnly || csrf.HttpOnly {
+		t.Fatalf("login set cookies %v", w.Result().Cookies())
+	}
+
+	call := func(method, csrfHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/users/v1/user-1", nil)
+		if method == http.MethodGet {
+			req = httptest.NewRequest(method, "/users/v1/userinfo", nil)
+		}
+		req.AddCookie(&http.Cookie{Name: access.Name, Value: access.Value})
+		req.AddCookie(&http.Cookie{Name: csrf.Name, Value: csrf.Value})
+		if csrfHeader != "" {
+			req.Header.Set(csrfTokenHeader, csrfHeader)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	if w := call(http.MethodGet, ""); w.Code != http.StatusOK || w.Body.String() != "user-1" {
+		t.Errorf("GET with the session cookie returned %d %q", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodPut, ""); w.Code != http.StatusForbidden {
+		t.Errorf("PUT without a CSRF token returned %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := call(http.MethodPut, "forged"); w.Code != http.StatusForbidden {
+		t.Errorf("PUT with a wrong CSRF token returned %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := call(http.MethodPut, csrf.Value); w.Code != http.StatusOK {
+		t.Errorf("PUT with the CSRF token returned %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestResendVerificationEmailDoesNotRevealAccounts(t *testing.T) {
+	outbox, err := mailer.NewOutboxMailer(t.TempDir(), "no-reply@notelify.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := fakeUserRepository{users: map[string]*domain.User{
+		"user-1": {UserId: "user-1", Firstname: "Ada", Email: "ada@example.com"},
+		"user-2": {UserId: "user-2", Firstname: "Grace", Email: "grace@example.com", EmailVerified: true},
+	}}
+	tokens := fakeOneTimeTokenRepository{tokens: map[string]*domain.OneTimeToken{}, used: map[string]bool{}}
+	conf := config.Config{EMAIL_VERIFY_TTL: time.Hour, EMAIL_VERIFY_COOLDOWN: time.Minute}
+	svc := services.NewUserManagementService(users, tokens, nil, nil, outbox, fakeLogger{}, nil, nil, nil, nil, conf)
+	handler := NewGinHandler(svc, nil, nil, nil, nil, nil, nil, nil, nil, nil, fakeLogger{}, conf)
+
+	router := gin.New()
+	router.POST("/users/v1/verify-email/resend", handler.ResendVerificationEmail)
+	resend := func(email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users/v1/verify-email/resend", strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := resend("ada@example.com")
+	// The second request falls inside the cooldown.
+	for _, email := range []string{"ada@example.com", "grace@example.com", "nobody@example.com"} {
+		w := resend(email)
+		if w.Code != first.Code || w.Body.String() != first.Body.String() || w.Header().Get("Retry-After") != "" {
+			t.Errorf("resend for %s returned %d %s, want %d %s", email, w.Code, w.Body.String(), first.Code, first.Body.String())
+		}
+	}
+	if first.Code != http.StatusAccepted {
+		t.Errorf("resend returned %d, want %d", first.Code, http.StatusAccepted)
+	}
+}
+
+func TestUnlockAccountRequiresAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &fakeUserService{users: map[string]*domain.User{
+		"user-1":  {UserId: "user-1", Firstname: "Ada"},
+		"admin-1": {UserId: "admin-1", Firstname: "Grace"},
+	}}
+	roleSvc := services.NewRoleManagementService(fakeRoleRepository{userRoles: map[string][]string{"admin-1": {domain.RoleAdmin}}}, fakeLogger{})
+	conf := config.Config{ACCESS_TOKEN_TTL: time.Minute, CORS_ALLOWED_ORIGINS: []string{"http://localhost:3000"}}
+	loginSvc := services.NewLoginProtectionService(memory.NewLoginAttemptStore(), fakeLogger{}, conf)
+	router := newRouter(svc, fakeTokenService{}, fakeKeyService{key: key}, roleSvc, nil, nil, nil, nil, loginSvc, nil, fakeLogger{}, conf)
+	middleware := NewMiddleware(svc, fakeTokenService{}, fakeKeyService{key: key}, roleSvc, nil, nil, nil, fakeLogger{}, conf)
+
+	unlock := func(user_id string) int {
+		req := httptest.NewRequest(http.MethodPost, "/users/v1/admin/unlock", strings.NewReader(`{"email":"ada@example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if user_id != "" {
+			token, err := middleware.GenerateToken(user_id, "session-"+user_id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := unlock(""); code != http.StatusUnauthorized {
+		t.Errorf("unlock without a token returned %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := unlock("user-1"); code != http.StatusForbidden {
+		t.Errorf("unlock by a plain user returned %d, want %d", code, http.StatusForbidden)
+	}
+	if code := unlock("admin-1"); code != http.StatusOK {
+		t.Errorf("unlock by an admin returned %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestAdminRoutesForbidPlainUsers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &fakeUserService{users: map[string]*domain.User{
+		"user-1": {UserId: "user-1", Firstname: "Ada"},
+	}}
+	roleSvc := services.NewRoleManagementService(fakeRoleRepository{userRoles: map[string][]string{}}, fakeLogger{})
+	conf := config.Config{ACCESS_TOKEN_TTL: time.Minute, CORS_ALLOWED_ORIGINS: []string{"http://localhost:3000"}}
+	router := newRouter(svc, fakeTokenService{}, fakeKeyService{key: key}, roleSvc, nil, nil, nil, nil, nil, nil, fakeLogger{}, conf)
+	middleware := NewMiddleware(svc, fakeTokenService{}, fakeKeyService{key: key}, roleSvc, nil, nil, nil, fakeLogger{}, conf)
+	token, err := middleware.GenerateToken("user-1", "session-user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/users/v1/"},
+		{http.MethodDelete, "/users/v1/"},
+		{http.MethodPost, "/users/v1/admin/unlock"},
+		{http.MethodGet, "/users/v1/admin/roles"},
+		{http.MethodGet, "/users/v1/admin/users/user-1/roles"},
+		{http.MethodPost, "/users/v1/admin/users/user-1/roles"},
+		{http.MethodDelete, "/users/v1/admin/users/user-1/roles/user"},
+		{http.MethodPost, "/users/v1/admin/users/user-1/impersonate"},
+	}
+	for _, route := range routes {
+		req := httptest.NewRequest(route.method, route.path, strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s by a plain user returned %d, want %d", route.method, route.path, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestReadUserReturnsPublicProfile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &fakeUserService{users: map[string]*domain.User{
+		"user-1": {UserId: "user-1", Firstname: "Ada", Lastname: "Lovelace", Email: "ada@example.com", Handle: "ada", TOTPEnabled: true},
+	}}
+	conf := config.Config{ACCESS_TOKEN_TTL: time.Minute, CORS_ALLOWED_ORIGINS: []string{"http://localhost:3000"}}
+	router := newRouter(svc, fakeTokenService{}, fakeKeyService{key: key}, nil, nil, nil, nil, nil, nil, nil, fakeLogger{}, conf)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/v1/user-1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("read user returned %d %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "ada@example.com") {
+		t.Errorf("public profile includes the email address: %s", w.Body.String())
+	}
+	var profile domain.UserInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.Sub != "user-1" || profile.Name != "Ada Lovelace" || profile.PreferredUsername != "ada" {
+		t.Errorf("profile = %+v", profile)
+	}
+}
+
+// fakeSigningKeyRepository keeps signing keys in memory.
+type fakeSigningKeyRepository struct {
+	keys map[string]domain.SigningKey
+}
+
+func (repo fakeSigningKeyRepository) CreateSigningKey(key *domain.SigningKey) (*domain.SigningKey, error) {
+	repo.keys[key.KeyId] = *key
+	return key, nil
+}
+
+func (repo fakeSigningKeyRepository) ReadSigningKeys() ([]domain.SigningKey, error) {
+	keys := []domain.SigningKey{}
+	for _, key := range repo.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (repo fakeSigningKeyRepository) DeleteExpiredSigningKeys() (string, error) {
+	return "", nil
+}
+
+func TestJWKSAndKeyRotation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := fakeSigningKeyRepository{keys: map[string]domain.SigningKey{}}
+	keySvc, err := services.NewKeyManagementService(repo, fakeLogger{}, "RS256", 200*time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &fakeUserService{users: map[string]*domain.User{"user-1": {UserId: "user-1"}}}
+	conf := config.Config{ACCESS_TOKEN_TTL: time.Minute, CORS_ALLOWED_ORIGINS: []string{"http://localhost:3000"}}
+	router := newRouter(svc, fakeTokenService{}, keySvc, fakeRoleService{}, nil, nil, nil, nil, nil, nil, fakeLogger{}, conf)
+	middleware := NewMiddleware(svc, fakeTokenService{}, keySvc, fakeRoleService{}, nil, nil, nil, fakeLogger{}, conf)
+
+	userinfo := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/users/v1/userinfo", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	oldToken, err := middleware.GenerateToken("user-1", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := keySvc.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rotation interval is shorter than the prepublish window, so the
+	// next key is published now and signs once the first rotates out.
+	if err := keySvc.RotateSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("jwks returned %d", w.Code)
+	}
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("jwks has %d keys, want 2: %s", len(jwks.Keys), w.Body.String())
+	}
+	for _, jwk := range jwks.Keys {
+		if jwk["kty"] != "RSA" || jwk["alg"] != "RS256" || jwk["use"] != "sig" || jwk["kid"] == "" || jwk["n"] == "" || jwk["e"] != "AQAB" {
+			t.Errorf("unexpected jwk %v", jwk)
+		}
+		if _, ok := jwk["d"]; ok {
+			t.Errorf("jwk %s includes the private exponent", jwk["kid"])
+		}
+	}
+	if jwks.Keys[0]["kid"] != old.KeyId && jwks.Keys[1]["kid"] != old.KeyId {
+		t.Errorf("current key %s is not published", old.KeyId)
+	}
+
+	time.Sleep(time.Until(old.RotatesAt) + 10*time.Millisecond)
+	newToken, err := middleware.GenerateToken("user-1", "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := new(jwt.Parser).ParseUnverified(newToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header["kid"] == old.KeyId {
+		t.Errorf("still signing with the retired key")
+	}
+	if code := userinfo(newToken); code != http.StatusOK {
+		t.Errorf("token signed with the new key returned %d, want %d", code, http.StatusOK)
+	}
+	if code := userinfo(oldToken); code != http.StatusOK {
+		t.Errorf("token signed with the retired key returned %d, want %d", code, http.StatusOK)
+	}
+
+	// A token naming a kid the service never issued is rejected, even when
+	// signed with a valid key.
+	parts := strings.Split(oldToken, ".")
+	header, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	forgedHeader := strings.Replace(string(header), old.KeyId, "forged-kid", 1)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(forgedHeader)) + "." + parts[1] + "." + parts[2]
+	if code := userinfo(forged); code != http.StatusUnauthorized {
+		t.Errorf("token with an unknown kid returned %d, want %d", code, http.StatusUnauthorized)
+	}
+}
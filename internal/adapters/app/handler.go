@@ -12,50 +12,109 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func InitGinRoutes(svc ports.UserService, logger ports.LoggingService, conf config.Config) {
+func InitGinRoutes(svc ports.UserService, tokenSvc ports.TokenService, keySvc ports.KeyService, roleSvc ports.RoleService, patSvc ports.PersonalAccessTokenService, serviceSvc ports.ServiceClientService, sessionSvc ports.SessionService, policy ports.ImpersonationPolicy, loginSvc ports.LoginProtectionService, providers ports.IdentityProviderRegistry, logger ports.LoggingService, conf config.Config) {
 	gin.SetMode(gin.DebugMode)
 
+	router := newRouter(svc, tokenSvc, keySvc, roleSvc, patSvc, serviceSvc, sessionSvc, policy, loginSvc, providers, logger, conf)
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Server running on port 0.0.0.0:%s", conf.SERVER_PORT),
+	}
+	logger.LogError(logEntry)
+	log.Printf("Server running on port 0.0.0.0:%s", conf.SERVER_PORT)
+	router.Run(fmt.Sprintf("0.0.0.0:%s", conf.SERVER_PORT))
+}
+
+// newRouter registers every route along with the middleware guarding it.
+func newRouter(svc ports.UserService, tokenSvc ports.TokenService, keySvc ports.KeyService, roleSvc ports.RoleService, patSvc ports.PersonalAccessTokenService, serviceSvc ports.ServiceClientService, sessionSvc ports.SessionService, policy ports.ImpersonationPolicy, loginSvc ports.LoginProtectionService, providers ports.IdentityProviderRegistry, logger ports.LoggingService, conf config.Config) *gin.Engine {
 	router := gin.Default()
 	router.Use(ginRequestLogger(logger))
 
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
+		AllowOrigins:     conf.CORS_ALLOWED_ORIGINS,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", csrfTokenHeader},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
 
-	handler := NewGinHandler(svc, logger, conf)
+	handler := NewGinHandler(svc, tokenSvc, keySvc, roleSvc, patSvc, serviceSvc, sessionSvc, policy, loginSvc, providers, logger, conf)
 
-	usersRoutes := router.Group("/users/v1")
+	router.GET("/.well-known/jwks.json", handler.JWKS)
 
-	// middleware := NewMiddleware(svc, logger, conf.SECRET_KEY)
+	usersRoutes := router.Group("/users/v1")
 
-	// usersRoutes.Use(middleware.Authorize)
+	middleware := NewMiddleware(svc, tokenSvc, keySvc, roleSvc, patSvc, serviceSvc, policy, logger, conf)
 
 	{
 		usersRoutes.GET("/healthcheck", handler.HealthCheck)
-		usersRoutes.GET("/", handler.ReadUsers)
-		usersRoutes.GET("/:user_id", handler.ReadUser)
-		usersRoutes.PUT("/:user_id", handler.UpdateUser)
-		usersRoutes.DELETE("/:user_id", handler.DeleteUser)
-		usersRoutes.DELETE("/", handler.DeleteAllUsers)
+		usersRoutes.GET("/", middleware.Authorize, middleware.RequirePermission(domain.PermissionReadUsers), handler.ReadUsers)
+		usersRoutes.GET("/:user_id", handler.ReadUserProfile)
+		usersRoutes.PUT("/:user_id", middleware.Authorize, middleware.BlockImpersonation, middleware.RequireScope(domain.ScopeProfile), handler.UpdateUser)
+		usersRoutes.DELETE("/:user_id", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.DeleteUser)
+		usersRoutes.DELETE("/", middleware.Authorize, middleware.RequirePermission(domain.PermissionAdmin), middleware.BlockImpersonation, handler.DeleteAllUsers)
 		usersRoutes.POST("/", handler.CreateUser)
+		usersRoutes.POST("/login", handler.Login)
+		usersRoutes.POST("/login/mfa", handler.LoginMFA)
+		usersRoutes.POST("/token/refresh", handler.RefreshToken)
+		usersRoutes.POST("/oauth/token", handler.ServiceToken)
+		usersRoutes.POST("/introspect", middleware.RequireServiceToken(domain.ScopeIntrospect), handler.Introspect)
+		usersRoutes.GET("/userinfo", middleware.Authorize, handler.UserInfo)
+		usersRoutes.POST("/password/forgot", handler.ForgotPassword)
+		usersRoutes.POST("/password/reset", handler.ResetPassword)
+		usersRoutes.POST("/login/magic-link", handler.RequestMagicLink)
+		usersRoutes.POST("/login/magic-link/verify", handler.MagicLinkLogin)
+		usersRoutes.POST("/password", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.SetPassword)
+		usersRoutes.POST("/verify-email", handler.VerifyEmail)
+		usersRoutes.POST("/verify-email/resend", handler.ResendVerificationEmail)
 		usersRoutes.GET("/github/login", handler.GithubLogin)
+		usersRoutes.GET("/github/login/callback", handler.GithubCallback)
 		usersRoutes.POST("/github/login/callback", handler.GithubCallback)
-		usersRoutes.POST("/logout", handler.Logout)
+		usersRoutes.GET("/linkedin/login", handler.LinkedinLogin)
+		usersRoutes.GET("/linkedin/login/callback", handler.LinkedinCallback)
+		usersRoutes.POST("/linkedin/login/callback", handler.LinkedinCallback)
+		usersRoutes.GET("/oauth/:provider/login", handler.OAuthLogin)
+		usersRoutes.GET("/oauth/:provider/login/callback", handler.OAuthCallback)
+		usersRoutes.POST("/oauth/:provider/login/callback", handler.OAuthCallback)
+		usersRoutes.GET("/identities", middleware.Authorize, middleware.RequireSession, handler.ReadUserIdentities)
+		usersRoutes.POST("/identities/confirm", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.ConfirmIdentityLink)
+		usersRoutes.POST("/identities/:provider/link", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.LinkIdentity)
+		usersRoutes.DELETE("/identities/:provider", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.UnlinkIdentity)
+		usersRoutes.POST("/mfa/totp/enroll", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.EnrollTOTP)
+		usersRoutes.POST("/mfa/totp/confirm", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.ConfirmTOTP)
+		usersRoutes.POST("/mfa/totp/disable", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.DisableTOTP)
+		usersRoutes.POST("/logout", middleware.Authorize, middleware.RequireSession, handler.Logout)
+		usersRoutes.POST("/logout/all", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.LogoutAllSessions)
+		usersRoutes.GET("/sessions", middleware.Authorize, middleware.RequireSession, handler.ReadSessions)
+		usersRoutes.DELETE("/sessions/:session_id", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.RevokeSession)
+		usersRoutes.GET("/access-tokens", middleware.Authorize, middleware.RequireSession, handler.ReadPersonalAccessTokens)
+		usersRoutes.POST("/access-tokens", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, middleware.RequireVerifiedEmail, handler.CreatePersonalAccessToken)
+		usersRoutes.DELETE("/access-tokens/:token_id", middleware.Authorize, middleware.RequireSession, middleware.BlockImpersonation, handler.RevokePersonalAccessToken)
 
 	}
 
-	logEntry := domain.LogMessage{
-		LogLevel: "INFO",
-		Service:  "users",
-		Message:  fmt.Sprintf("Server running on port 0.0.0.0:%s", conf.SERVER_PORT),
+	adminRoutes := usersRoutes.Group("/admin", middleware.Authorize, middleware.RequirePermission(domain.PermissionAdmin))
+
+	{
+		adminRoutes.POST("/unlock", handler.UnlockAccount)
+		adminRoutes.GET("/roles", handler.ReadRoles)
+		adminRoutes.GET("/users/:user_id/roles", handler.ReadUserRoles)
+		adminRoutes.POST("/users/:user_id/roles", handler.AssignUserRole)
+		adminRoutes.DELETE("/users/:user_id/roles/:role", handler.RemoveUserRole)
+		adminRoutes.POST("/users/:user_id/impersonate", middleware.RequireSession, middleware.BlockImpersonation, handler.ImpersonateUser)
 	}
-	logger.LogError(logEntry)
-	log.Printf("Server running on port 0.0.0.0:%s", conf.SERVER_PORT)
-	router.Run(fmt.Sprintf("0.0.0.0:%s", conf.SERVER_PORT))
+
+	// Internal routes are only for other Notelify services
+	internalRoutes := usersRoutes.Group("/internal", middleware.RequireServiceToken(domain.ScopeInternalUsersRead))
+
+	{
+		internalRoutes.GET("/users", handler.ReadUsers)
+		internalRoutes.GET("/users/:user_id", handler.ReadUser)
+	}
+
+	return router
 }
 
 func ginRequestLogger(logger ports.LoggingService) gin.HandlerFunc {
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+const oauthStateCookie = "oauth_state"
+
+var (
+	ErrInvalidOAuthState  = errors.New("invalid or expired OAuth state")
+	ErrOAuthNotConfigured = errors.New("OAuth login is not configured")
+)
+
+// oauthState is kept in a signed cookie between the redirect to the provider
+// and the callback. It ties the callback to the browser that started the
+// login and carries the PKCE verifier for the code exchange. LinkUserId is
+// set when a signed in user is linking the provider to their account.
+type oauthState struct {
+	Provider   string `json:"provider"`
+	State      string `json:"state"`
+	Verifier   string `json:"verifier"`
+	LinkUserId string `json:"link_user_id,omitempty"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+// beginOAuth stores a fresh state and PKCE verifier in a cookie and returns
+// the provider's consent page URL. On failure it writes the error response
+// and returns false.
+func (h handler) beginOAuth(ctx *gin.Context, provider ports.IdentityProvider, linkUserId string) (string, bool) {
+	if h.conf.SECRET_KEY == "" {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": ErrOAuthNotConfigured.Error(),
+		})
+		return "", false
+	}
+
+	stateBytes := make([]byte, 32)
+	if _, err := rand.Read(stateBytes); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return "", false
+	}
+	state := oauthState{
+		Provider:   provider.Name(),
+		State:      base64.RawURLEncoding.EncodeToString(stateBytes),
+		Verifier:   oauth2.GenerateVerifier(),
+		LinkUserId: linkUserId,
+		ExpiresAt:  time.Now().Add(h.conf.OAUTH_STATE_TTL).Unix(),
+	}
+	cookieValue, err := signOAuthState(h.conf.SECRET_KEY, state)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return "", false
+	}
+
+	url, err := provider.AuthCodeURL(ctx.Request.Context(), state.State, state.Verifier)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"error": err.Error(),
+		})
+		return "", false
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oauthStateCookie, cookieValue, int(h.conf.OAUTH_STATE_TTL.Seconds()), "/users/v1", h.conf.COOKIE_DOMAIN, h.conf.COOKIE_SECURE, true)
+	return url, true
+}
+
+// finishOAuth checks the state returned by the provider against the cookie
+// set by beginOAuth and returns it. The cookie is cleared either way, so a
+// state can only be used once.
+func (h handler) finishOAuth(ctx *gin.Context, provider, returnedState string) (*oauthState, error) {
+	cookieValue, err := ctx.Cookie(oauthStateCookie)
+	ctx.SetCookie(oauthStateCookie, "", -1, "/users/v1", h.conf.COOKIE_DOMAIN, h.conf.COOKIE_SECURE, true)
+	if err != nil || returnedState == "" || h.conf.SECRET_KEY == "" {
+		return nil, ErrInvalidOAuthState
+	}
+
+	state, err := verifyOAuthState(h.conf.SECRET_KEY, cookieValue)
+	if err != nil {
+		return nil, err
+	}
+	if state.Provider != provider || time.Now().Unix() > state.ExpiresAt {
+		return nil, ErrInvalidOAuthState
+	}
+	if subtle.ConstantTimeCompare([]byte(state.State), []byte(returnedState)) != 1 {
+		return nil, ErrInvalidOAuthState
+	}
+	return state, nil
+}
+
+func signOAuthState(secret string, state oauthState) (string, error) {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + oauthStateSignature(secret, encoded), nil
+}
+
+func verifyOAuthState(secret, cookieValue string) (*oauthState, error) {
+	encoded, signature, found := strings.Cut(cookieValue, ".")
+	if !found {
+		return nil, ErrInvalidOAuthState
+	}
+	if !hmac.Equal([]byte(signature), []byte(oauthStateSignature(secret, encoded))) {
+		return nil, ErrInvalidOAuthState
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+	var state oauthState
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return nil, ErrInvalidOAuthState
+	}
+	return &state, nil
+}
+
+func oauthStateSignature(secret, encoded string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("oauth_state:" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
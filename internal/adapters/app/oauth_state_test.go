@@ -0,0 +1,176 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// fakeIdentityProvider records the state and PKCE verifier it was sent.
+type fakeIdentityProvider struct {
+	state, verifier *string
+}
+
+func (p fakeIdentityProvider) Name() string {
+	return "github"
+}
+
+func (p fakeIdentityProvider) AuthCodeURL(ctx context.Context, state, verifier string) (string, error) {
+	*p.state = state
+	*p.verifier = verifier
+	return "https://provider.example.com/authorize?state=" + state + "&code_challenge=" + oauth2.S256ChallengeFromVerifier(verifier), nil
+}
+
+func (p fakeIdentityProvider) Exchange(ctx context.Context, code, verifier string) (*domain.User, *domain.UserIdentity, error) {
+	return nil, nil, nil
+}
+
+func newOAuthStateTestHandler() handler {
+	gin.SetMode(gin.TestMode)
+	conf := config.Config{SECRET_KEY: "test-secret", OAUTH_STATE_TTL: time.Minute}
+	return NewGinHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, fakeLogger{}, conf).(handler)
+}
+
+// beginTestOAuth runs beginOAuth and returns the state cookie it set.
+func beginTestOAuth(t *testing.T, h handler, provider fakeIdentityProvider) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/users/v1/login/github", nil)
+	if _, ok := h.beginOAuth(ctx, provider, ""); !ok {
+		t.Fatalf("beginOAuth failed: %s", w.Body.String())
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oauthStateCookie {
+			return cookie
+		}
+	}
+	t.Fatal("no state cookie set")
+	return nil
+}
+
+func TestBeginOAuthUsesPKCE(t *testing.T) {
+	h := newOAuthStateTestHandler()
+	var state, verifier string
+	provider := fakeIdentityProvider{state: &state, verifier: &verifier}
+
+	cookie := beginTestOAuth(t, h, provider)
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/users/v1" {
+		t.Errorf("state cookie %+v is not HttpOnly, SameSite=Lax and scoped to /users/v1", cookie)
+	}
+	// RFC 7636 verifiers are 43 to 128 characters.
+	if len(verifier) < 43 || len(verifier) > 128 {
+		t.Errorf("verifier %q has length %d", verifier, len(verifier))
+	}
+	stored, err := verifyOAuthState(h.conf.SECRET_KEY, cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != state || stored.Verifier != verifier || stored.Provider != "github" {
+		t.Errorf("cookie holds %+v, provider was sent state %q and verifier %q", stored, state, verifier)
+	}
+
+	firstState, firstVerifier := state, verifier
+	beginTestOAuth(t, h, provider)
+	if state == firstState || verifier == firstVerifier {
+		t.Errorf("a second login reused the state or verifier")
+	}
+}
+
+func TestFinishOAuth(t *testing.T) {
+	h := newOAuthStateTestHandler()
+	sign := func(secret string, state oauthState) string {
+		value, err := signOAuthState(secret, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	valid := oauthState{Provider: "github", State: "state-1", Verifier: "verifier-1", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	expired := valid
+	expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+
+	// A cookie whose payload was changed after signing.
+	encoded, signature, _ := strings.Cut(sign(h.conf.SECRET_KEY, valid), ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(encoded)
+	tampered := strings.Replace(string(payload), `"state-1"`, `"state-2"`, 1)
+	tamperedCookie := base64.RawURLEncoding.EncodeToString([]byte(tampered)) + "." + signature
+
+	tests := []struct {
+		name     string
+		cookie   string
+		provider string
+		state    string
+		ok       bool
+	}{
+		{"valid", sign(h.conf.SECRET_KEY, valid), "github", "state-1", true},
+		{"no cookie", "", "github", "state-1", false},
+		{"no state returned", sign(h.conf.SECRET_KEY, valid), "github", "", false},
+		{"mismatched state", sign(h.conf.SECRET_KEY, valid), "github", "state-2", false},
+		{"other provider", sign(h.conf.SECRET_KEY, valid), "linkedin", "state-1", false},
+		{"expired", sign(h.conf.SECRET_KEY, expired), "github", "state-1", false},
+		{"signed with another secret", sign("other-secret", valid), "github", "state-1", false},
+		{"tampered payload", tamperedCookie, "github", "state-2", false},
+		{"unsigned", encoded, "github", "state-1", false},
+		{"garbage", "not-a-state.cookie", "github", "state-1", false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/users/v1/callback/"+tt.provider, nil)
+		if tt.cookie != "" {
+			ctx.Request.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: tt.cookie})
+		}
+
+		state, err := h.finishOAuth(ctx, tt.provider, tt.state)
+		if tt.ok && (err != nil || state.Verifier != "verifier-1") {
+			t.Errorf("%s: finishOAuth returned %+v, %v", tt.name, state, err)
+		}
+		if !tt.ok && err != ErrInvalidOAuthState {
+			t.Errorf("%s: finishOAuth returned %v, want %v", tt.name, err, ErrInvalidOAuthState)
+		}
+		// The cookie is cleared whatever the outcome, so it cannot be replayed.
+		cleared := false
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == oauthStateCookie && cookie.MaxAge < 0 {
+				cleared = true
+			}
+		}
+		if !cleared {
+			t.Errorf("%s: state cookie was not cleared", tt.name)
+		}
+	}
+}
+
+func TestOAuthWithoutSecretKey(t *testing.T) {
+	h := NewGinHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, fakeLogger{}, config.Config{OAUTH_STATE_TTL: time.Minute}).(handler)
+	var state, verifier string
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/users/v1/login/github", nil)
+	if _, ok := h.beginOAuth(ctx, fakeIdentityProvider{state: &state, verifier: &verifier}, ""); ok || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("beginOAuth without a secret key returned %d", w.Code)
+	}
+
+	// An empty key must not accept a cookie signed with an empty key.
+	cookie, err := signOAuthState("", oauthState{Provider: "github", State: "state-1", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, _ = gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/users/v1/callback/github", nil)
+	ctx.Request.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: cookie})
+	if _, err := h.finishOAuth(ctx, "github", "state-1"); err != ErrInvalidOAuthState {
+		t.Errorf("finishOAuth without a secret key returned %v, want %v", err, ErrInvalidOAuthState)
+	}
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessTokenCookie      = "token"
+	refreshTokenCookie     = "refresh_token"
+	refreshTokenCookiePath = "/users/v1"
+	csrfTokenCookie        = "csrf_token"
+	csrfTokenHeader        = "X-CSRF-Token"
+)
+
+// startSession is where every login method ends, so a password login and a
+// login through an identity provider give the client the same session.
+// Users with two-factor authentication get an mfa token for LoginMFA
+// instead.
+func (h handler) startSession(ctx *gin.Context, user *domain.User) {
+	if user.TOTPEnabled {
+		h.requireMFA(ctx, user.UserId)
+		return
+	}
+	h.issueTokens(ctx, user.UserId)
+}
+
+func (h handler) requireMFA(ctx *gin.Context, user_id string) {
+	mfaToken, err := h.middleware.GenerateMFAToken(user_id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// The token goes in the fragment so it never reaches server logs.
+	if browserRedirect(ctx) {
+		fragment := url.Values{"mfa_token": {mfaToken}}
+		ctx.Redirect(http.StatusSeeOther, h.conf.FRONTEND_URL+"/login/mfa#"+fragment.Encode())
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"mfaRequired": true,
+		"mfaToken":    mfaToken,
+		"expiresIn":   int(h.conf.MFA_TOKEN_TTL.Seconds()),
+	})
+}
+
+// issueTokens starts a session for the user, issues its access token and
+// refresh token and sets them as cookies. A browser sent here by an identity
+// provider is redirected to the frontend; other clients get the tokens in
+// the body.
+func (h handler) issueTokens(ctx *gin.Context, user_id string) {
+	token, refreshToken, err := h.tokenSvc.IssueRefreshToken(user_id)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if _, err := h.sessionSvc.RecordSession(user_id, token.FamilyId, ctx.Request.UserAgent(), ctx.ClientIP()); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	tokenString, err := h.middleware.GenerateToken(user_id, token.FamilyId)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	csrfToken, err := h.setSessionCookies(ctx, tokenString, refreshToken)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if browserRedirect(ctx) {
+		ctx.Redirect(http.StatusSeeOther, h.conf.FRONTEND_URL)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"accessToken":  tokenString,
+		"refreshToken": refreshToken,
+		"csrfToken":    csrfToken,
+		"expiresIn":    int(h.conf.ACCESS_TOKEN_TTL.Seconds()),
+		"redirectTo":   h.conf.FRONTEND_URL,
+	})
+}
+
+// setSessionCookies sets the access and refresh token cookies along with a
+// new CSRF token. The CSRF cookie is readable by scripts so the frontend can
+// echo it in the X-CSRF-Token header; it is also returned for clients on
+// another domain.
+func (h handler) setSessionCookies(ctx *gin.Context, accessToken, refreshToken string) (string, error) {
+	csrfBytes := make([]byte, 32)
+	if _, err := rand.Read(csrfBytes); err != nil {
+		return "", err
+	}
+	csrfToken := base64.RawURLEncoding.EncodeToString(csrfBytes)
+
+	h.setCookie(ctx, accessTokenCookie, accessToken, int(h.conf.ACCESS_TOKEN_TTL.Seconds()), "/", true)
+	h.setCookie(ctx, refreshTokenCookie, refreshToken, int(h.conf.REFRESH_TOKEN_TTL.Seconds()), refreshTokenCookiePath, true)
+	h.setCookie(ctx, csrfTokenCookie, csrfToken, int(h.conf.REFRESH_TOKEN_TTL.Seconds()), "/", false)
+	return csrfToken, nil
+}
+
+func (h handler) clearSessionCookies(ctx *gin.Context) {
+	h.setCookie(ctx, accessTokenCookie, "", -1, "/", true)
+	h.setCookie(ctx, refreshTokenCookie, "", -1, refreshTokenCookiePath, true)
+	h.setCookie(ctx, csrfTokenCookie, "", -1, "/", false)
+}
+
+// setCookie applies the configured cookie domain, Secure and SameSite
+// settings.
+func (h handler) setCookie(ctx *gin.Context, name, value string, maxAge int, path string, httpOnly bool) {
+	ctx.SetSameSite(h.conf.COOKIE_SAMESITE)
+	ctx.SetCookie(name, value, maxAge, path, h.conf.COOKIE_DOMAIN, h.conf.COOKIE_SECURE, httpOnly)
+}
+
+// validCSRFToken implements the double-submit check for requests
+// authenticated by cookie: the X-CSRF-Token header must match the csrf_token
+// cookie. A cross-site page can make the browser send the cookie but cannot
+// read it to set the header. Safe methods are not checked.
+func validCSRFToken(ctx *gin.Context) bool {
+	switch ctx.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	cookie, err := ctx.Cookie(csrfTokenCookie)
+	header := ctx.GetHeader(csrfTokenHeader)
+	if err != nil || cookie == "" || header == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+// browserRedirect reports whether the request is a browser navigation from
+// an identity provider's redirect, rather than an API call.
+func browserRedirect(ctx *gin.Context) bool {
+	return ctx.Request.Method == http.MethodGet
+}
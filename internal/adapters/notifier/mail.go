@@ -0,0 +1,33 @@
+package notifier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+)
+
+// MailNotifier emails users about sign-ins from new devices.
+type MailNotifier struct {
+	mailer      ports.Mailer
+	frontendURL string
+}
+
+func NewMailNotifier(mailer ports.Mailer, frontendURL string) *MailNotifier {
+	return &MailNotifier{mailer: mailer, frontendURL: frontendURL}
+}
+
+func (n *MailNotifier) NotifyNewDevice(user *domain.User, session *domain.Session) error {
+	if user.Email == "" {
+		return nil
+	}
+	message := domain.MailMessage{
+		To:      user.Email,
+		Subject: "New sign-in to your Notelify account",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYour account was signed in to from %s (IP address %s) at %s.\n\nIf this was not you, sign that session out and change your password at %s/settings/sessions.\n",
+			user.Firstname, session.Device, session.IPAddress, session.CreatedAt.UTC().Format(time.RFC1123), n.frontendURL),
+	}
+	return n.mailer.SendMail(message)
+}
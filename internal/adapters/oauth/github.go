@@ -0,0 +1,107 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"golang.org/x/oauth2"
+)
+
+// GithubProvider logs users in through GitHub's OAuth apps. GitHub does not
+// speak OpenID Connect, so the profile comes from its REST API.
+type GithubProvider struct {
+	oauth  *oauth2.Config
+	apiURL string
+}
+
+func NewGithubProvider(clientID, clientSecret, redirectURL, apiURL string) *GithubProvider {
+	return &GithubProvider{
+		oauth: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"read:user", "user:email"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  "https://github.com/login/oauth/authorize",
+				TokenURL: "https://github.com/login/oauth/access_token",
+			},
+		},
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+	}
+}
+
+func (p *GithubProvider) Name() string {
+	return "github"
+}
+
+func (p *GithubProvider) AuthCodeURL(ctx context.Context, state, verifier string) (string, error) {
+	return p.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *GithubProvider) Exchange(ctx context.Context, code, verifier string) (*domain.User, *domain.UserIdentity, error) {
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrExchangeFailed, err.Error())
+	}
+
+	var githubUser domain.GithubUser
+	if err := p.getJSON(ctx, "/user", token.AccessToken, &githubUser); err != nil {
+		return nil, nil, err
+	}
+	githubUser.AccessToken = token.AccessToken
+
+	// The profile only carries the email the user chose to make public, and
+	// says nothing about whether it was verified.
+	email, err := p.primaryEmail(ctx, token.AccessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	githubUser.Email = email
+	user := githubUser.InitGithubUser()
+
+	identity := domain.UserIdentity{
+		Provider: p.Name(),
+		Subject:  strconv.Itoa(githubUser.ID),
+		Email:    user.Email,
+	}
+	return &user, &identity, nil
+}
+
+// primaryEmail returns the user's primary email address, or an empty string
+// when it has not been verified.
+func (p *GithubProvider) primaryEmail(ctx context.Context, accessToken string) (string, error) {
+	var emails []domain.GithubEmail
+	if err := p.getJSON(ctx, "/user/emails", accessToken, &emails); err != nil {
+		return "", err
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			return email.Email, nil
+		}
+	}
+	return "", nil
+}
+
+func (p *GithubProvider) getJSON(ctx context.Context, path, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("github %s request failed with status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
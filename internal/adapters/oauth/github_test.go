@@ -0,0 +1,64 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+func TestGithubPrimaryEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		emails []domain.GithubEmail
+		want   string
+	}{
+		{"primary and verified", []domain.GithubEmail{
+			{Email: "old@example.com", Verified: true},
+			{Email: "ada@example.com", Primary: true, Verified: true},
+		}, "ada@example.com"},
+		{"primary but unverified", []domain.GithubEmail{
+			{Email: "ada@example.com", Primary: true},
+			{Email: "old@example.com", Verified: true},
+		}, ""},
+		{"verified but not primary", []domain.GithubEmail{
+			{Email: "old@example.com", Verified: true},
+		}, ""},
+		{"no emails", []domain.GithubEmail{}, ""},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/user/emails" || r.Header.Get("Authorization") != "Bearer github-token" {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(tt.emails)
+		}))
+		provider := NewGithubProvider("client", "secret", "http://localhost/callback", server.URL+"/")
+
+		email, err := provider.primaryEmail(context.Background(), "github-token")
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: primaryEmail returned %v", tt.name, err)
+			continue
+		}
+		if email != tt.want {
+			t.Errorf("%s: primaryEmail returned %q, want %q", tt.name, email, tt.want)
+		}
+	}
+}
+
+func TestGithubPrimaryEmailRequestFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+	provider := NewGithubProvider("client", "secret", "http://localhost/callback", server.URL)
+
+	if _, err := provider.primaryEmail(context.Background(), "github-token"); err == nil {
+		t.Errorf("primaryEmail ignored a failed request")
+	}
+}
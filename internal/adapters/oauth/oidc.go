@@ -0,0 +1,301 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+)
+
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// defaultClaimMapping maps user fields to the standard OpenID Connect claims.
+// Providers can override single entries through their configuration.
+var defaultClaimMapping = map[string]string{
+	"subject":        "sub",
+	"email":          "email",
+	"email_verified": "email_verified",
+	"firstname":      "given_name",
+	"lastname":       "family_name",
+	"name":           "name",
+	"handle":         "preferred_username",
+	"profile_image":  "picture",
+}
+
+// oidcDiscovery is the part of the provider's
+// /.well-known/openid-configuration document we use.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider logs users in through any OpenID Connect provider. Endpoints
+// are discovered from the issuer on first use and ID tokens are checked
+// against the provider's published keys.
+type OIDCProvider struct {
+	name   string
+	issuer string
+	claims map[string]string
+	oauth  oauth2.Config
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      *keySet
+}
+
+func NewOIDCProvider(conf config.OIDCProviderConfig) *OIDCProvider {
+	claims := map[string]string{}
+	for field, claim := range defaultClaimMapping {
+		claims[field] = claim
+	}
+	for field, claim := range conf.Claims {
+		claims[field] = claim
+	}
+
+	scopes := conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+
+	return &OIDCProvider{
+		name:   conf.Name,
+		issuer: strings.TrimSuffix(conf.Issuer, "/"),
+		claims: claims,
+		oauth: oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Scopes:       scopes,
+		},
+	}
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, verifier string) (string, error) {
+	oauthConfig, err := p.oauthConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauthConfig.AuthCodeURL(state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("nonce", nonceFor(verifier)),
+	), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier string) (*domain.User, *domain.UserIdentity, error) {
+	oauthConfig, err := p.oauthConfig(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrExchangeFailed, err.Error())
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, nil, fmt.Errorf("%w: token response has no id_token", ErrInvalidIDToken)
+	}
+	claims, err := p.verifyIDToken(ctx, rawIDToken, nonceFor(verifier))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Some providers keep profile claims out of the ID token.
+	if p.discovery.UserinfoEndpoint != "" {
+		userinfo, err := p.userinfo(ctx, token.AccessToken)
+		if err != nil {
+			return nil, nil, err
+		}
+		if userinfo["sub"] != claims["sub"] {
+			return nil, nil, fmt.Errorf("%w: userinfo subject does not match", ErrInvalidIDToken)
+		}
+		for claim, value := range userinfo {
+			if _, ok := claims[claim]; !ok {
+				claims[claim] = value
+			}
+		}
+	}
+
+	user, identity, err := p.mapClaims(claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	user.AccessToken = token.AccessToken
+	return user, identity, nil
+}
+
+// oauthConfig returns the OAuth client for the provider, running discovery
+// first if it has not succeeded yet.
+func (p *OIDCProvider) oauthConfig(ctx context.Context) (*oauth2.Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery == nil {
+		discovery := oidcDiscovery{}
+		if err := getJSON(ctx, p.issuer+"/.well-known/openid-configuration", "", &discovery); err != nil {
+			return nil, fmt.Errorf("%s discovery failed: %w", p.name, err)
+		}
+		if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+			return nil, fmt.Errorf("%s discovery returned issuer %q, want %q", p.name, discovery.Issuer, p.issuer)
+		}
+		if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("%s discovery document is missing endpoints", p.name)
+		}
+		p.discovery = &discovery
+		p.keys = newKeySet(discovery.JWKSURI)
+	}
+
+	oauthConfig := p.oauth
+	oauthConfig.Endpoint = oauth2.Endpoint{
+		AuthURL:  p.discovery.AuthorizationEndpoint,
+		TokenURL: p.discovery.TokenEndpoint,
+	}
+	return &oauthConfig, nil
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err.Error())
+	}
+
+	issuer, _ := claims["iss"].(string)
+	switch {
+	case strings.TrimSuffix(issuer, "/") != p.issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, issuer)
+	case !claims.VerifyAudience(p.oauth.ClientID, true):
+		return nil, fmt.Errorf("%w: token was not issued for this client", ErrInvalidIDToken)
+	case !claims.VerifyExpiresAt(time.Now().Unix(), true):
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	case claims["nonce"] != nonce:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) userinfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	userinfo := map[string]interface{}{}
+	if err := getJSON(ctx, p.discovery.UserinfoEndpoint, accessToken, &userinfo); err != nil {
+		return nil, fmt.Errorf("%s userinfo request failed: %w", p.name, err)
+	}
+	return userinfo, nil
+}
+
+// mapClaims builds the user profile and identity from the token claims
+// using the provider's claim mapping.
+func (p *OIDCProvider) mapClaims(claims map[string]interface{}) (*domain.User, *domain.UserIdentity, error) {
+	subject := p.claimString(claims, "subject")
+	if subject == "" {
+		return nil, nil, fmt.Errorf("%w: no subject claim", ErrInvalidIDToken)
+	}
+
+	firstname, lastname := p.claimString(claims, "firstname"), p.claimString(claims, "lastname")
+	if firstname == "" {
+		nameParts := strings.Fields(p.claimString(claims, "name"))
+		if len(nameParts) > 0 {
+			firstname = nameParts[0]
+			lastname = strings.Join(nameParts[1:], " ")
+		}
+	}
+
+	// An address the provider has not verified could belong to anyone, so
+	// it is neither stored nor matched against existing accounts.
+	email := p.claimString(claims, "email")
+	if p.claimString(claims, "email_verified") != "true" {
+		email = ""
+	}
+
+	user := domain.User{
+		Firstname:     firstname,
+		Lastname:      lastname,
+		Email:         email,
+		EmailVerified: email != "",
+		Handle:        p.claimString(claims, "handle"),
+		Articles:      []domain.Article{},
+		ProfileImage:  p.claimString(claims, "profile_image"),
+		Following:     []domain.FollowUser{},
+		Followers:     []domain.FollowUser{},
+	}
+	identity := domain.UserIdentity{
+		Provider: p.name,
+		Subject:  subject,
+		Email:    email,
+	}
+	return &user, &identity, nil
+}
+
+// claimString looks up the claim mapped to field. Dotted claim names reach
+// into nested objects, such as "address.country".
+func (p *OIDCProvider) claimString(claims map[string]interface{}, field string) string {
+	var value interface{} = claims
+	for _, part := range strings.Split(p.claims[field], ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[part]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool, float64:
+		return fmt.Sprint(v)
+	default:
+		return ""
+	}
+}
+
+// nonceFor derives the OIDC nonce from the PKCE verifier, which only this
+// login attempt knows, so the ID token is bound to it without extra state.
+func nonceFor(verifier string) string {
+	sum := sha256.Sum256([]byte("nonce:" + verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func getJSON(ctx context.Context, url, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request to %s failed with status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,63 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+)
+
+var ErrExchangeFailed = errors.New("failed to exchange code for token")
+
+// Registry holds the login providers by name.
+type Registry struct {
+	providers map[string]ports.IdentityProvider
+}
+
+// NewRegistry registers GitHub, LinkedIn and every provider listed in
+// conf.OIDC_PROVIDERS.
+func NewRegistry(conf config.Config) (*Registry, error) {
+	registry := Registry{
+		providers: map[string]ports.IdentityProvider{},
+	}
+
+	github := NewGithubProvider(conf.GITHUB_CLIENT_ID, conf.GITHUB_CLIENT_SECRET, conf.GITHUB_REDIRECT_URL, conf.GITHUB_API_URL)
+	if err := registry.Register(github); err != nil {
+		return nil, err
+	}
+
+	linkedin := NewOIDCProvider(config.OIDCProviderConfig{
+		Name:         "linkedin",
+		Issuer:       conf.LINKEDIN_ISSUER,
+		ClientID:     conf.LINKEDIN_CLIENT_ID,
+		ClientSecret: conf.LINKEDIN_CLIENT_SECRET,
+		RedirectURL:  conf.LINKEDIN_REDIRECT_URL,
+	})
+	if err := registry.Register(linkedin); err != nil {
+		return nil, err
+	}
+
+	for _, providerConf := range conf.OIDC_PROVIDERS {
+		if providerConf.Name == "" || providerConf.Issuer == "" {
+			return nil, fmt.Errorf("oidc provider %q needs a name and an issuer", providerConf.Name)
+		}
+		if err := registry.Register(NewOIDCProvider(providerConf)); err != nil {
+			return nil, err
+		}
+	}
+	return &registry, nil
+}
+
+func (r *Registry) Register(provider ports.IdentityProvider) error {
+	if _, ok := r.providers[provider.Name()]; ok {
+		return fmt.Errorf("identity provider %q registered twice", provider.Name())
+	}
+	r.providers[provider.Name()] = provider
+	return nil
+}
+
+func (r *Registry) Provider(name string) (ports.IdentityProvider, bool) {
+	provider, ok := r.providers[name]
+	return provider, ok
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// LoginAttemptStore keeps failed login counters in process memory. Like
+// RevocationStore it only suits tests and single-instance deployments.
+type LoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]domain.LoginAttempt
+}
+
+func NewLoginAttemptStore() *LoginAttemptStore {
+	return &LoginAttemptStore{
+		attempts: map[string]domain.LoginAttempt{},
+	}
+}
+
+func (store *LoginAttemptStore) ReadLoginAttempt(key string) (*domain.LoginAttempt, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	attempt, ok := store.attempts[key]
+	if !ok {
+		attempt = domain.LoginAttempt{Key: key}
+	}
+	return &attempt, nil
+}
+
+func (store *LoginAttemptStore) RecordLoginFailure(key string, window time.Duration) (*domain.LoginAttempt, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	attempt, ok := store.attempts[key]
+	if !ok || now.Sub(attempt.LastFailureAt) > window {
+		attempt.Key = key
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	store.attempts[key] = attempt
+	return &attempt, nil
+}
+
+func (store *LoginAttemptStore) LockLogin(key string, lockedUntil time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	attempt, ok := store.attempts[key]
+	if !ok {
+		attempt = domain.LoginAttempt{Key: key}
+	}
+	attempt.LockedUntil = lockedUntil
+	store.attempts[key] = attempt
+	return nil
+}
+
+func (store *LoginAttemptStore) ResetLoginAttempts(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.attempts, key)
+	return nil
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"sync"
+	"time"
+)
+
+type userRevocation struct {
+	revokedAt time.Time
+	expiresAt time.Time
+}
+
+// RevocationStore keeps revoked tokens in process memory. It is meant for
+// tests and single-instance deployments; entries are lost on restart.
+type RevocationStore struct {
+	mu            sync.Mutex
+	revokedTokens map[string]time.Time
+	revokedUsers  map[string]userRevocation
+}
+
+func NewRevocationStore() *RevocationStore {
+	return &RevocationStore{
+		revokedTokens: map[string]time.Time{},
+		revokedUsers:  map[string]userRevocation{},
+	}
+}
+
+func (store *RevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.revokedTokens[jti] = expiresAt
+	store.purgeExpired()
+	return nil
+}
+
+func (store *RevocationStore) IsTokenRevoked(jti string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	_, ok := store.revokedTokens[jti]
+	return ok, nil
+}
+
+func (store *RevocationStore) RevokeUserTokens(user_id string, revokedAt, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.revokedUsers[user_id] = userRevocation{revokedAt: revokedAt, expiresAt: expiresAt}
+	store.purgeExpired()
+	return nil
+}
+
+func (store *RevocationStore) ReadUserTokensRevokedAt(user_id string) (time.Time, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	revocation, ok := store.revokedUsers[user_id]
+	if !ok || time.Now().After(revocation.expiresAt) {
+		return time.Time{}, nil
+	}
+	return revocation.revokedAt, nil
+}
+
+func (store *RevocationStore) purgeExpired() {
+	now := time.Now()
+	for jti, expiresAt := range store.revokedTokens {
+		if now.After(expiresAt) {
+			delete(store.revokedTokens, jti)
+		}
+	}
+	for user_id, revocation := range store.revokedUsers {
+		if now.After(revocation.expiresAt) {
+			delete(store.revokedUsers, user_id)
+		}
+	}
+}
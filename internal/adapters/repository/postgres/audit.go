@@ -0,0 +1,18 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+func (psql *PostgresDBClient) CreateAuditEvent(event *domain.AuditEvent) (*domain.AuditEvent, error) {
+	queryString := fmt.Sprintf(`
+		INSERT INTO %s (event_id, actor_id, action, target_id, outcome, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, psql.auditTable)
+	_, err := psql.db.Exec(queryString, event.EventId, event.ActorId, event.Action, event.TargetId, event.Outcome, event.Reason, event.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
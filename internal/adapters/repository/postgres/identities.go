@@ -0,0 +1,84 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+func (psql *PostgresDBClient) CreateUserIdentity(identity *domain.UserIdentity) (*domain.UserIdentity, error) {
+	queryString := fmt.Sprintf(`
+		INSERT INTO %s
+			(provider, subject, user_id, email, created_at)
+		VALUES
+			($1, $2, $3, $4, $5)`, psql.identityTable)
+	_, err := psql.db.Exec(queryString, identity.Provider, identity.Subject, identity.UserId, identity.Email, identity.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (psql *PostgresDBClient) ReadUserIdentity(provider, subject string) (*domain.UserIdentity, error) {
+	var identity domain.UserIdentity
+	queryString := fmt.Sprintf(`SELECT provider, subject, user_id, email, created_at FROM %s WHERE provider = $1 AND subject = $2`, psql.identityTable)
+	err := psql.db.QueryRow(queryString, provider, subject).Scan(&identity.Provider, &identity.Subject, &identity.UserId, &identity.Email, &identity.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (psql *PostgresDBClient) ReadUserIdentities(user_id string) ([]domain.UserIdentity, error) {
+	queryString := fmt.Sprintf(`SELECT provider, subject, user_id, email, created_at FROM %s WHERE user_id = $1 ORDER BY created_at`, psql.identityTable)
+	rows, err := psql.db.Query(queryString, user_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []domain.UserIdentity{}
+	for rows.Next() {
+		var identity domain.UserIdentity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserId, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// DeleteUserIdentity removes the link to provider. The matching legacy id
+// column on the user row is cleared as well, or the startup backfill would
+// link the provider again.
+func (psql *PostgresDBClient) DeleteUserIdentity(user_id, provider string) (string, error) {
+	tx, err := psql.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	queryString := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1 AND provider = $2`, psql.identityTable)
+	if _, err := tx.Exec(queryString, user_id, provider); err != nil {
+		return "", err
+	}
+
+	switch provider {
+	case "github":
+		queryString = fmt.Sprintf(`UPDATE %s SET github_id = NULL WHERE user_id = $1`, psql.tablename)
+	case "linkedin":
+		queryString = fmt.Sprintf(`UPDATE %s SET linkedin_id = NULL WHERE user_id = $1`, psql.tablename)
+	default:
+		queryString = ""
+	}
+	if queryString != "" {
+		if _, err := tx.Exec(queryString, user_id); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return "Identity unlinked successfully", nil
+}
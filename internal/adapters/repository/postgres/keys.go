@@ -0,0 +1,189 @@
+package postgres
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+func (psql *PostgresDBClient) CreateSigningKey(key *domain.SigningKey) (*domain.SigningKey, error) {
+	privateKey, err := psql.encodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+			(
+				kid,
+				algorithm,
+				private_key,
+				public_key,
+				created_at,
+				rotates_at,
+				expires_at
+			)
+		VALUES
+			($1,$2,$3,$4,$5,$6,$7)`,
+		psql.signingKeyTable)
+	_, err = psql.db.Exec(
+		query,
+		key.KeyId,
+		key.Algorithm,
+		privateKey,
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		key.CreatedAt,
+		key.RotatesAt,
+		key.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (psql *PostgresDBClient) ReadSigningKeys() ([]domain.SigningKey, error) {
+	queryString := fmt.Sprintf(`
+		SELECT
+			kid,
+			algorithm,
+			private_key,
+			public_key,
+			created_at,
+			rotates_at,
+			expires_at
+		FROM %s
+		WHERE
+			expires_at > NOW()`, psql.signingKeyTable)
+	rows, err := psql.db.Query(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []domain.SigningKey{}
+	for rows.Next() {
+		var key domain.SigningKey
+		var privateKey, publicKey string
+		if err := rows.Scan(
+			&key.KeyId,
+			&key.Algorithm,
+			&privateKey,
+			&publicKey,
+			&key.CreatedAt,
+			&key.RotatesAt,
+			&key.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+
+		key.PrivateKey, err = psql.decodePrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		key.PublicKey, err = parsePEM(publicKey, x509.ParsePKIXPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (psql *PostgresDBClient) DeleteExpiredSigningKeys() (string, error) {
+	queryString := fmt.Sprintf(`DELETE FROM %s WHERE expires_at < NOW()`, psql.signingKeyTable)
+	_, err := psql.db.Exec(queryString)
+	if err != nil {
+		return "", err
+	}
+	return "Expired signing keys deleted successfully", nil
+}
+
+// ReencryptSigningKeys encrypts stored private keys that are in plain text
+// or sealed with an old key-encryption key, and returns how many it updated.
+func (psql *PostgresDBClient) ReencryptSigningKeys() (int, error) {
+	if psql.encrypter == nil {
+		return 0, nil
+	}
+	queryString := fmt.Sprintf(`SELECT kid, private_key FROM %s`, psql.signingKeyTable)
+	rows, err := psql.db.Query(queryString)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	privateKeys := map[string]string{}
+	for rows.Next() {
+		var kid, privateKey string
+		if err := rows.Scan(&kid, &privateKey); err != nil {
+			return 0, err
+		}
+		if psql.encrypter.NeedsReencryption(privateKey) {
+			privateKeys[kid] = privateKey
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for kid, privateKey := range privateKeys {
+		plaintext, err := psql.encrypter.Decrypt(privateKey)
+		if err != nil {
+			return updated, err
+		}
+		sealed, err := psql.encrypter.Encrypt(plaintext)
+		if err != nil {
+			return updated, err
+		}
+		queryString := fmt.Sprintf(`UPDATE %s SET private_key = $1 WHERE kid = $2`, psql.signingKeyTable)
+		if _, err := psql.db.Exec(queryString, sealed, kid); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// encodePrivateKey PEM encodes a private key and, when an encrypter is set,
+// encrypts it.
+func (psql *PostgresDBClient) encodePrivateKey(privateKey any) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	encoded := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if psql.encrypter == nil {
+		return encoded, nil
+	}
+	return psql.encrypter.Encrypt(encoded)
+}
+
+// decodePrivateKey reverses encodePrivateKey. Keys stored before encryption
+// was introduced are plain PEM.
+func (psql *PostgresDBClient) decodePrivateKey(stored string) (any, error) {
+	encoded := stored
+	if psql.encrypter != nil {
+		var err error
+		encoded, err = psql.encrypter.Decrypt(stored)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parsePEM(encoded, x509.ParsePKCS8PrivateKey)
+}
+
+func parsePEM(encoded string, parse func([]byte) (any, error)) (any, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("invalid PEM encoded key")
+	}
+	return parse(block.Bytes)
+}
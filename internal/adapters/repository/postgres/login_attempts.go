@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// ReadLoginAttempt returns an empty attempt when the key has no failures.
+func (psql *PostgresDBClient) ReadLoginAttempt(key string) (*domain.LoginAttempt, error) {
+	var attempt domain.LoginAttempt
+	var lockedUntil sql.NullTime
+	queryString := fmt.Sprintf(`
+		SELECT
+			attempt_key,
+			failures,
+			last_failure_at,
+			locked_until
+		FROM %s
+		WHERE
+			attempt_key=$1`, psql.loginAttemptTable)
+	err := psql.db.QueryRow(
+		queryString,
+		key).Scan(
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailureAt,
+		&lockedUntil,
+	)
+	if err == sql.ErrNoRows {
+		return &domain.LoginAttempt{Key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	attempt.LockedUntil = lockedUntil.Time
+	return &attempt, nil
+}
+
+// RecordLoginFailure increments the failure count, starting over when the
+// previous failure is older than window.
+func (psql *PostgresDBClient) RecordLoginFailure(key string, window time.Duration) (*domain.LoginAttempt, error) {
+	var attempt domain.LoginAttempt
+	var lockedUntil sql.NullTime
+	queryString := fmt.Sprintf(`
+		INSERT INTO %[1]s (attempt_key, failures, last_failure_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (attempt_key) DO UPDATE SET
+			failures = CASE
+				WHEN %[1]s.last_failure_at < NOW() - make_interval(secs => $2) THEN 1
+				ELSE %[1]s.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING
+			attempt_key,
+			failures,
+			last_failure_at,
+			locked_until`, psql.loginAttemptTable)
+	err := psql.db.QueryRow(
+		queryString,
+		key,
+		window.Seconds()).Scan(
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailureAt,
+		&lockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	attempt.LockedUntil = lockedUntil.Time
+	return &attempt, nil
+}
+
+func (psql *PostgresDBClient) LockLogin(key string, lockedUntil time.Time) error {
+	queryString := fmt.Sprintf(`UPDATE %s SET locked_until = $2 WHERE attempt_key = $1`, psql.loginAttemptTable)
+	_, err := psql.db.Exec(queryString, key, lockedUntil)
+	return err
+}
+
+func (psql *PostgresDBClient) ResetLoginAttempts(key string) error {
+	queryString := fmt.Sprintf(`DELETE FROM %s WHERE attempt_key = $1`, psql.loginAttemptTable)
+	_, err := psql.db.Exec(queryString, key)
+	return err
+}
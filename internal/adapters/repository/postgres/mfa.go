@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// UpdateUserTOTP stores a new secret and resets the replay counter. An empty
+// secret removes two-factor authentication.
+func (psql *PostgresDBClient) UpdateUserTOTP(user_id, secret string, enabled bool) (string, error) {
+	queryString := fmt.Sprintf(`
+		UPDATE %s SET
+			totp_secret = NULLIF($2, ''),
+			totp_enabled = $3,
+			totp_last_step = CASE WHEN totp_secret IS DISTINCT FROM NULLIF($2, '') THEN 0 ELSE totp_last_step END
+		WHERE
+			user_id = $1`, psql.tablename)
+	_, err := psql.db.Exec(queryString, user_id, secret, enabled)
+	if err != nil {
+		return "", err
+	}
+	return "Two-factor authentication updated successfully", nil
+}
+
+func (psql *PostgresDBClient) ReadUserTOTPSecret(user_id string) (string, error) {
+	var secret sql.NullString
+	queryString := fmt.Sprintf(`SELECT totp_secret FROM %s WHERE user_id = $1`, psql.tablename)
+	err := psql.db.QueryRow(queryString, user_id).Scan(&secret)
+	if err != nil {
+		return "", err
+	}
+	return secret.String, nil
+}
+
+// ReadUserTOTPSecrets returns the stored TOTP secret of every user that has
+// one, keyed by user id.
+func (psql *PostgresDBClient) ReadUserTOTPSecrets() (map[string]string, error) {
+	queryString := fmt.Sprintf(`SELECT user_id, totp_secret FROM %s WHERE totp_secret IS NOT NULL`, psql.tablename)
+	rows, err := psql.db.Query(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	secrets := map[string]string{}
+	for rows.Next() {
+		var user_id, secret string
+		if err := rows.Scan(&user_id, &secret); err != nil {
+			return nil, err
+		}
+		secrets[user_id] = secret
+	}
+	return secrets, rows.Err()
+}
+
+// ReplaceUserTOTPSecret stores the same secret in a new encrypted form. Unlike
+// UpdateUserTOTP it keeps the replay counter.
+func (psql *PostgresDBClient) ReplaceUserTOTPSecret(user_id, secret string) (string, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET totp_secret = $2 WHERE user_id = $1 AND totp_secret IS NOT NULL`, psql.tablename)
+	_, err := psql.db.Exec(queryString, user_id, secret)
+	if err != nil {
+		return "", err
+	}
+	return "Two-factor authentication secret updated successfully", nil
+}
+
+// UpdateUserTOTPLastStep records the time step of an accepted code. It
+// reports false when that step, or a later one, was already used.
+func (psql *PostgresDBClient) UpdateUserTOTPLastStep(user_id string, step int64) (bool, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET totp_last_step = $2 WHERE user_id = $1 AND totp_last_step < $2`, psql.tablename)
+	result, err := psql.db.Exec(queryString, user_id, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// CreateRecoveryCodes replaces all of the user's recovery codes.
+func (psql *PostgresDBClient) CreateRecoveryCodes(user_id string, code_hashes []string) (string, error) {
+	tx, err := psql.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, psql.recoveryCodeTable), user_id)
+	if err != nil {
+		return "", err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (code_hash, user_id) VALUES ($1, $2)`, psql.recoveryCodeTable)
+	for _, code_hash := range code_hashes {
+		_, err = tx.Exec(query, code_hash, user_id)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return "Recovery codes created successfully", nil
+}
+
+func (psql *PostgresDBClient) ConsumeRecoveryCode(user_id, code_hash string) (bool, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, psql.recoveryCodeTable)
+	result, err := psql.db.Exec(queryString, user_id, code_hash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (psql *PostgresDBClient) DeleteRecoveryCodes(user_id string) (string, error) {
+	queryString := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, psql.recoveryCodeTable)
+	_, err := psql.db.Exec(queryString, user_id)
+	if err != nil {
+		return "", err
+	}
+	return "Recovery codes deleted successfully", nil
+}
@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/lib/pq"
+)
+
+func (psql *PostgresDBClient) CreatePersonalAccessToken(token *domain.PersonalAccessToken) (*domain.PersonalAccessToken, error) {
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+			(
+				token_id,
+				user_id,
+				name,
+				scopes,
+				token_hash,
+				expires_at,
+				created_at
+			)
+		VALUES
+			($1,$2,$3,$4,$5,$6,$7)`,
+		psql.patTable)
+	_, err := psql.db.Exec(
+		query,
+		token.TokenId,
+		token.UserId,
+		token.Name,
+		pq.Array(token.Scopes),
+		token.TokenHash,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (psql *PostgresDBClient) ReadPersonalAccessTokenWithHash(token_hash string) (*domain.PersonalAccessToken, error) {
+	queryString := fmt.Sprintf(`
+		SELECT
+			token_id,
+			user_id,
+			name,
+			scopes,
+			token_hash,
+			expires_at,
+			last_used_at,
+			created_at
+		FROM %s
+		WHERE
+			token_hash=$1`, psql.patTable)
+	return scanPersonalAccessToken(psql.db.QueryRow(queryString, token_hash))
+}
+
+func (psql *PostgresDBClient) ReadUserPersonalAccessTokens(user_id string) ([]domain.PersonalAccessToken, error) {
+	queryString := fmt.Sprintf(`
+		SELECT
+			token_id,
+			user_id,
+			name,
+			scopes,
+			token_hash,
+			expires_at,
+			last_used_at,
+			created_at
+		FROM %s
+		WHERE
+			user_id=$1
+		ORDER BY created_at DESC`, psql.patTable)
+	rows, err := psql.db.Query(queryString, user_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []domain.PersonalAccessToken{}
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// DeletePersonalAccessToken reports whether the user had a token with that id.
+func (psql *PostgresDBClient) DeletePersonalAccessToken(user_id, token_id string) (bool, error) {
+	queryString := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1 AND token_id = $2`, psql.patTable)
+	result, err := psql.db.Exec(queryString, user_id, token_id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (psql *PostgresDBClient) DeleteUserPersonalAccessTokens(user_id string) (string, error) {
+	queryString := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, psql.patTable)
+	_, err := psql.db.Exec(queryString, user_id)
+	if err != nil {
+		return "", err
+	}
+	return "Tokens deleted successfully", nil
+}
+
+func (psql *PostgresDBClient) UpdatePersonalAccessTokenLastUsed(token_id string, lastUsedAt time.Time) (string, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET last_used_at = $2 WHERE token_id = $1`, psql.patTable)
+	_, err := psql.db.Exec(queryString, token_id, lastUsedAt)
+	if err != nil {
+		return "", err
+	}
+	return "Token usage recorded successfully", nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPersonalAccessToken(row rowScanner) (*domain.PersonalAccessToken, error) {
+	var token domain.PersonalAccessToken
+	var lastUsedAt sql.NullTime
+	err := row.Scan(
+		&token.TokenId,
+		&token.UserId,
+		&token.Name,
+		pq.Array(&token.Scopes),
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&lastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	token.LastUsedAt = lastUsedAt.Time
+	return &token, nil
+}
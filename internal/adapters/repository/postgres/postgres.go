@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/AntonyIS/notelify-users-service/config"
 	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
 )
@@ -17,7 +19,24 @@ import (
 type PostgresDBClient struct {
 	db                 *sql.DB
 	tablename          string
+	refreshTokenTable  string
+	revokedTokenTable  string
+	revokedUserTable   string
+	signingKeyTable    string
+	oneTimeTokenTable  string
+	recoveryCodeTable  string
+	loginAttemptTable  string
+	identityTable      string
+	roleTable          string
+	permissionTable    string
+	userRoleTable      string
+	auditTable         string
+	patTable           string
+	serviceClientTable string
+	sessionTable       string
 	articlesServiceURL string
+	serviceTokens      ports.ServiceTokenSource
+	encrypter          ports.SecretEncrypter
 }
 
 func NewPostgresClient(appConfig config.Config) (*PostgresDBClient, error) {
@@ -44,12 +63,46 @@ func NewPostgresClient(appConfig config.Config) (*PostgresDBClient, error) {
 		return nil, err
 	}
 
-	err = migrateDB(db, tablename)
+	client := PostgresDBClient{
+		db:                 db,
+		tablename:          tablename,
+		refreshTokenTable:  fmt.Sprintf("%s_refresh_tokens", tablename),
+		revokedTokenTable:  fmt.Sprintf("%s_revoked_tokens", tablename),
+		revokedUserTable:   fmt.Sprintf("%s_revoked_users", tablename),
+		signingKeyTable:    fmt.Sprintf("%s_signing_keys", tablename),
+		oneTimeTokenTable:  fmt.Sprintf("%s_one_time_tokens", tablename),
+		recoveryCodeTable:  fmt.Sprintf("%s_recovery_codes", tablename),
+		loginAttemptTable:  fmt.Sprintf("%s_login_attempts", tablename),
+		identityTable:      fmt.Sprintf("%s_identities", tablename),
+		roleTable:          fmt.Sprintf("%s_roles", tablename),
+		permissionTable:    fmt.Sprintf("%s_role_permissions", tablename),
+		userRoleTable:      fmt.Sprintf("%s_user_roles", tablename),
+		auditTable:         fmt.Sprintf("%s_audit_log", tablename),
+		patTable:           fmt.Sprintf("%s_personal_access_tokens", tablename),
+		serviceClientTable: fmt.Sprintf("%s_service_clients", tablename),
+		sessionTable:       fmt.Sprintf("%s_sessions", tablename),
+		articlesServiceURL: articlesServiceURL,
+	}
+
+	err = client.migrateDB()
 	if err != nil {
 		return nil, err
 	}
 
-	return &PostgresDBClient{db: db, tablename: tablename, articlesServiceURL: articlesServiceURL}, nil
+	return &client, nil
+}
+
+// UseServiceTokens sets where the token sent to the articles service comes
+// from. The token service signs with keys stored by this client, so it is
+// set after both exist.
+func (psql *PostgresDBClient) UseServiceTokens(source ports.ServiceTokenSource) {
+	psql.serviceTokens = source
+}
+
+// UseSecretEncrypter sets how signing keys are encrypted at rest. Without
+// one, private keys are stored as plain PEM.
+func (psql *PostgresDBClient) UseSecretEncrypter(encrypter ports.SecretEncrypter) {
+	psql.encrypter = encrypter
 }
 
 func (psql *PostgresDBClient) CreateUser(user *domain.User) (*domain.User, error) {
@@ -70,10 +123,11 @@ func (psql *PostgresDBClient) CreateUser(user *domain.User) (*domain.User, error
 				profile_image,
 				following,
 				followers, 
-				accessToken
+				accessToken,
+				email_verified
 			) 
 		VALUES 
-			($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)`,
+			($1,NULLIF($2, ''),NULLIF($3, ''),$4,$5,NULLIF($6, ''),NULLIF($7, ''),$8,$9,$10,$11,$12,$13,$14,$15)`,
 		psql.tablename)
 	_, err := psql.db.Exec(
 		query,
@@ -91,6 +145,7 @@ func (psql *PostgresDBClient) CreateUser(user *domain.User) (*domain.User, error
 		pq.Array(user.Following),
 		pq.Array(user.Followers),
 		user.AccessToken,
+		user.EmailVerified,
 	)
 
 	if err != nil {
@@ -105,18 +160,20 @@ func (psql *PostgresDBClient) ReadUserWithId(user_id string) (*domain.User, erro
 	queryString := fmt.Sprintf(`
 		SELECT 
 			user_id,
-			github_id,
-			linkedin_id,
+			COALESCE(github_id, ''),
+			COALESCE(linkedin_id, ''),
 			firstname,
 			lastname,
-			email,
+			COALESCE(email, ''),
 			handle,
 			about,
 			articles,
 			profile_image,
 			following,
 			followers,
-			accessToken 
+			accessToken,
+			email_verified,
+			totp_enabled
 		FROM %s 
 		WHERE 
 			user_id=$1`, psql.tablename)
@@ -136,13 +193,15 @@ func (psql *PostgresDBClient) ReadUserWithId(user_id string) (*domain.User, erro
 		pq.Array(&user.Following),
 		pq.Array(&user.Followers),
 		&user.AccessToken,
+		&user.EmailVerified,
+		&user.TOTPEnabled,
 	)
 	if err != nil {
 		return nil, err
 	}
 	articleSvcURL := fmt.Sprintf("%s/author/%s", psql.articlesServiceURL, user_id)
 	var articles []domain.Article
-	articles, _ = getUserArticles(articleSvcURL)
+	articles, _ = psql.getUserArticles(articleSvcURL)
 	user.Articles = articles
 	return &user, nil
 }
@@ -152,18 +211,20 @@ func (psql *PostgresDBClient) ReadUserWithGithubId(github_id string) (*domain.Us
 	queryString := fmt.Sprintf(`
 		SELECT 
 			user_id,
-			github_id,
-			linkedin_id,
+			COALESCE(github_id, ''),
+			COALESCE(linkedin_id, ''),
 			firstname,
 			lastname,
-			email,
+			COALESCE(email, ''),
 			handle,
 			about,
 			articles,
 			profile_image,
 			following,
 			followers,
-			accessToken 
+			accessToken,
+			email_verified,
+			totp_enabled
 		FROM %s 
 		WHERE 
 			github_id=$1`, psql.tablename)
@@ -183,13 +244,15 @@ func (psql *PostgresDBClient) ReadUserWithGithubId(github_id string) (*domain.Us
 		pq.Array(&user.Following),
 		pq.Array(&user.Followers),
 		&user.AccessToken,
+		&user.EmailVerified,
+		&user.TOTPEnabled,
 	)
 	if err != nil {
 		return nil, err
 	}
 	articleSvcURL := fmt.Sprintf("%s/author/%s", psql.articlesServiceURL, github_id)
 	var articles []domain.Article
-	articles, _ = getUserArticles(articleSvcURL)
+	articles, _ = psql.getUserArticles(articleSvcURL)
 	user.Articles = articles
 	return &user, nil
 }
@@ -199,18 +262,20 @@ func (psql *PostgresDBClient) ReadUserWithLinkedinId(linkedin_id string) (*domai
 	queryString := fmt.Sprintf(`
 		SELECT 
 			user_id,
-			github_id,
-			linkedin_id,
+			COALESCE(github_id, ''),
+			COALESCE(linkedin_id, ''),
 			firstname,
 			lastname,
-			email,
+			COALESCE(email, ''),
 			handle,
 			about,
 			articles,
 			profile_image,
 			following,
 			followers,
-			accessToken 
+			accessToken,
+			email_verified,
+			totp_enabled
 		FROM %s 
 		WHERE 
 		linkedin_id=$1`, psql.tablename)
@@ -230,19 +295,21 @@ func (psql *PostgresDBClient) ReadUserWithLinkedinId(linkedin_id string) (*domai
 		pq.Array(&user.Following),
 		pq.Array(&user.Followers),
 		&user.AccessToken,
+		&user.EmailVerified,
+		&user.TOTPEnabled,
 	)
 	if err != nil {
 		return nil, err
 	}
 	articleSvcURL := fmt.Sprintf("%s/author/%s", psql.articlesServiceURL, linkedin_id)
 	var articles []domain.Article
-	articles, _ = getUserArticles(articleSvcURL)
+	articles, _ = psql.getUserArticles(articleSvcURL)
 	user.Articles = articles
 	return &user, nil
 }
 
 func (psql *PostgresDBClient) ReadUsers() ([]domain.User, error) {
-	rows, err := psql.db.Query(fmt.Sprintf("SELECT user_id,github_id,linkedin_id,firstname,lastname,email,handle,about,articles,profile_image,following,followers, accessToken FROM %s", psql.tablename))
+	rows, err := psql.db.Query(fmt.Sprintf("SELECT user_id,COALESCE(github_id, ''),COALESCE(linkedin_id, ''),firstname,lastname,COALESCE(email, ''),handle,about,articles,profile_image,following,followers, accessToken, email_verified, totp_enabled FROM %s", psql.tablename))
 	if err != nil {
 		return nil, err
 	}
@@ -266,6 +333,8 @@ func (psql *PostgresDBClient) ReadUsers() ([]domain.User, error) {
 			pq.Array(&user.Following),
 			pq.Array(&user.Followers),
 			&user.AccessToken,
+			&user.EmailVerified,
+			&user.TOTPEnabled,
 		); err != nil {
 
 			return nil, err
@@ -277,8 +346,8 @@ func (psql *PostgresDBClient) ReadUsers() ([]domain.User, error) {
 
 func (psql *PostgresDBClient) ReadUserWithEmail(email string) (*domain.User, error) {
 	var user domain.User
-	queryString := fmt.Sprintf(`SELECT user_id,github_id,linkedin_id,firstname,lastname,email,handle,about,articles,profile_image,following,followers, accessToken FROM %s WHERE email=$1`, psql.tablename)
-	err := psql.db.QueryRow(queryString, email).Scan(&user.UserId, &user.Firstname, &user.Lastname, &user.Email, &user.Handle, &user.About, pq.Array(&user.Articles), &user.ProfileImage, &user.Following, &user.Followers, &user.AccessToken)
+	queryString := fmt.Sprintf(`SELECT user_id,COALESCE(github_id, ''),COALESCE(linkedin_id, ''),firstname,lastname,COALESCE(email, ''),COALESCE(password, ''),handle,about,articles,profile_image,following,followers, accessToken, email_verified, totp_enabled FROM %s WHERE email=$1`, psql.tablename)
+	err := psql.db.QueryRow(queryString, email).Scan(&user.UserId, &user.GitHubId, &user.LinkedInId, &user.Firstname, &user.Lastname, &user.Email, &user.Password, &user.Handle, &user.About, pq.Array(&user.Articles), &user.ProfileImage, pq.Array(&user.Following), pq.Array(&user.Followers), &user.AccessToken, &user.EmailVerified, &user.TOTPEnabled)
 	if err != nil {
 		return nil, err
 	}
@@ -286,6 +355,8 @@ func (psql *PostgresDBClient) ReadUserWithEmail(email string) (*domain.User, err
 	return &user, nil
 }
 
+// UpdateUser saves the user's profile fields. Credentials, provider links and
+// follows have their own update paths.
 func (psql *PostgresDBClient) UpdateUser(user *domain.User) (*domain.User, error) {
 	queryString := fmt.Sprintf(`
 	UPDATE %s SET 
@@ -293,40 +364,136 @@ func (psql *PostgresDBClient) UpdateUser(user *domain.User) (*domain.User, error
 		lastname = $3,
 		handle = $4,
 		about = $5,
-		articles = $6,
-		profile_image = $7,
-		following = $8,
-		followers = $9,
-		accessToken = $10,
+		profile_image = $6
+	WHERE user_id = $1
 	`, psql.tablename)
 
-	_, err := psql.db.Exec(queryString, user.Firstname, user.Lastname, user.Handle, user.About, user.Articles, user.ProfileImage, user.Following, user.Followers, user.AccessToken)
+	_, err := psql.db.Exec(queryString, user.UserId, user.Firstname, user.Lastname, user.Handle, user.About, user.ProfileImage)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
+func (psql *PostgresDBClient) UpdateUserPassword(user_id, password string) (string, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET password = $2 WHERE user_id = $1`, psql.tablename)
+	_, err := psql.db.Exec(queryString, user_id, password)
+	if err != nil {
+		return "", err
+	}
+	return "Password updated successfully", nil
+}
+
+// ReadUserPassword returns the user's password hash, or an empty string for
+// accounts that have not set a password.
+func (psql *PostgresDBClient) ReadUserPassword(user_id string) (string, error) {
+	var password string
+	queryString := fmt.Sprintf(`SELECT COALESCE(password, '') FROM %s WHERE user_id = $1`, psql.tablename)
+	err := psql.db.QueryRow(queryString, user_id).Scan(&password)
+	if err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+func (psql *PostgresDBClient) UpdateUserEmailVerified(user_id string, verified bool) (string, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET email_verified = $2 WHERE user_id = $1`, psql.tablename)
+	_, err := psql.db.Exec(queryString, user_id, verified)
+	if err != nil {
+		return "", err
+	}
+	return "Email verification updated successfully", nil
+}
+
+// ReadUserAccessTokens returns the stored provider access token of every
+// user that has one, keyed by user id.
+func (psql *PostgresDBClient) ReadUserAccessTokens() (map[string]string, error) {
+	queryString := fmt.Sprintf(`SELECT user_id, accessToken FROM %s WHERE accessToken IS NOT NULL AND accessToken <> ''`, psql.tablename)
+	rows, err := psql.db.Query(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accessTokens := map[string]string{}
+	for rows.Next() {
+		var user_id, accessToken string
+		if err := rows.Scan(&user_id, &accessToken); err != nil {
+			return nil, err
+		}
+		accessTokens[user_id] = accessToken
+	}
+	return accessTokens, rows.Err()
+}
+
+func (psql *PostgresDBClient) UpdateUserAccessToken(user_id, accessToken string) (string, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET accessToken = $2 WHERE user_id = $1`, psql.tablename)
+	_, err := psql.db.Exec(queryString, user_id, accessToken)
+	if err != nil {
+		return "", err
+	}
+	return "Access token updated successfully", nil
+}
+
+// userOwnedTables lists the tables holding rows that belong to a user,
+// ending with the users table itself. The audit log is kept, and revoked
+// access tokens expire on their own.
+func (psql *PostgresDBClient) userOwnedTables() []string {
+	return []string{
+		psql.patTable,
+		psql.sessionTable,
+		psql.refreshTokenTable,
+		psql.oneTimeTokenTable,
+		psql.recoveryCodeTable,
+		psql.identityTable,
+		psql.userRoleTable,
+		psql.tablename,
+	}
+}
+
+// DeleteUser removes the user along with everything they own, so their
+// tokens stop authenticating and a provider login linked to them signs up a
+// new account instead of resolving to a deleted one.
 func (psql *PostgresDBClient) DeleteUser(user_id string) (string, error) {
-	queryString := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, psql.tablename)
-	_, err := psql.db.Exec(queryString, user_id)
+	tx, err := psql.db.Begin()
 	if err != nil {
 		return "", err
 	}
+	defer tx.Rollback()
+
+	for _, table := range psql.userOwnedTables() {
+		queryString := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1`, table)
+		if _, err := tx.Exec(queryString, user_id); err != nil {
+			return "", err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
 	return "Entity deleted successfully", nil
 }
 
 func (psql *PostgresDBClient) DeleteAllUsers() (string, error) {
-	queryString := fmt.Sprintf(`DELETE FROM %s`, psql.tablename)
-	_, err := psql.db.Exec(queryString)
+	tx, err := psql.db.Begin()
 	if err != nil {
 		return "", err
 	}
+	defer tx.Rollback()
+
+	for _, table := range psql.userOwnedTables() {
+		queryString := fmt.Sprintf(`DELETE FROM %s`, table)
+		if _, err := tx.Exec(queryString); err != nil {
+			return "", err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
 	return "All items deletes successfully", nil
 }
 
-func migrateDB(db *sql.DB, userTable string) error {
-	queryString := fmt.Sprintf(`
+func (psql *PostgresDBClient) migrateDB() error {
+	userTableQuery := fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS %s (
 			user_id VARCHAR(255) NOT NULL PRIMARY KEY UNIQUE,
 			github_id VARCHAR(255)  UNIQUE,
@@ -334,28 +501,257 @@ func migrateDB(db *sql.DB, userTable string) error {
 			firstname VARCHAR(255) NOT NULL,
 			lastname VARCHAR(255) NOT NULL,
 			email VARCHAR(255) UNIQUE,
-			password VARCHAR(255) UNIQUE NOT NULL,
+			password VARCHAR(255),
 			handle VARCHAR(255),
 			about TEXT,
 			articles TEXT [],
 			profile_image varchar(255),
 			following TEXT [],
 			followers TEXT [],
-			accessToken  VARCHAR(255)
+			accessToken TEXT,
+			email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+			totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			totp_secret TEXT,
+			totp_last_step BIGINT NOT NULL DEFAULT 0
 	)
-	`, userTable)
+	`, psql.tablename)
 
-	_, err := db.Exec(queryString)
-	if err != nil {
-		return err
+	userTableUpgradeQuery := fmt.Sprintf(`
+		ALTER TABLE %s
+			ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+			ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0,
+			ALTER COLUMN accessToken TYPE TEXT,
+			ALTER COLUMN totp_secret TYPE TEXT
+	`, psql.tablename)
+
+	// Missing provider ids and emails are stored as NULL so they do not
+	// collide on the UNIQUE constraints. Older rows used empty strings.
+	userIdentityUpgradeQuery := fmt.Sprintf(`
+		UPDATE %s SET
+			github_id = NULLIF(github_id, ''),
+			linkedin_id = NULLIF(linkedin_id, ''),
+			email = NULLIF(email, '')
+		WHERE github_id = '' OR linkedin_id = '' OR email = ''
+	`, psql.tablename)
+
+	// Accounts created through an OAuth provider have no password.
+	userPasswordUpgradeQuery := fmt.Sprintf(`
+		ALTER TABLE %s
+			ALTER COLUMN password DROP NOT NULL,
+			DROP CONSTRAINT IF EXISTS %s_password_key
+	`, psql.tablename, strings.ToLower(psql.tablename))
+
+	refreshTokenTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			token_id VARCHAR(255) NOT NULL PRIMARY KEY,
+			family_id VARCHAR(255) NOT NULL,
+			user_id VARCHAR(255) NOT NULL,
+			token_hash VARCHAR(255) NOT NULL UNIQUE,
+			used BOOLEAN NOT NULL DEFAULT FALSE,
+			revoked BOOLEAN NOT NULL DEFAULT FALSE,
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+	)
+	`, psql.refreshTokenTable)
+
+	revokedTokenTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			jti VARCHAR(255) NOT NULL PRIMARY KEY,
+			expires_at TIMESTAMPTZ NOT NULL
+	)
+	`, psql.revokedTokenTable)
+
+	revokedUserTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			user_id VARCHAR(255) NOT NULL PRIMARY KEY,
+			revoked_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+	)
+	`, psql.revokedUserTable)
+
+	signingKeyTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			kid VARCHAR(255) NOT NULL PRIMARY KEY,
+			algorithm VARCHAR(32) NOT NULL,
+			private_key TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			rotates_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL
+	)
+	`, psql.signingKeyTable)
+
+	oneTimeTokenTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			token_hash VARCHAR(255) NOT NULL PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			purpose VARCHAR(64) NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			used_at TIMESTAMPTZ
+	)
+	`, psql.oneTimeTokenTable)
+
+	recoveryCodeTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			code_hash VARCHAR(255) NOT NULL PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			used_at TIMESTAMPTZ
+	)
+	`, psql.recoveryCodeTable)
+
+	loginAttemptTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			attempt_key VARCHAR(255) NOT NULL PRIMARY KEY,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at TIMESTAMPTZ NOT NULL,
+			locked_until TIMESTAMPTZ
+	)
+	`, psql.loginAttemptTable)
+
+	identityTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			provider VARCHAR(64) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			user_id VARCHAR(255) NOT NULL,
+			email VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (provider, subject)
+	)
+	`, psql.identityTable)
+
+	// Accounts linked before the identities table existed only have the
+	// provider id on the user row.
+	identityBackfillQuery := fmt.Sprintf(`
+		INSERT INTO %s (provider, subject, user_id, email, created_at)
+			SELECT 'github', github_id, user_id, COALESCE(email, ''), NOW() FROM %s WHERE github_id IS NOT NULL
+			UNION ALL
+			SELECT 'linkedin', linkedin_id, user_id, COALESCE(email, ''), NOW() FROM %s WHERE linkedin_id IS NOT NULL
+		ON CONFLICT DO NOTHING
+	`, psql.identityTable, psql.tablename, psql.tablename)
+
+	roleTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			name VARCHAR(64) NOT NULL PRIMARY KEY,
+			description TEXT NOT NULL DEFAULT ''
+	)
+	`, psql.roleTable)
+
+	permissionTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			role VARCHAR(64) NOT NULL REFERENCES %s (name) ON DELETE CASCADE,
+			permission VARCHAR(64) NOT NULL,
+			PRIMARY KEY (role, permission)
+	)
+	`, psql.permissionTable, psql.roleTable)
+
+	userRoleTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			user_id VARCHAR(255) NOT NULL,
+			role VARCHAR(64) NOT NULL REFERENCES %s (name) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (user_id, role)
+	)
+	`, psql.userRoleTable, psql.roleTable)
+
+	auditTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			event_id VARCHAR(255) NOT NULL PRIMARY KEY,
+			actor_id VARCHAR(255) NOT NULL,
+			action VARCHAR(64) NOT NULL,
+			target_id VARCHAR(255) NOT NULL,
+			outcome VARCHAR(32) NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL
+	)
+	`, psql.auditTable)
+
+	patTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			token_id VARCHAR(255) NOT NULL PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			scopes TEXT [] NOT NULL,
+			token_hash VARCHAR(255) NOT NULL UNIQUE,
+			expires_at TIMESTAMPTZ NOT NULL,
+			last_used_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL
+	)
+	`, psql.patTable)
+
+	serviceClientTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			client_id VARCHAR(255) NOT NULL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			secret_hash VARCHAR(255) NOT NULL,
+			scopes TEXT [] NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
+	)
+	`, psql.serviceClientTable)
+
+	sessionTableQuery := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			session_id VARCHAR(255) NOT NULL PRIMARY KEY,
+			user_id VARCHAR(255) NOT NULL,
+			device VARCHAR(255) NOT NULL,
+			user_agent TEXT NOT NULL,
+			ip_address VARCHAR(255) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			last_seen_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMPTZ
+	)
+	`, psql.sessionTable)
+
+	for _, queryString := range []string{
+		userTableQuery,
+		userTableUpgradeQuery,
+		userPasswordUpgradeQuery,
+		userIdentityUpgradeQuery,
+		refreshTokenTableQuery,
+		revokedTokenTableQuery,
+		revokedUserTableQuery,
+		signingKeyTableQuery,
+		oneTimeTokenTableQuery,
+		recoveryCodeTableQuery,
+		loginAttemptTableQuery,
+		identityTableQuery,
+		identityBackfillQuery,
+		roleTableQuery,
+		permissionTableQuery,
+		userRoleTableQuery,
+		auditTableQuery,
+		patTableQuery,
+		serviceClientTableQuery,
+		sessionTableQuery,
+	} {
+		_, err := psql.db.Exec(queryString)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return psql.seedRoles()
 
 }
 
-func getUserArticles(url string) ([]domain.Article, error) {
-	response, err := http.Get(url)
+// getUserArticles reads a user's articles from the articles service,
+// authenticating with a service token when a token source is set.
+func (psql *PostgresDBClient) getUserArticles(url string) ([]domain.Article, error) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return []domain.Article{}, err
+	}
+	if psql.serviceTokens != nil {
+		token, err := psql.serviceTokens.ServiceToken(domain.ScopeArticlesRead)
+		if err != nil {
+			return []domain.Article{}, err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := http.DefaultClient.Do(request)
 	if err != nil {
 		return []domain.Article{}, err
 	}
@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// seedRoles creates the default roles and grants their permissions. Roles
+// and permissions added by hand are left alone.
+func (psql *PostgresDBClient) seedRoles() error {
+	roleQuery := fmt.Sprintf(`INSERT INTO %s (name, description) VALUES ($1, $2) ON CONFLICT DO NOTHING`, psql.roleTable)
+	permissionQuery := fmt.Sprintf(`INSERT INTO %s (role, permission) VALUES ($1, $2) ON CONFLICT DO NOTHING`, psql.permissionTable)
+
+	for _, role := range domain.DefaultRoles {
+		if _, err := psql.db.Exec(roleQuery, role.Name, role.Description); err != nil {
+			return err
+		}
+		for _, permission := range role.Permissions {
+			if _, err := psql.db.Exec(permissionQuery, role.Name, permission); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (psql *PostgresDBClient) ReadRoles() ([]domain.Role, error) {
+	queryString := fmt.Sprintf(`
+		SELECT r.name, r.description, COALESCE(p.permission, '')
+		FROM %s r
+		LEFT JOIN %s p ON p.role = r.name
+		ORDER BY r.name, p.permission`, psql.roleTable, psql.permissionTable)
+	rows, err := psql.db.Query(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []domain.Role{}
+	for rows.Next() {
+		var name, description, permission string
+		if err := rows.Scan(&name, &description, &permission); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, domain.Role{Name: name, Description: description, Permissions: []string{}})
+		}
+		if permission != "" {
+			role := &roles[len(roles)-1]
+			role.Permissions = append(role.Permissions, permission)
+		}
+	}
+	return roles, rows.Err()
+}
+
+func (psql *PostgresDBClient) ReadUserRoles(user_id string) ([]string, error) {
+	queryString := fmt.Sprintf(`SELECT role FROM %s WHERE user_id = $1 ORDER BY role`, psql.userRoleTable)
+	rows, err := psql.db.Query(queryString, user_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (psql *PostgresDBClient) AssignUserRole(user_id, role string) (string, error) {
+	queryString := fmt.Sprintf(`INSERT INTO %s (user_id, role, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, psql.userRoleTable)
+	_, err := psql.db.Exec(queryString, user_id, role, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return "Role assigned successfully", nil
+}
+
+func (psql *PostgresDBClient) RemoveUserRole(user_id, role string) (string, error) {
+	queryString := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1 AND role = $2`, psql.userRoleTable)
+	_, err := psql.db.Exec(queryString, user_id, role)
+	if err != nil {
+		return "", err
+	}
+	return "Role removed successfully", nil
+}
+
+func (psql *PostgresDBClient) CountUsersWithRole(role string) (int, error) {
+	var count int
+	queryString := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE role = $1`, psql.userRoleTable)
+	err := psql.db.QueryRow(queryString, role).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/lib/pq"
+)
+
+func (psql *PostgresDBClient) CreateServiceClient(client *domain.ServiceClient) (*domain.ServiceClient, error) {
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+			(
+				client_id,
+				name,
+				secret_hash,
+				scopes,
+				created_at
+			)
+		VALUES
+			($1,$2,$3,$4,$5)`,
+		psql.serviceClientTable)
+	_, err := psql.db.Exec(
+		query,
+		client.ClientId,
+		client.Name,
+		client.SecretHash,
+		pq.Array(client.Scopes),
+		client.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (psql *PostgresDBClient) ReadServiceClient(client_id string) (*domain.ServiceClient, error) {
+	var client domain.ServiceClient
+	queryString := fmt.Sprintf(`
+		SELECT
+			client_id,
+			name,
+			secret_hash,
+			scopes,
+			created_at
+		FROM %s
+		WHERE
+			client_id=$1`, psql.serviceClientTable)
+	err := psql.db.QueryRow(queryString, client_id).Scan(
+		&client.ClientId,
+		&client.Name,
+		&client.SecretHash,
+		pq.Array(&client.Scopes),
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
@@ -0,0 +1,134 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+func (psql *PostgresDBClient) CreateSession(session *domain.Session) (*domain.Session, error) {
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+			(
+				session_id,
+				user_id,
+				device,
+				user_agent,
+				ip_address,
+				created_at,
+				last_seen_at
+			)
+		VALUES
+			($1,$2,$3,$4,$5,$6,$7)`,
+		psql.sessionTable)
+	_, err := psql.db.Exec(
+		query,
+		session.SessionId,
+		session.UserId,
+		session.Device,
+		session.UserAgent,
+		session.IPAddress,
+		session.CreatedAt,
+		session.LastSeenAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// ReadUserSessions returns the user's sessions that are not revoked and were
+// last seen after activeSince, most recent first.
+func (psql *PostgresDBClient) ReadUserSessions(user_id string, activeSince time.Time) ([]domain.Session, error) {
+	queryString := fmt.Sprintf(`
+		SELECT
+			session_id,
+			user_id,
+			device,
+			user_agent,
+			ip_address,
+			created_at,
+			last_seen_at
+		FROM %s
+		WHERE
+			user_id=$1 AND revoked_at IS NULL AND last_seen_at > $2
+		ORDER BY last_seen_at DESC`, psql.sessionTable)
+	rows, err := psql.db.Query(queryString, user_id, activeSince)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []domain.Session{}
+	for rows.Next() {
+		var session domain.Session
+		err := rows.Scan(
+			&session.SessionId,
+			&session.UserId,
+			&session.Device,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// ReadUserDevices returns every device the user has signed in from,
+// including on sessions that have since been revoked.
+func (psql *PostgresDBClient) ReadUserDevices(user_id string) ([]string, error) {
+	queryString := fmt.Sprintf(`SELECT DISTINCT device FROM %s WHERE user_id = $1`, psql.sessionTable)
+	rows, err := psql.db.Query(queryString, user_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []string{}
+	for rows.Next() {
+		var device string
+		if err := rows.Scan(&device); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+func (psql *PostgresDBClient) UpdateSessionLastSeen(session_id, ip string, lastSeenAt time.Time) (string, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET last_seen_at = $2, ip_address = $3 WHERE session_id = $1`, psql.sessionTable)
+	_, err := psql.db.Exec(queryString, session_id, lastSeenAt, ip)
+	if err != nil {
+		return "", err
+	}
+	return "Session updated successfully", nil
+}
+
+// RevokeSession reports whether the user had an active session with that id.
+func (psql *PostgresDBClient) RevokeSession(user_id, session_id string) (bool, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET revoked_at = NOW() WHERE user_id = $1 AND session_id = $2 AND revoked_at IS NULL`, psql.sessionTable)
+	result, err := psql.db.Exec(queryString, user_id, session_id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (psql *PostgresDBClient) RevokeUserSessions(user_id string) (string, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, psql.sessionTable)
+	_, err := psql.db.Exec(queryString, user_id)
+	if err != nil {
+		return "", err
+	}
+	return "Sessions revoked successfully", nil
+}
@@ -0,0 +1,303 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+func (psql *PostgresDBClient) CreateRefreshToken(token *domain.RefreshToken) (*domain.RefreshToken, error) {
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+			(
+				token_id,
+				family_id,
+				user_id,
+				token_hash,
+				used,
+				revoked,
+				expires_at,
+				created_at
+			)
+		VALUES
+			($1,$2,$3,$4,$5,$6,$7,$8)`,
+		psql.refreshTokenTable)
+	_, err := psql.db.Exec(
+		query,
+		token.TokenId,
+		token.FamilyId,
+		token.UserId,
+		token.TokenHash,
+		token.Used,
+		token.Revoked,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (psql *PostgresDBClient) ReadRefreshTokenWithHash(token_hash string) (*domain.RefreshToken, error) {
+	var token domain.RefreshToken
+	queryString := fmt.Sprintf(`
+		SELECT
+			token_id,
+			family_id,
+			user_id,
+			token_hash,
+			used,
+			revoked,
+			expires_at,
+			created_at
+		FROM %s
+		WHERE
+			token_hash=$1`, psql.refreshTokenTable)
+	err := psql.db.QueryRow(
+		queryString,
+		token_hash).Scan(
+		&token.TokenId,
+		&token.FamilyId,
+		&token.UserId,
+		&token.TokenHash,
+		&token.Used,
+		&token.Revoked,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed flags the token as rotated. It reports false when the
+// token had already been used, so concurrent rotations cannot both succeed.
+func (psql *PostgresDBClient) MarkRefreshTokenUsed(token_id string) (bool, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET used = TRUE WHERE token_id = $1 AND used = FALSE`, psql.refreshTokenTable)
+	result, err := psql.db.Exec(queryString, token_id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (psql *PostgresDBClient) RevokeRefreshTokenFamily(family_id string) (string, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET revoked = TRUE WHERE family_id = $1`, psql.refreshTokenTable)
+	_, err := psql.db.Exec(queryString, family_id)
+	if err != nil {
+		return "", err
+	}
+	return "Token family revoked successfully", nil
+}
+
+func (psql *PostgresDBClient) RevokeUserRefreshTokens(user_id string) (string, error) {
+	queryString := fmt.Sprintf(`UPDATE %s SET revoked = TRUE WHERE user_id = $1`, psql.refreshTokenTable)
+	_, err := psql.db.Exec(queryString, user_id)
+	if err != nil {
+		return "", err
+	}
+	return "User tokens revoked successfully", nil
+}
+
+func (psql *PostgresDBClient) RevokeToken(jti string, expiresAt time.Time) error {
+	queryString := fmt.Sprintf(`
+		INSERT INTO %s (jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`, psql.revokedTokenTable)
+	_, err := psql.db.Exec(queryString, jti, expiresAt)
+	if err != nil {
+		return err
+	}
+	return psql.purgeExpiredRevocations()
+}
+
+func (psql *PostgresDBClient) IsTokenRevoked(jti string) (bool, error) {
+	var revoked bool
+	queryString := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE jti = $1)`, psql.revokedTokenTable)
+	err := psql.db.QueryRow(queryString, jti).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+	return revoked, nil
+}
+
+func (psql *PostgresDBClient) RevokeUserTokens(user_id string, revokedAt, expiresAt time.Time) error {
+	queryString := fmt.Sprintf(`
+		INSERT INTO %s (user_id, revoked_at, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			revoked_at = EXCLUDED.revoked_at,
+			expires_at = EXCLUDED.expires_at`, psql.revokedUserTable)
+	_, err := psql.db.Exec(queryString, user_id, revokedAt, expiresAt)
+	if err != nil {
+		return err
+	}
+	return psql.purgeExpiredRevocations()
+}
+
+// ReadUserTokensRevokedAt returns the zero time when the user has no active
+// revocation.
+func (psql *PostgresDBClient) ReadUserTokensRevokedAt(user_id string) (time.Time, error) {
+	var revokedAt time.Time
+	queryString := fmt.Sprintf(`SELECT revoked_at FROM %s WHERE user_id = $1 AND expires_at > NOW()`, psql.revokedUserTable)
+	err := psql.db.QueryRow(queryString, user_id).Scan(&revokedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return revokedAt, nil
+}
+
+func (psql *PostgresDBClient) purgeExpiredRevocations() error {
+	for _, tablename := range []string{psql.revokedTokenTable, psql.revokedUserTable} {
+		queryString := fmt.Sprintf(`DELETE FROM %s WHERE expires_at < NOW()`, tablename)
+		_, err := psql.db.Exec(queryString)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (psql *PostgresDBClient) CreateOneTimeToken(token *domain.OneTimeToken) (*domain.OneTimeToken, error) {
+	query := fmt.Sprintf(
+		`INSERT INTO %s
+			(
+				token_hash,
+				user_id,
+				purpose,
+				expires_at,
+				created_at
+			)
+		VALUES
+			($1,$2,$3,$4,$5)`,
+		psql.oneTimeTokenTable)
+	_, err := psql.db.Exec(
+		query,
+		token.TokenHash,
+		token.UserId,
+		token.Purpose,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ReadOneTimeToken returns an unused, unexpired token without using it up.
+func (psql *PostgresDBClient) ReadOneTimeToken(token_hash, purpose string) (*domain.OneTimeToken, error) {
+	var token domain.OneTimeToken
+	queryString := fmt.Sprintf(`
+		SELECT
+			token_hash,
+			user_id,
+			purpose,
+			expires_at,
+			created_at
+		FROM %s
+		WHERE
+			token_hash = $1 AND
+			purpose = $2 AND
+			used_at IS NULL AND
+			expires_at > NOW()`, psql.oneTimeTokenTable)
+	err := psql.db.QueryRow(
+		queryString,
+		token_hash,
+		purpose).Scan(
+		&token.TokenHash,
+		&token.UserId,
+		&token.Purpose,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeOneTimeToken marks an unused, unexpired token as used and returns
+// it. The update is a single statement so a token can only be consumed once.
+func (psql *PostgresDBClient) ConsumeOneTimeToken(token_hash, purpose string) (*domain.OneTimeToken, error) {
+	var token domain.OneTimeToken
+	queryString := fmt.Sprintf(`
+		UPDATE %s SET
+			used_at = NOW()
+		WHERE
+			token_hash = $1 AND
+			purpose = $2 AND
+			used_at IS NULL AND
+			expires_at > NOW()
+		RETURNING
+			token_hash,
+			user_id,
+			purpose,
+			expires_at,
+			created_at`, psql.oneTimeTokenTable)
+	err := psql.db.QueryRow(
+		queryString,
+		token_hash,
+		purpose).Scan(
+		&token.TokenHash,
+		&token.UserId,
+		&token.Purpose,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (psql *PostgresDBClient) ReadLatestOneTimeToken(user_id, purpose string) (*domain.OneTimeToken, error) {
+	var token domain.OneTimeToken
+	queryString := fmt.Sprintf(`
+		SELECT
+			token_hash,
+			user_id,
+			purpose,
+			expires_at,
+			created_at
+		FROM %s
+		WHERE
+			user_id = $1 AND
+			purpose = $2
+		ORDER BY created_at DESC
+		LIMIT 1`, psql.oneTimeTokenTable)
+	err := psql.db.QueryRow(
+		queryString,
+		user_id,
+		purpose).Scan(
+		&token.TokenHash,
+		&token.UserId,
+		&token.Purpose,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (psql *PostgresDBClient) DeleteOneTimeTokens(user_id, purpose string) (string, error) {
+	queryString := fmt.Sprintf(`DELETE FROM %s WHERE user_id = $1 AND purpose = $2`, psql.oneTimeTokenTable)
+	_, err := psql.db.Exec(queryString, user_id, purpose)
+	if err != nil {
+		return "", err
+	}
+	return "Tokens deleted successfully", nil
+}
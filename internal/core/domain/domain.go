@@ -1,11 +1,10 @@
 package domain
 
 import (
+	"crypto"
 	"strconv"
 	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type FollowUser struct {
@@ -19,20 +18,155 @@ type FollowUser struct {
 }
 
 type User struct {
-	UserId       string       `json:"user_id"`
-	GitHubId     string       `json:"github_id"`
-	LinkedInId   string       `json:"linkedin_id"`
-	Firstname    string       `json:"firstname"`
-	Lastname     string       `json:"lastname"`
-	Email        string       `json:"email"`
-	Password     string       `json:"password"`
-	Handle       string       `json:"handle"`
-	About        string       `json:"about"`
-	Articles     []Article    `json:"articles"`
-	ProfileImage string       `json:"profile_image"`
-	Following    []FollowUser `json:"following"`
-	Followers    []FollowUser `json:"followers"`
-	AccessToken  string       `json:"access_token"`
+	UserId        string       `json:"user_id"`
+	GitHubId      string       `json:"github_id"`
+	LinkedInId    string       `json:"linkedin_id"`
+	Firstname     string       `json:"firstname"`
+	Lastname      string       `json:"lastname"`
+	Email         string       `json:"email"`
+	EmailVerified bool         `json:"email_verified"`
+	Password      string       `json:"password"`
+	Handle        string       `json:"handle"`
+	About         string       `json:"about"`
+	Articles      []Article    `json:"articles"`
+	ProfileImage  string       `json:"profile_image"`
+	Following     []FollowUser `json:"following"`
+	Followers     []FollowUser `json:"followers"`
+	AccessToken   string       `json:"-"`
+	TOTPEnabled   bool         `json:"totp_enabled"`
+}
+
+// UserInfo is the public projection of a user returned by the userinfo
+// endpoint. Claim names follow OpenID Connect.
+type UserInfo struct {
+	Sub               string `json:"sub"`
+	Name              string `json:"name"`
+	GivenName         string `json:"given_name"`
+	FamilyName        string `json:"family_name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+	About             string `json:"about"`
+}
+
+func (u User) UserInfo() UserInfo {
+	return UserInfo{
+		Sub:               u.UserId,
+		Name:              strings.TrimSpace(u.Firstname + " " + u.Lastname),
+		GivenName:         u.Firstname,
+		FamilyName:        u.Lastname,
+		PreferredUsername: u.Handle,
+		Picture:           u.ProfileImage,
+		About:             u.About,
+	}
+}
+
+// Built-in roles. Users with no assigned role have RoleUser.
+const (
+	RoleUser      = "user"
+	RoleAuthor    = "author"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions granted through roles. PermissionAdmin guards the admin routes.
+const (
+	PermissionAdmin            = "admin"
+	PermissionReadUsers        = "users:read"
+	PermissionManageUsers      = "users:manage"
+	PermissionWriteArticles    = "articles:write"
+	PermissionModerateArticles = "articles:moderate"
+)
+
+// ScopeProfile lets a personal access token edit its owner's profile. The
+// other scopes a token can carry are permission names, and only take effect
+// while the owner's roles still grant them.
+const ScopeProfile = "profile"
+
+// PersonalAccessTokenScopes are the scopes a personal access token can carry.
+// Admin and user management stay with interactive sessions.
+var PersonalAccessTokenScopes = []string{ScopeProfile, PermissionReadUsers, PermissionWriteArticles, PermissionModerateArticles}
+
+// Scopes carried by service tokens. ScopeInternalUsersRead and
+// ScopeIntrospect are granted to registered clients of this service;
+// ScopeArticlesRead is requested by this service when it calls the articles
+// service.
+const (
+	ScopeInternalUsersRead = "internal:users:read"
+	ScopeIntrospect        = "internal:introspect"
+	ScopeArticlesRead      = "articles:read"
+)
+
+// ServiceScopes are the scopes a service client can be registered with.
+var ServiceScopes = []string{ScopeInternalUsersRead, ScopeIntrospect}
+
+// Role groups the permissions granted to the users assigned to it.
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// DefaultRoles are created when the database is migrated.
+var DefaultRoles = []Role{
+	{
+		Name:        RoleUser,
+		Description: "Reads articles and manages their own profile",
+		Permissions: []string{},
+	},
+	{
+		Name:        RoleAuthor,
+		Description: "Publishes articles",
+		Permissions: []string{PermissionWriteArticles},
+	},
+	{
+		Name:        RoleModerator,
+		Description: "Moderates articles and user profiles",
+		Permissions: []string{PermissionWriteArticles, PermissionModerateArticles, PermissionReadUsers, PermissionManageUsers},
+	},
+	{
+		Name:        RoleAdmin,
+		Description: "Administers the service",
+		Permissions: []string{PermissionAdmin, PermissionWriteArticles, PermissionModerateArticles, PermissionReadUsers, PermissionManageUsers},
+	},
+}
+
+// Actor is the authenticated user a request is made on behalf of.
+type Actor struct {
+	UserId string   `json:"user_id"`
+	Roles  []string `json:"roles"`
+}
+
+// Actions recorded in the audit log.
+const (
+	AuditActionUpdateUser          = "user.update"
+	AuditActionDeleteUser          = "user.delete"
+	AuditActionImpersonate         = "user.impersonate"
+	AuditActionImpersonatedRequest = "user.impersonated_request"
+)
+
+// Outcomes recorded in the audit log. An override is an allowed action on
+// another user's account.
+const (
+	AuditOutcomeAllowed  = "allowed"
+	AuditOutcomeDenied   = "denied"
+	AuditOutcomeOverride = "override"
+)
+
+// AuditEvent records an authorization decision worth keeping, such as a
+// denied request or a moderator acting on another user's account.
+type AuditEvent struct {
+	EventId   string    `json:"event_id"`
+	ActorId   string    `json:"actor_id"`
+	Action    string    `json:"action"`
+	TargetId  string    `json:"target_id"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
 }
 
 type Article struct {
@@ -46,9 +180,96 @@ type Article struct {
 	AuthorID     string    `json:"author_id"`
 }
 
-func (u User) CheckPasswordHarsh(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-	return err == nil
+type RefreshToken struct {
+	TokenId   string    `json:"token_id"`
+	FamilyId  string    `json:"family_id"`
+	UserId    string    `json:"user_id"`
+	TokenHash string    `json:"-"`
+	Used      bool      `json:"used"`
+	Revoked   bool      `json:"revoked"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session is one signed-in device. Its id is the family id of the refresh
+// tokens issued to that device, and access tokens carry it as the sid claim.
+type Session struct {
+	SessionId  string    `json:"session_id"`
+	UserId     string    `json:"user_id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// PersonalAccessToken lets a user call the API from scripts without their
+// password. The token itself is shown once; only its hash is stored.
+type PersonalAccessToken struct {
+	TokenId    string    `json:"token_id"`
+	UserId     string    `json:"user_id"`
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes"`
+	TokenHash  string    `json:"-"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ServiceClient is another Notelify service allowed to call internal routes
+// with the client credentials grant. Only the hash of its secret is stored.
+type ServiceClient struct {
+	ClientId   string    `json:"client_id"`
+	Name       string    `json:"name"`
+	SecretHash string    `json:"-"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ServiceToken is an access token issued to a service client.
+type ServiceToken struct {
+	AccessToken string
+	ClientId    string
+	Scopes      []string
+	ExpiresAt   time.Time
+}
+
+// OneTimeToken is a single-use token mailed to a user, such as a password
+// reset link. Only the hash of the token is stored.
+type OneTimeToken struct {
+	TokenHash string    `json:"-"`
+	UserId    string    `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginAttempt tracks failed logins for one key, such as an account or a
+// client IP.
+type LoginAttempt struct {
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+type MailMessage struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// SigningKey is one entry in the JWT signing key ring. A key signs new tokens
+// until RotatesAt and stays published for verification until ExpiresAt.
+type SigningKey struct {
+	KeyId      string            `json:"kid"`
+	Algorithm  string            `json:"alg"`
+	PrivateKey crypto.PrivateKey `json:"-"`
+	PublicKey  crypto.PublicKey  `json:"-"`
+	CreatedAt  time.Time         `json:"created_at"`
+	RotatesAt  time.Time         `json:"rotates_at"`
+	ExpiresAt  time.Time         `json:"expires_at"`
 }
 
 type LogMessage struct {
@@ -63,14 +284,28 @@ type GithubUser struct {
 	Firstname   string `json:"firstname"`
 	Lastname    string `json:"lastname"`
 	AvatarURL   string `json:"avatar_url"`
-	AccessToken string `json:"access_token"`
+	AccessToken string `json:"-"`
 	Email       string `json:"email"`
-	Handle      string `json:"handle"`
+	Handle      string `json:"login"`
+}
+
+// GithubEmail is one entry of GitHub's /user/emails response.
+type GithubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
 }
 
+// InitGithubUser maps a GitHub profile to a user. Email must already hold a
+// verified address, since it is stored as verified.
 func (g *GithubUser) InitGithubUser() User {
-	nameParts := strings.Split(g.Name, " ")
-	if len(nameParts) >= 2 {
+	nameParts := strings.Fields(g.Name)
+	switch len(nameParts) {
+	case 0:
+		g.Firstname = g.Handle
+	case 1:
+		g.Firstname = nameParts[0]
+	default:
 		g.Firstname = nameParts[0]
 		g.Lastname = strings.Join(nameParts[1:], " ")
 	}
@@ -81,7 +316,7 @@ func (g *GithubUser) InitGithubUser() User {
 		LinkedInId:   "",
 		Firstname:    g.Firstname,
 		Lastname:     g.Lastname,
-		Email:        "",
+		Email:        g.Email,
 		Password:     "",
 		Handle:       g.Handle,
 		About:        "",
@@ -91,6 +326,17 @@ func (g *GithubUser) InitGithubUser() User {
 		Followers:    []FollowUser{},
 		AccessToken:  g.AccessToken,
 	}
+	user.EmailVerified = g.Email != ""
 
 	return user
 }
+
+// UserIdentity links a user to their account at an external identity
+// provider, identified by the provider's subject.
+type UserIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserId    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
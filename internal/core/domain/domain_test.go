@@ -0,0 +1,38 @@
+package domain
+
+import "testing"
+
+func TestInitGithubUserName(t *testing.T) {
+	tests := []struct {
+		name      string
+		firstname string
+		lastname  string
+	}{
+		{"", "octocat", ""},
+		{"   ", "octocat", ""},
+		{"Ada", "Ada", ""},
+		{"Ada Lovelace", "Ada", "Lovelace"},
+		{" Ada  King Lovelace ", "Ada", "King Lovelace"},
+	}
+	for _, tt := range tests {
+		github := GithubUser{ID: 42, Name: tt.name, Handle: "octocat"}
+		user := github.InitGithubUser()
+		if user.Firstname != tt.firstname || user.Lastname != tt.lastname {
+			t.Errorf("name %q split into %q %q, want %q %q", tt.name, user.Firstname, user.Lastname, tt.firstname, tt.lastname)
+		}
+		if user.GitHubId != "42" || user.Handle != "octocat" {
+			t.Errorf("name %q: user %+v", tt.name, user)
+		}
+	}
+}
+
+func TestInitGithubUserEmailVerified(t *testing.T) {
+	github := GithubUser{ID: 42, Handle: "octocat"}
+	if user := github.InitGithubUser(); user.EmailVerified {
+		t.Errorf("user without an email is marked verified")
+	}
+	github.Email = "octocat@example.com"
+	if user := github.InitGithubUser(); !user.EmailVerified || user.Email != "octocat@example.com" {
+		t.Errorf("user %+v, want the verified email", user)
+	}
+}
@@ -1,6 +1,11 @@
 package ports
 
-import "github.com/AntonyIS/notelify-users-service/internal/core/domain"
+import (
+	"context"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
 
 type UserService interface {
 	CreateUser(user *domain.User) (*domain.User, error)
@@ -9,9 +14,26 @@ type UserService interface {
 	ReadUserWithLinkedinId(user_id string) (*domain.User, error)
 	ReadUserWithEmail(email string) (*domain.User, error)
 	ReadUsers() ([]domain.User, error)
-	UpdateUser(user *domain.User) (*domain.User, error)
-	DeleteUser(user_id string) (string, error)
+	UpdateUser(actor *domain.Actor, user *domain.User) (*domain.User, error)
+	DeleteUser(actor *domain.Actor, user_id string) (string, error)
 	DeleteAllUsers() (string, error)
+	RequestPasswordReset(email string) error
+	ResetPassword(token, newPassword string) (*domain.User, error)
+	SetPassword(user_id, currentPassword, newPassword string) error
+	Authenticate(email, password string) (*domain.User, error)
+	VerifyEmail(token string) (*domain.User, error)
+	ResendVerificationEmail(email string) error
+	RequestMagicLink(email string) error
+	LoginWithMagicLink(token string) (*domain.User, error)
+	EnrollTOTP(user_id string) (*domain.TOTPEnrollment, error)
+	ConfirmTOTP(user_id, code string) ([]string, error)
+	DisableTOTP(user_id, code string) error
+	VerifyTOTP(user_id, code string) error
+	LoginWithIdentity(user *domain.User, identity *domain.UserIdentity) (*domain.User, bool, error)
+	LinkIdentity(user_id string, identity *domain.UserIdentity) error
+	ConfirmIdentityLink(user_id, linkToken string) (*domain.UserIdentity, error)
+	UnlinkIdentity(user_id, provider string) error
+	ReadUserIdentities(user_id string) ([]domain.UserIdentity, error)
 }
 
 type UserRepository interface {
@@ -22,10 +44,206 @@ type UserRepository interface {
 	ReadUserWithEmail(email string) (*domain.User, error)
 	ReadUsers() ([]domain.User, error)
 	UpdateUser(user *domain.User) (*domain.User, error)
+	ReadUserPassword(user_id string) (string, error)
+	UpdateUserPassword(user_id, password string) (string, error)
+	UpdateUserEmailVerified(user_id string, verified bool) (string, error)
+	ReadUserAccessTokens() (map[string]string, error)
+	UpdateUserAccessToken(user_id, accessToken string) (string, error)
 	DeleteUser(user_id string) (string, error)
 	DeleteAllUsers() (string, error)
 }
 
+type MFARepository interface {
+	UpdateUserTOTP(user_id, secret string, enabled bool) (string, error)
+	ReadUserTOTPSecret(user_id string) (string, error)
+	ReadUserTOTPSecrets() (map[string]string, error)
+	ReplaceUserTOTPSecret(user_id, secret string) (string, error)
+	UpdateUserTOTPLastStep(user_id string, step int64) (bool, error)
+	CreateRecoveryCodes(user_id string, code_hashes []string) (string, error)
+	ConsumeRecoveryCode(user_id, code_hash string) (bool, error)
+	DeleteRecoveryCodes(user_id string) (string, error)
+}
+
+type IdentityRepository interface {
+	CreateUserIdentity(identity *domain.UserIdentity) (*domain.UserIdentity, error)
+	ReadUserIdentity(provider, subject string) (*domain.UserIdentity, error)
+	ReadUserIdentities(user_id string) ([]domain.UserIdentity, error)
+	DeleteUserIdentity(user_id, provider string) (string, error)
+}
+
+// IdentityProvider is an external login provider. Exchange trades an
+// authorization code for the user's profile and their identity at the
+// provider.
+type IdentityProvider interface {
+	Name() string
+	AuthCodeURL(ctx context.Context, state, verifier string) (string, error)
+	Exchange(ctx context.Context, code, verifier string) (*domain.User, *domain.UserIdentity, error)
+}
+
+type IdentityProviderRegistry interface {
+	Provider(name string) (IdentityProvider, bool)
+}
+
+type OneTimeTokenRepository interface {
+	CreateOneTimeToken(token *domain.OneTimeToken) (*domain.OneTimeToken, error)
+	ReadOneTimeToken(token_hash, purpose string) (*domain.OneTimeToken, error)
+	ConsumeOneTimeToken(token_hash, purpose string) (*domain.OneTimeToken, error)
+	ReadLatestOneTimeToken(user_id, purpose string) (*domain.OneTimeToken, error)
+	DeleteOneTimeTokens(user_id, purpose string) (string, error)
+}
+
+type Mailer interface {
+	SendMail(message domain.MailMessage) error
+}
+
+type TokenService interface {
+	IssueRefreshToken(user_id string) (*domain.RefreshToken, string, error)
+	RotateRefreshToken(refreshToken string) (*domain.RefreshToken, string, error)
+	RevokeRefreshToken(user_id, refreshToken string) error
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(jti, session_id, user_id string, issuedAt time.Time) (bool, error)
+	RevokeSessionTokens(session_id string) error
+	LogoutAllSessions(user_id string) error
+}
+
+type SessionService interface {
+	RecordSession(user_id, session_id, userAgent, ip string) (*domain.Session, error)
+	TouchSession(session_id, ip string)
+	ReadSessions(user_id string) ([]domain.Session, error)
+	RevokeSession(user_id, session_id string) error
+	RevokeAllSessions(user_id string) error
+}
+
+type SessionRepository interface {
+	CreateSession(session *domain.Session) (*domain.Session, error)
+	ReadUserSessions(user_id string, activeSince time.Time) ([]domain.Session, error)
+	ReadUserDevices(user_id string) ([]string, error)
+	UpdateSessionLastSeen(session_id, ip string, lastSeenAt time.Time) (string, error)
+	RevokeSession(user_id, session_id string) (bool, error)
+	RevokeUserSessions(user_id string) (string, error)
+}
+
+// LoginNotifier tells a user about sign-ins they may not recognise.
+type LoginNotifier interface {
+	NotifyNewDevice(user *domain.User, session *domain.Session) error
+}
+
+type RefreshTokenRepository interface {
+	CreateRefreshToken(token *domain.RefreshToken) (*domain.RefreshToken, error)
+	ReadRefreshTokenWithHash(token_hash string) (*domain.RefreshToken, error)
+	MarkRefreshTokenUsed(token_id string) (bool, error)
+	RevokeRefreshTokenFamily(family_id string) (string, error)
+	RevokeUserRefreshTokens(user_id string) (string, error)
+}
+
+type RevocationStore interface {
+	RevokeToken(jti string, expiresAt time.Time) error
+	IsTokenRevoked(jti string) (bool, error)
+	RevokeUserTokens(user_id string, revokedAt, expiresAt time.Time) error
+	ReadUserTokensRevokedAt(user_id string) (time.Time, error)
+}
+
+type PersonalAccessTokenService interface {
+	CreateToken(user_id, name string, scopes []string, ttl time.Duration) (*domain.PersonalAccessToken, string, error)
+	ReadTokens(user_id string) ([]domain.PersonalAccessToken, error)
+	RevokeToken(user_id, token_id string) error
+	RevokeUserTokens(user_id string) error
+	AuthenticateToken(token string) (*domain.PersonalAccessToken, error)
+}
+
+type PersonalAccessTokenRepository interface {
+	CreatePersonalAccessToken(token *domain.PersonalAccessToken) (*domain.PersonalAccessToken, error)
+	ReadPersonalAccessTokenWithHash(token_hash string) (*domain.PersonalAccessToken, error)
+	ReadUserPersonalAccessTokens(user_id string) ([]domain.PersonalAccessToken, error)
+	DeletePersonalAccessToken(user_id, token_id string) (bool, error)
+	DeleteUserPersonalAccessTokens(user_id string) (string, error)
+	UpdatePersonalAccessTokenLastUsed(token_id string, lastUsedAt time.Time) (string, error)
+}
+
+type ServiceClientService interface {
+	RegisterClient(name string, scopes []string) (*domain.ServiceClient, string, error)
+	IssueToken(client_id, secret string, scopes []string) (*domain.ServiceToken, error)
+	VerifyToken(token string) (*domain.ServiceToken, error)
+}
+
+type ServiceClientRepository interface {
+	CreateServiceClient(client *domain.ServiceClient) (*domain.ServiceClient, error)
+	ReadServiceClient(client_id string) (*domain.ServiceClient, error)
+}
+
+// ServiceTokenSource supplies the token this service presents when it calls
+// other Notelify services.
+type ServiceTokenSource interface {
+	ServiceToken(scopes ...string) (string, error)
+}
+
+type KeyService interface {
+	SigningKey() (*domain.SigningKey, error)
+	VerificationKey(kid string) (*domain.SigningKey, error)
+	PublicKeys() ([]domain.SigningKey, error)
+	RotateSigningKeys() error
+}
+
+type SigningKeyRepository interface {
+	CreateSigningKey(key *domain.SigningKey) (*domain.SigningKey, error)
+	ReadSigningKeys() ([]domain.SigningKey, error)
+	DeleteExpiredSigningKeys() (string, error)
+}
+
+type RoleService interface {
+	ReadRoles() ([]domain.Role, error)
+	ReadUserRoles(user_id string) ([]string, error)
+	AssignRole(user_id, role string) error
+	RemoveRole(user_id, role string) error
+	HasPermission(roles []string, permission string) (bool, error)
+}
+
+type RoleRepository interface {
+	ReadRoles() ([]domain.Role, error)
+	ReadUserRoles(user_id string) ([]string, error)
+	AssignUserRole(user_id, role string) (string, error)
+	RemoveUserRole(user_id, role string) (string, error)
+	CountUsersWithRole(role string) (int, error)
+}
+
+// ImpersonationPolicy decides who may sign in as another user and audits
+// what they do while signed in as them.
+type ImpersonationPolicy interface {
+	AuthorizeImpersonation(admin *domain.Actor, target_id, reason string) error
+	RecordImpersonatedRequest(admin_id, user_id, request string)
+}
+
+type AuditRepository interface {
+	CreateAuditEvent(event *domain.AuditEvent) (*domain.AuditEvent, error)
+}
+
+type LoginProtectionService interface {
+	CheckLoginAllowed(email, ip string) (time.Duration, error)
+	RecordLoginFailure(email, ip string)
+	RecordLoginSuccess(email string)
+	UnlockAccount(email string) error
+}
+
+type LoginAttemptStore interface {
+	ReadLoginAttempt(key string) (*domain.LoginAttempt, error)
+	RecordLoginFailure(key string, window time.Duration) (*domain.LoginAttempt, error)
+	LockLogin(key string, lockedUntil time.Time) error
+	ResetLoginAttempts(key string) error
+}
+
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encodedHash string) (bool, bool, error)
+}
+
+// SecretEncrypter encrypts secrets before they are stored, such as the
+// access tokens issued by identity providers and TOTP secrets.
+type SecretEncrypter interface {
+	Encrypt(plaintext string) (string, error)
+	Decrypt(value string) (string, error)
+	NeedsReencryption(value string) bool
+}
+
 type LoggingService interface {
 	SendLog(LogEntry domain.LogMessage)
 	LogDebug(LogEntry domain.LogMessage)
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Stored secrets, such as provider access tokens, use envelope encryption.
+// Each value is sealed with its own random data key, and the data key is
+// sealed with a key-encryption key (KEK) from config. A value is stored as
+//
+//	enc:v1:<kek id>:<sealed data key>:<sealed value>
+//
+// Rotating the KEK means adding a new key, making it current, and running
+// the reencrypt-tokens command before the old key is removed.
+const encryptedValuePrefix = "enc:v1:"
+
+var (
+	ErrEncryptionKeyNotSet  = errors.New("no token encryption key is configured")
+	ErrUnknownEncryptionKey = errors.New("value is encrypted with an unknown key")
+	ErrInvalidCiphertext    = errors.New("invalid encrypted value")
+)
+
+type EnvelopeEncryptionService struct {
+	keys      map[string][]byte
+	currentId string
+}
+
+// NewEnvelopeEncryptionService parses keys, a comma separated list of
+// id:key pairs where each key is 32 base64 encoded bytes. New values are
+// sealed with the key named currentId, or the first key when it is empty.
+// With no keys, Encrypt fails with ErrEncryptionKeyNotSet.
+func NewEnvelopeEncryptionService(keys, currentId string) (*EnvelopeEncryptionService, error) {
+	svc := EnvelopeEncryptionService{
+		keys: map[string][]byte{},
+	}
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found || id == "" {
+			return nil, fmt.Errorf("token encryption key %q must be written as id:key", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("token encryption key %q must be 32 base64 encoded bytes", id)
+		}
+		svc.keys[id] = key
+		if svc.currentId == "" {
+			svc.currentId = id
+		}
+	}
+
+	if currentId != "" {
+		if _, ok := svc.keys[currentId]; !ok {
+			return nil, fmt.Errorf("current token encryption key %q is not configured", currentId)
+		}
+		svc.currentId = currentId
+	}
+	return &svc, nil
+}
+
+func (svc *EnvelopeEncryptionService) Encrypt(plaintext string) (string, error) {
+	if svc.currentId == "" {
+		return "", ErrEncryptionKeyNotSet
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	sealedKey, err := sealGCM(svc.keys[svc.currentId], dataKey, []byte(svc.currentId))
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := sealGCM(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedValuePrefix + strings.Join([]string{
+		svc.currentId,
+		base64.RawStdEncoding.EncodeToString(sealedKey),
+		base64.RawStdEncoding.EncodeToString(sealedValue),
+	}, ":"), nil
+}
+
+// Decrypt opens a value from Encrypt. Values stored before encryption was
+// introduced are returned unchanged.
+func (svc *EnvelopeEncryptionService) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, encryptedValuePrefix), ":")
+	if len(parts) != 3 {
+		return "", ErrInvalidCiphertext
+	}
+	kek, ok := svc.keys[parts[0]]
+	if !ok {
+		return "", ErrUnknownEncryptionKey
+	}
+	sealedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	sealedValue, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	dataKey, err := openGCM(kek, sealedKey, []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openGCM(dataKey, sealedValue, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencryption reports whether value is in plain text or sealed with a
+// key other than the current one.
+func (svc *EnvelopeEncryptionService) NeedsReencryption(value string) bool {
+	if value == "" {
+		return false
+	}
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return true
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, encryptedValuePrefix), ":")
+	return id != svc.currentId
+}
+
+// sealGCM encrypts plaintext with AES-256-GCM and prepends the nonce.
+func sealGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// fakeAccessTokenRepository stores provider access tokens by user id.
+type fakeAccessTokenRepository struct {
+	fakeUserRepository
+	accessTokens map[string]string
+}
+
+func (repo fakeAccessTokenRepository) ReadUserAccessTokens() (map[string]string, error) {
+	return repo.accessTokens, nil
+}
+
+func (repo fakeAccessTokenRepository) UpdateUserAccessToken(user_id, accessToken string) (string, error) {
+	repo.accessTokens[user_id] = accessToken
+	return "", nil
+}
+
+// testKey returns a key-encryption key entry for NewEnvelopeEncryptionService.
+func testKey(t *testing.T, id string) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func TestEnvelopeEncryption(t *testing.T) {
+	k1 := testKey(t, "k1")
+	encrypter, err := NewEnvelopeEncryptionService(k1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := encrypter.Encrypt("provider-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, encryptedValuePrefix+"k1:") || strings.Contains(sealed, "provider-token") {
+		t.Fatalf("Encrypt returned %q", sealed)
+	}
+	if again, _ := encrypter.Encrypt("provider-token"); again == sealed {
+		t.Errorf("Encrypt is deterministic")
+	}
+	if plaintext, err := encrypter.Decrypt(sealed); err != nil || plaintext != "provider-token" {
+		t.Fatalf("Decrypt returned %q, %v", plaintext, err)
+	}
+	if plaintext, err := encrypter.Decrypt("legacy-token"); err != nil || plaintext != "legacy-token" {
+		t.Errorf("Decrypt of a plain text value returned %q, %v", plaintext, err)
+	}
+
+	parts := strings.Split(sealed, ":")
+	flip := func(part int) string {
+		tampered := append([]string{}, parts...)
+		raw, err := base64.RawStdEncoding.DecodeString(tampered[part])
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[len(raw)-1] ^= 1
+		tampered[part] = base64.RawStdEncoding.EncodeToString(raw)
+		return strings.Join(tampered, ":")
+	}
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{"tampered data key", flip(3), ErrInvalidCiphertext},
+		{"tampered value", flip(4), ErrInvalidCiphertext},
+		{"key id swapped", strings.Replace(sealed, ":k1:", ":k2:", 1), ErrUnknownEncryptionKey},
+		{"truncated", strings.Join(parts[:4], ":"), ErrInvalidCiphertext},
+	}
+	for _, tt := range tests {
+		if _, err := encrypter.Decrypt(tt.value); err != tt.err {
+			t.Errorf("%s: Decrypt returned %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// A value sealed under another key ring with the same key id does not
+	// open either, since the key itself differs.
+	other, err := NewEnvelopeEncryptionService(testKey(t, "k1"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Decrypt(sealed); err != ErrInvalidCiphertext {
+		t.Errorf("Decrypt with the wrong key returned %v, want %v", err, ErrInvalidCiphertext)
+	}
+
+	rotated, err := NewEnvelopeEncryptionService(k1+","+testKey(t, "k2"), "k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for value, want := range map[string]bool{
+		"":             false,
+		"legacy-token": true,
+		sealed:         true,
+	} {
+		if got := rotated.NeedsReencryption(value); got != want {
+			t.Errorf("NeedsReencryption(%q) = %v, want %v", value, got, want)
+		}
+	}
+	resealed, err := rotated.Encrypt("provider-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.NeedsReencryption(resealed) || !encrypter.NeedsReencryption(resealed) {
+		t.Errorf("NeedsReencryption does not follow the current key")
+	}
+}
+
+func TestNewEnvelopeEncryptionServiceRejectsBadKeys(t *testing.T) {
+	for _, keys := range []string{
+		"k1",
+		":" + base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		"k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)),
+		"k1:not base64",
+	} {
+		if _, err := NewEnvelopeEncryptionService(keys, ""); err == nil {
+			t.Errorf("NewEnvelopeEncryptionService(%q) succeeded", keys)
+		}
+	}
+	if _, err := NewEnvelopeEncryptionService(testKey(t, "k1"), "k2"); err == nil {
+		t.Errorf("an unconfigured current key was accepted")
+	}
+}
+
+func TestReencryptAfterKeyRotation(t *testing.T) {
+	k1 := testKey(t, "k1")
+	old, err := NewEnvelopeEncryptionService(k1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedToken, err := old.Encrypt("token-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedSecret, err := old.Encrypt("SECRET1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := map[string]*domain.User{}
+	repo := fakeAccessTokenRepository{
+		fakeUserRepository: fakeUserRepository{users: users},
+		accessTokens: map[string]string{
+			"user-1": sealedToken,
+			"user-2": "legacy-token",
+		},
+	}
+	mfa := newFakeMFARepository(users)
+	mfa.secrets["user-1"] = sealedSecret
+	mfa.secrets["user-2"] = "LEGACYSECRET"
+	mfa.lastSteps["user-1"] = 42
+
+	rotated, err := NewEnvelopeEncryptionService(k1+","+testKey(t, "k2"), "k2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewUserManagementService(repo, nil, mfa, nil, nil, fakeLogger{}, nil, nil, rotated, nil, config.Config{})
+
+	if updated, err := svc.ReencryptProviderTokens(); err != nil || updated != 2 {
+		t.Fatalf("ReencryptProviderTokens returned %d, %v", updated, err)
+	}
+	if updated, err := svc.ReencryptTOTPSecrets(); err != nil || updated != 2 {
+		t.Fatalf("ReencryptTOTPSecrets returned %d, %v", updated, err)
+	}
+
+	want := map[string]string{
+		repo.accessTokens["user-1"]: "token-1",
+		repo.accessTokens["user-2"]: "legacy-token",
+		mfa.secrets["user-1"]:       "SECRET1",
+		mfa.secrets["user-2"]:       "LEGACYSECRET",
+	}
+	for stored, plaintext := range want {
+		if !strings.HasPrefix(stored, encryptedValuePrefix+"k2:") {
+			t.Errorf("%q is not sealed with the new key", stored)
+		}
+		if got, err := rotated.Decrypt(stored); err != nil || got != plaintext {
+			t.Errorf("Decrypt(%q) = %q, %v, want %q", stored, got, err, plaintext)
+		}
+	}
+	if mfa.lastSteps["user-1"] != 42 {
+		t.Errorf("re-encrypting reset the TOTP replay counter")
+	}
+
+	// Running it again finds nothing left to do.
+	if updated, err := svc.ReencryptProviderTokens(); err != nil || updated != 0 {
+		t.Errorf("second ReencryptProviderTokens returned %d, %v", updated, err)
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnknownPasswordHash     = errors.New("unknown password hash format")
+	ErrUnsupportedPasswordHash = errors.New("unsupported password hashing algorithm")
+)
+
+// Argon2Params are the cost parameters for new Argon2id hashes. Memory is in
+// KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// passwordAlgorithm is one hashing scheme the hasher can verify. Stored
+// hashes are matched to a scheme by their prefix.
+type passwordAlgorithm interface {
+	matches(encodedHash string) bool
+	hash(password string) (string, error)
+	verify(password, encodedHash string) (bool, error)
+	// outdated reports whether encodedHash is weaker than what hash produces.
+	outdated(encodedHash string) bool
+}
+
+// PasswordHashService hashes new passwords with the preferred algorithm and
+// verifies hashes made by any algorithm it knows.
+type PasswordHashService struct {
+	preferred  passwordAlgorithm
+	algorithms []passwordAlgorithm
+}
+
+// NewPasswordHashService hashes new passwords with algorithm ("argon2id" or
+// "bcrypt").
+func NewPasswordHashService(algorithm string, argon2Params Argon2Params, bcryptCost int) (*PasswordHashService, error) {
+	argon2id := argon2idAlgorithm{params: argon2Params}
+	bcryptHash := bcryptAlgorithm{cost: bcryptCost}
+
+	svc := PasswordHashService{
+		algorithms: []passwordAlgorithm{argon2id, bcryptHash},
+	}
+	switch algorithm {
+	case "argon2id":
+		svc.preferred = argon2id
+	case "bcrypt":
+		svc.preferred = bcryptHash
+	default:
+		return nil, ErrUnsupportedPasswordHash
+	}
+	return &svc, nil
+}
+
+func (svc *PasswordHashService) Hash(password string) (string, error) {
+	return svc.preferred.hash(password)
+}
+
+// Verify checks password against encodedHash. needsRehash is set when the
+// password matched but the hash was made with a different algorithm or
+// weaker parameters than new hashes get.
+func (svc *PasswordHashService) Verify(password, encodedHash string) (match bool, needsRehash bool, err error) {
+	for _, algorithm := range svc.algorithms {
+		if !algorithm.matches(encodedHash) {
+			continue
+		}
+		match, err := algorithm.verify(password, encodedHash)
+		if err != nil || !match {
+			return false, false, err
+		}
+		needsRehash = algorithm != svc.preferred || algorithm.outdated(encodedHash)
+		return true, needsRehash, nil
+	}
+	return false, false, ErrUnknownPasswordHash
+}
+
+type bcryptAlgorithm struct {
+	cost int
+}
+
+func (a bcryptAlgorithm) matches(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") || strings.HasPrefix(encodedHash, "$2b$") || strings.HasPrefix(encodedHash, "$2y$")
+}
+
+func (a bcryptAlgorithm) hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), a.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+func (a bcryptAlgorithm) verify(password, encodedHash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (a bcryptAlgorithm) outdated(encodedHash string) bool {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	return err != nil || cost < a.cost
+}
+
+// argon2idAlgorithm stores hashes in the PHC string format used by the
+// reference implementation:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+type argon2idAlgorithm struct {
+	params Argon2Params
+}
+
+func (a argon2idAlgorithm) matches(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$argon2id$")
+}
+
+func (a argon2idAlgorithm) hash(password string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.params.Memory, a.params.Iterations, a.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a argon2idAlgorithm) verify(password, encodedHash string) (bool, error) {
+	params, salt, key, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
+
+func (a argon2idAlgorithm) outdated(encodedHash string) bool {
+	params, salt, _, err := decodeArgon2idHash(encodedHash)
+	if err != nil {
+		return true
+	}
+	return params.Memory < a.params.Memory ||
+		params.Iterations < a.params.Iterations ||
+		params.Parallelism < a.params.Parallelism ||
+		params.KeyLength < a.params.KeyLength ||
+		uint32(len(salt)) < a.params.SaltLength
+}
+
+func decodeArgon2idHash(encodedHash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	if version != argon2.Version {
+		return params, nil, nil, ErrUnsupportedPasswordHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// testArgon2Params keeps Argon2id cheap enough for tests.
+var testArgon2Params = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func newTestHasher(t *testing.T, algorithm string, argon2Params Argon2Params, bcryptCost int) *PasswordHashService {
+	t.Helper()
+	hasher, err := NewPasswordHashService(algorithm, argon2Params, bcryptCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func mustHash(t *testing.T, hasher *PasswordHashService, password string) string {
+	t.Helper()
+	encodedHash, err := hasher.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodedHash
+}
+
+func TestPasswordHashVerify(t *testing.T) {
+	argon2Hash := mustHash(t, newTestHasher(t, "argon2id", testArgon2Params, 4), "correct horse")
+	weakParams := testArgon2Params
+	weakParams.Memory = 512
+	weakArgon2Hash := mustHash(t, newTestHasher(t, "argon2id", weakParams, 4), "correct horse")
+	bcryptHash := mustHash(t, newTestHasher(t, "bcrypt", testArgon2Params, 4), "correct horse")
+
+	argon2Hasher := newTestHasher(t, "argon2id", testArgon2Params, 4)
+	bcryptHasher := newTestHasher(t, "bcrypt", testArgon2Params, 4)
+	costlierBcryptHasher := newTestHasher(t, "bcrypt", testArgon2Params, 5)
+
+	tests := []struct {
+		name        string
+		hasher      *PasswordHashService
+		password    string
+		encodedHash string
+		match       bool
+		needsRehash bool
+		err         error
+	}{
+		{"argon2id", argon2Hasher, "correct horse", argon2Hash, true, false, nil},
+		{"argon2id wrong password", argon2Hasher, "wrong horse", argon2Hash, false, false, nil},
+		{"argon2id weaker parameters", argon2Hasher, "correct horse", weakArgon2Hash, true, true, nil},
+		{"argon2id weaker parameters wrong password", argon2Hasher, "wrong horse", weakArgon2Hash, false, false, nil},
+		{"bcrypt under argon2id", argon2Hasher, "correct horse", bcryptHash, true, true, nil},
+		{"bcrypt $2b$ under argon2id", argon2Hasher, "correct horse", "$2b$" + strings.TrimPrefix(bcryptHash, "$2a$"), true, true, nil},
+		{"bcrypt $2y$ under argon2id", argon2Hasher, "correct horse", "$2y$" + strings.TrimPrefix(bcryptHash, "$2a$"), true, true, nil},
+		{"bcrypt wrong password", argon2Hasher, "wrong horse", bcryptHash, false, false, nil},
+		{"bcrypt", bcryptHasher, "correct horse", bcryptHash, true, false, nil},
+		{"bcrypt lower cost", costlierBcryptHasher, "correct horse", bcryptHash, true, true, nil},
+		{"argon2id under bcrypt", bcryptHasher, "correct horse", argon2Hash, true, true, nil},
+		{"unknown prefix", argon2Hasher, "correct horse", "$md5$abc", false, false, ErrUnknownPasswordHash},
+		{"plain text", argon2Hasher, "correct horse", "correct horse", false, false, ErrUnknownPasswordHash},
+		{"malformed argon2id", argon2Hasher, "correct horse", "$argon2id$v=19$m=1024", false, false, ErrUnknownPasswordHash},
+		{"other argon2 version", argon2Hasher, "correct horse", strings.Replace(argon2Hash, "$v=19$", "$v=16$", 1), false, false, ErrUnsupportedPasswordHash},
+	}
+	for _, tt := range tests {
+		match, needsRehash, err := tt.hasher.Verify(tt.password, tt.encodedHash)
+		if match != tt.match || needsRehash != tt.needsRehash || err != tt.err {
+			t.Errorf("%s: Verify returned %v, %v, %v, want %v, %v, %v", tt.name, match, needsRehash, err, tt.match, tt.needsRehash, tt.err)
+		}
+	}
+}
+
+func TestNewPasswordHashServiceRejectsUnknownAlgorithm(t *testing.T) {
+	if _, err := NewPasswordHashService("md5", testArgon2Params, 4); err != ErrUnsupportedPasswordHash {
+		t.Errorf("returned %v, want %v", err, ErrUnsupportedPasswordHash)
+	}
+}
+
+func TestAuthenticateRehashesOutdatedPasswords(t *testing.T) {
+	bcryptHash := mustHash(t, newTestHasher(t, "bcrypt", testArgon2Params, 4), "correct horse")
+	argon2Hash := mustHash(t, newTestHasher(t, "argon2id", testArgon2Params, 4), "correct horse")
+
+	tests := []struct {
+		name     string
+		stored   string
+		password string
+		err      error
+		// prefix is what the stored hash starts with afterwards.
+		prefix string
+	}{
+		{"bcrypt is upgraded", bcryptHash, "correct horse", nil, "$argon2id$"},
+		{"argon2id is kept", argon2Hash, "correct horse", nil, argon2Hash},
+		{"wrong password keeps bcrypt", bcryptHash, "wrong horse", ErrInvalidCredentials, bcryptHash},
+	}
+	for _, tt := range tests {
+		users := map[string]*domain.User{"user-1": {UserId: "user-1", Email: "ada@example.com", Password: tt.stored}}
+		svc := NewUserManagementService(fakeUserRepository{users: users}, nil, nil, nil, nil, fakeLogger{}, nil, newTestHasher(t, "argon2id", testArgon2Params, 4), nil, nil, config.Config{})
+
+		user, err := svc.Authenticate("ada@example.com", tt.password)
+		if err != tt.err {
+			t.Errorf("%s: Authenticate returned %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && user.Password != "" {
+			t.Errorf("%s: Authenticate returned the password hash", tt.name)
+		}
+		if stored := users["user-1"].Password; !strings.HasPrefix(stored, tt.prefix) {
+			t.Errorf("%s: stored hash %q, want prefix %q", tt.name, stored, tt.prefix)
+		}
+		if _, err := svc.Authenticate("ada@example.com", "correct horse"); err != nil {
+			t.Errorf("%s: signing in again returned %v", tt.name, err)
+		}
+	}
+
+	svc := NewUserManagementService(fakeUserRepository{users: map[string]*domain.User{}}, nil, nil, nil, nil, fakeLogger{}, nil, newTestHasher(t, "argon2id", testArgon2Params, 4), nil, nil, config.Config{})
+	if _, err := svc.Authenticate("nobody@example.com", "correct horse"); err != ErrInvalidCredentials {
+		t.Errorf("unknown email returned %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy, err := NewPasswordPolicy(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &domain.User{Email: "Ada.Lovelace@example.com", Handle: "countess"}
+
+	tests := []struct {
+		name     string
+		password string
+		user     *domain.User
+		valid    bool
+	}{
+		{"long enough", "a long new password", user, true},
+		{"too short", "short pw", user, false},
+		{"minimum length", "abcdefghij", user, true},
+		{"length counted in runes", "ééééééééé", nil, false},
+		{"too long", strings.Repeat("a", maxPasswordLength+1), nil, false},
+		{"maximum length", strings.Repeat("ab", maxPasswordLength/2), nil, true},
+		{"common", "qwertyuiop", nil, false},
+		{"common in another case", "QwertyUiop", nil, false},
+		{"contains the email", "my ada.lovelace@example.com", user, false},
+		{"contains the email's local part", "xxADA.LOVELACExx", user, false},
+		{"contains the handle", "the countess rules", user, false},
+		{"short details are ignored", "ab is not my name", &domain.User{Email: "ab@example.com", Handle: "ab"}, true},
+		{"no user", "a long new password", nil, true},
+	}
+	for _, tt := range tests {
+		err := policy.Validate(tt.password, tt.user)
+		if tt.valid && err != nil {
+			t.Errorf("%s: Validate returned %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("%s: Validate returned %v, want %v", tt.name, err, ErrWeakPassword)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+const (
+	identityLinkPurpose = "identity_link"
+	identityLinkTTL     = time.Minute * 15
+)
+
+var (
+	ErrIdentityInUse         = errors.New("this account is already linked to another user")
+	ErrProviderAlreadyLinked = errors.New("an account from this provider is already linked")
+	ErrIdentityNotLinked     = errors.New("no account from this provider is linked")
+	ErrLastLoginMethod       = errors.New("cannot unlink the only remaining way to sign in")
+	ErrInvalidLinkToken      = errors.New("invalid or expired link confirmation")
+)
+
+// IdentityLinkRequiredError is returned when a provider login matches the
+// email of an existing account. Rather than creating a second account the
+// user must sign in to the existing one and confirm the link with LinkToken.
+type IdentityLinkRequiredError struct {
+	Provider  string
+	LinkToken string
+}
+
+func (e *IdentityLinkRequiredError) Error() string {
+	return fmt.Sprintf("an account with this email already exists, sign in to it to link %s", e.Provider)
+}
+
+// pendingIdentityLink is the payload of a link confirmation token.
+type pendingIdentityLink struct {
+	Provider  string `json:"provider"`
+	Subject   string `json:"subject"`
+	Email     string `json:"email"`
+	UserId    string `json:"user_id"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// LoginWithIdentity returns the user linked to an identity at an external
+// provider, creating the user from the provider's profile on first login.
+// The boolean reports whether a new user was created.
+func (svc *UserManagementService) LoginWithIdentity(user *domain.User, identity *domain.UserIdentity) (*domain.User, bool, error) {
+	linked, err := svc.identities.ReadUserIdentity(identity.Provider, identity.Subject)
+	if err == nil {
+		dbUser, err := svc.repo.ReadUserWithId(linked.UserId)
+		if err != nil {
+			return nil, false, err
+		}
+		return dbUser, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, false, err
+	}
+
+	if user.Email != "" {
+		if existing, err := svc.repo.ReadUserWithEmail(user.Email); err == nil {
+			return nil, false, svc.identityLinkRequired(existing.UserId, identity)
+		}
+	}
+
+	// The user row still carries the GitHub and LinkedIn ids for callers
+	// that look users up by them.
+	switch identity.Provider {
+	case "github":
+		user.GitHubId = identity.Subject
+	case "linkedin":
+		user.LinkedInId = identity.Subject
+	}
+
+	newUser, err := svc.createUser(user, true)
+	if err != nil {
+		return nil, false, err
+	}
+
+	identity.UserId = newUser.UserId
+	if _, err := svc.createIdentity(identity); err != nil {
+		return nil, false, err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("User with ID [%s] signed up with %s", newUser.UserId, identity.Provider),
+	}
+	svc.logger.LogInfo(logEntry)
+	return newUser, true, nil
+}
+
+// LinkIdentity adds an identity at an external provider to a signed in user.
+func (svc *UserManagementService) LinkIdentity(user_id string, identity *domain.UserIdentity) error {
+	linked, err := svc.identities.ReadUserIdentity(identity.Provider, identity.Subject)
+	if err == nil {
+		if linked.UserId == user_id {
+			return nil
+		}
+		return ErrIdentityInUse
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	identities, err := svc.identities.ReadUserIdentities(user_id)
+	if err != nil {
+		return err
+	}
+	for _, existing := range identities {
+		if existing.Provider == identity.Provider {
+			return ErrProviderAlreadyLinked
+		}
+	}
+
+	identity.UserId = user_id
+	if _, err := svc.createIdentity(identity); err != nil {
+		return err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("User with ID [%s] linked %s", user_id, identity.Provider),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// ConfirmIdentityLink links the identity described by a token from
+// IdentityLinkRequiredError. Only the account the token was issued for may
+// confirm it.
+func (svc *UserManagementService) ConfirmIdentityLink(user_id, linkToken string) (*domain.UserIdentity, error) {
+	var pending pendingIdentityLink
+	if err := openPayload(svc.conf.SECRET_KEY, identityLinkPurpose, linkToken, &pending); err != nil {
+		return nil, ErrInvalidLinkToken
+	}
+	if pending.UserId != user_id || time.Now().Unix() > pending.ExpiresAt {
+		return nil, ErrInvalidLinkToken
+	}
+
+	identity := domain.UserIdentity{
+		Provider: pending.Provider,
+		Subject:  pending.Subject,
+		Email:    pending.Email,
+	}
+	if err := svc.LinkIdentity(user_id, &identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// UnlinkIdentity removes the user's identity at provider, unless it is the
+// last way they have to sign in.
+func (svc *UserManagementService) UnlinkIdentity(user_id, provider string) error {
+	identities, err := svc.identities.ReadUserIdentities(user_id)
+	if err != nil {
+		return err
+	}
+
+	linked := false
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			linked = true
+		}
+	}
+	if !linked {
+		return ErrIdentityNotLinked
+	}
+
+	if len(identities) == 1 {
+		password, err := svc.repo.ReadUserPassword(user_id)
+		if err != nil {
+			return err
+		}
+		if password == "" {
+			return ErrLastLoginMethod
+		}
+	}
+
+	_, err = svc.identities.DeleteUserIdentity(user_id, provider)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("User with ID [%s] unlinked %s", user_id, provider),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+func (svc *UserManagementService) ReadUserIdentities(user_id string) ([]domain.UserIdentity, error) {
+	identities, err := svc.identities.ReadUserIdentities(user_id)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (svc *UserManagementService) identityLinkRequired(user_id string, identity *domain.UserIdentity) error {
+	pending := pendingIdentityLink{
+		Provider:  identity.Provider,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+		UserId:    user_id,
+		ExpiresAt: time.Now().Add(identityLinkTTL).Unix(),
+	}
+	linkToken, err := signPayload(svc.conf.SECRET_KEY, identityLinkPurpose, pending)
+	if err != nil {
+		return err
+	}
+	return &IdentityLinkRequiredError{
+		Provider:  identity.Provider,
+		LinkToken: linkToken,
+	}
+}
+
+func (svc *UserManagementService) createIdentity(identity *domain.UserIdentity) (*domain.UserIdentity, error) {
+	identity.CreatedAt = time.Now()
+	newIdentity, err := svc.identities.CreateUserIdentity(identity)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+	return newIdentity, nil
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+	"github.com/google/uuid"
+)
+
+// keyPrepublishWindow is how long a new key is served from the JWKS endpoint
+// before it starts signing, so verifiers with a cached key set pick it up.
+const keyPrepublishWindow = time.Hour
+
+// keyReloadInterval limits how often a token with an unknown kid makes
+// VerificationKey reload the key ring, so forged kids cannot turn every
+// request into a database query.
+const keyReloadInterval = time.Second * 30
+
+var (
+	ErrUnknownSigningKey        = errors.New("unknown signing key")
+	ErrUnsupportedSigningMethod = errors.New("unsupported signing algorithm")
+)
+
+type KeyManagementService struct {
+	repo             ports.SigningKeyRepository
+	logger           ports.LoggingService
+	algorithm        string
+	rotationInterval time.Duration
+	verificationTTL  time.Duration
+
+	mu       sync.RWMutex
+	keys     []domain.SigningKey
+	loadedAt time.Time
+}
+
+// NewKeyManagementService creates a key ring that signs with algorithm (RS256
+// or EdDSA). Retired keys stay valid for verification for verificationTTL,
+// which should be at least the lifetime of the longest token they sign.
+func NewKeyManagementService(repo ports.SigningKeyRepository, logger ports.LoggingService, algorithm string, rotationInterval, verificationTTL time.Duration) (*KeyManagementService, error) {
+	if algorithm != "RS256" && algorithm != "EdDSA" {
+		return nil, ErrUnsupportedSigningMethod
+	}
+	svc := KeyManagementService{
+		repo:             repo,
+		logger:           logger,
+		algorithm:        algorithm,
+		rotationInterval: rotationInterval,
+		verificationTTL:  verificationTTL,
+	}
+	if err := svc.RotateSigningKeys(); err != nil {
+		return nil, err
+	}
+	return &svc, nil
+}
+
+// SigningKey returns the oldest key that has not yet rotated out, so keys
+// created ahead of time are published before they are used.
+func (svc *KeyManagementService) SigningKey() (*domain.SigningKey, error) {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range svc.keys {
+		if key.Algorithm == svc.algorithm && now.Before(key.RotatesAt) {
+			return &key, nil
+		}
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+func (svc *KeyManagementService) VerificationKey(kid string) (*domain.SigningKey, error) {
+	if key := svc.findKey(kid); key != nil {
+		return key, nil
+	}
+	// The key may have been created by another instance since our last
+	// load. New keys are published well before they sign, so a recent load
+	// would already have found it.
+	svc.mu.RLock()
+	recentlyLoaded := time.Since(svc.loadedAt) < keyReloadInterval
+	svc.mu.RUnlock()
+	if recentlyLoaded {
+		return nil, ErrUnknownSigningKey
+	}
+	if err := svc.loadKeys(); err != nil {
+		return nil, err
+	}
+	if key := svc.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+func (svc *KeyManagementService) PublicKeys() ([]domain.SigningKey, error) {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	now := time.Now()
+	keys := []domain.SigningKey{}
+	for _, key := range svc.keys {
+		if now.Before(key.ExpiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// RotateSigningKeys reloads the key ring, creates the next key once the
+// current one is within keyPrepublishWindow of rotating and drops keys that
+// can no longer verify any token. It is safe to call on a schedule.
+func (svc *KeyManagementService) RotateSigningKeys() error {
+	if _, err := svc.repo.DeleteExpiredSigningKeys(); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+
+	if err := svc.loadKeys(); err != nil {
+		return err
+	}
+
+	if svc.hasUpcomingKey() {
+		return nil
+	}
+
+	key, err := svc.generateKey()
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	if _, err := svc.repo.CreateSigningKey(key); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Signing key [%s] created, active until %s", key.KeyId, key.RotatesAt.Format(time.RFC3339)),
+	}
+	svc.logger.LogInfo(logEntry)
+
+	return svc.loadKeys()
+}
+
+// ScheduleKeyRotation runs RotateSigningKeys every interval in the background.
+func (svc *KeyManagementService) ScheduleKeyRotation(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			svc.RotateSigningKeys()
+		}
+	}()
+}
+
+func (svc *KeyManagementService) hasUpcomingKey() bool {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	threshold := time.Now().Add(keyPrepublishWindow)
+	for _, key := range svc.keys {
+		if key.Algorithm == svc.algorithm && key.RotatesAt.After(threshold) {
+			return true
+		}
+	}
+	return false
+}
+
+func (svc *KeyManagementService) findKey(kid string) *domain.SigningKey {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range svc.keys {
+		if key.KeyId == kid && now.Before(key.ExpiresAt) {
+			return &key
+		}
+	}
+	return nil
+}
+
+func (svc *KeyManagementService) loadKeys() error {
+	keys, err := svc.repo.ReadSigningKeys()
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	svc.mu.Lock()
+	svc.keys = keys
+	svc.loadedAt = time.Now()
+	svc.mu.Unlock()
+	return nil
+}
+
+func (svc *KeyManagementService) generateKey() (*domain.SigningKey, error) {
+	key := domain.SigningKey{
+		KeyId:     uuid.New().String(),
+		Algorithm: svc.algorithm,
+	}
+
+	switch svc.algorithm {
+	case "RS256":
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey = privateKey
+		key.PublicKey = &privateKey.PublicKey
+	case "EdDSA":
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey = privateKey
+		key.PublicKey = publicKey
+	default:
+		return nil, ErrUnsupportedSigningMethod
+	}
+
+	// A new key starts signing when the current one rotates out, so it
+	// only needs to cover a full interval from that point.
+	start := time.Now()
+	if current, err := svc.SigningKey(); err == nil {
+		start = current.RotatesAt
+	}
+	key.CreatedAt = time.Now()
+	key.RotatesAt = start.Add(svc.rotationInterval)
+	key.ExpiresAt = key.RotatesAt.Add(svc.verificationTTL)
+	return &key, nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// fakeSigningKeyRepository keeps signing keys in memory and counts reads.
+type fakeSigningKeyRepository struct {
+	keys  map[string]domain.SigningKey
+	reads *int
+}
+
+func newFakeSigningKeyRepository() fakeSigningKeyRepository {
+	return fakeSigningKeyRepository{keys: map[string]domain.SigningKey{}, reads: new(int)}
+}
+
+func (repo fakeSigningKeyRepository) CreateSigningKey(key *domain.SigningKey) (*domain.SigningKey, error) {
+	repo.keys[key.KeyId] = *key
+	return key, nil
+}
+
+func (repo fakeSigningKeyRepository) ReadSigningKeys() ([]domain.SigningKey, error) {
+	*repo.reads++
+	keys := []domain.SigningKey{}
+	for _, key := range repo.keys {
+		if time.Now().Before(key.ExpiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (repo fakeSigningKeyRepository) DeleteExpiredSigningKeys() (string, error) {
+	for kid, key := range repo.keys {
+		if !time.Now().Before(key.ExpiresAt) {
+			delete(repo.keys, kid)
+		}
+	}
+	return "", nil
+}
+
+func TestSigningKeyRotationOverlap(t *testing.T) {
+	repo := newFakeSigningKeyRepository()
+	svc, err := NewKeyManagementService(repo, fakeLogger{}, "EdDSA", 200*time.Millisecond, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := svc.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The interval is shorter than keyPrepublishWindow, so the next key is
+	// created straight away and published before it signs.
+	if err := svc.RotateSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+	published, err := svc.PublicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 2 {
+		t.Fatalf("%d keys published, want 2", len(published))
+	}
+	next := published[1]
+	if current, _ := svc.SigningKey(); current.KeyId != first.KeyId {
+		t.Errorf("signing with %s before the first key rotated out", current.KeyId)
+	}
+	if !next.RotatesAt.After(first.RotatesAt) {
+		t.Errorf("next key rotates at %v, not after the first key at %v", next.RotatesAt, first.RotatesAt)
+	}
+
+	time.Sleep(time.Until(first.RotatesAt) + 10*time.Millisecond)
+	current, err := svc.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.KeyId != next.KeyId {
+		t.Errorf("signing with %s after rotation, want %s", current.KeyId, next.KeyId)
+	}
+	// Tokens signed with the retired key still verify.
+	if key, err := svc.VerificationKey(first.KeyId); err != nil || key.KeyId != first.KeyId {
+		t.Errorf("retired key did not verify: %v", err)
+	}
+}
+
+func TestRetiredSigningKeyExpires(t *testing.T) {
+	repo := newFakeSigningKeyRepository()
+	svc, err := NewKeyManagementService(repo, fakeLogger{}, "EdDSA", 50*time.Millisecond, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := svc.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Until(first.ExpiresAt) + 10*time.Millisecond)
+	if err := svc.RotateSigningKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.VerificationKey(first.KeyId); err != ErrUnknownSigningKey {
+		t.Errorf("expired key returned %v, want %v", err, ErrUnknownSigningKey)
+	}
+	published, _ := svc.PublicKeys()
+	for _, key := range published {
+		if key.KeyId == first.KeyId {
+			t.Errorf("expired key is still published")
+		}
+	}
+}
+
+func TestUnknownSigningKeyReloadsAtMostOnce(t *testing.T) {
+	repo := newFakeSigningKeyRepository()
+	svc, err := NewKeyManagementService(repo, fakeLogger{}, "RS256", time.Hour*24, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Let the load done by NewKeyManagementService age out.
+	svc.mu.Lock()
+	svc.loadedAt = time.Now().Add(-keyReloadInterval)
+	svc.mu.Unlock()
+
+	reads := *repo.reads
+	for i := 0; i < 10; i++ {
+		if _, err := svc.VerificationKey("forged-kid"); err != ErrUnknownSigningKey {
+			t.Fatalf("unknown kid returned %v, want %v", err, ErrUnknownSigningKey)
+		}
+	}
+	if loads := *repo.reads - reads; loads != 1 {
+		t.Errorf("unknown kids reloaded the key ring %d times, want 1", loads)
+	}
+
+	// A key created by another instance is found on the next allowed reload.
+	key, err := svc.generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateSigningKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.VerificationKey(key.KeyId); err != ErrUnknownSigningKey {
+		t.Errorf("new key found before the reload interval passed: %v", err)
+	}
+	svc.mu.Lock()
+	svc.loadedAt = time.Now().Add(-keyReloadInterval)
+	svc.mu.Unlock()
+	if _, err := svc.VerificationKey(key.KeyId); err != nil {
+		t.Errorf("key from another instance returned %v", err)
+	}
+}
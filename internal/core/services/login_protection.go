@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+)
+
+// Failures before progressive delays kick in, and the longest delay applied
+// before the key is locked outright.
+const (
+	loginDelayAfter = 3
+	loginMaxDelay   = time.Minute
+)
+
+var (
+	ErrLoginLocked    = errors.New("too many failed login attempts, try again later")
+	ErrLoginThrottled = errors.New("login attempted too quickly after a failure, try again shortly")
+)
+
+type LoginProtectionService struct {
+	store  ports.LoginAttemptStore
+	logger ports.LoggingService
+	conf   config.Config
+}
+
+func NewLoginProtectionService(store ports.LoginAttemptStore, logger ports.LoggingService, conf config.Config) *LoginProtectionService {
+	svc := LoginProtectionService{
+		store:  store,
+		logger: logger,
+		conf:   conf,
+	}
+	return &svc
+}
+
+// CheckLoginAllowed reports whether a login for email from ip may proceed.
+// When it may not, the returned duration is how long the caller should wait.
+func (svc *LoginProtectionService) CheckLoginAllowed(email, ip string) (time.Duration, error) {
+	now := time.Now()
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		attempt, err := svc.store.ReadLoginAttempt(key)
+		if err != nil {
+			logEntry := domain.LogMessage{
+				LogLevel: "ERROR",
+				Service:  "users",
+				Message:  err.Error(),
+			}
+			svc.logger.LogError(logEntry)
+			return 0, err
+		}
+
+		if now.Before(attempt.LockedUntil) {
+			return attempt.LockedUntil.Sub(now), ErrLoginLocked
+		}
+
+		if attempt.Failures < loginDelayAfter || now.Sub(attempt.LastFailureAt) > svc.conf.LOGIN_ATTEMPT_WINDOW {
+			continue
+		}
+		delay := svc.conf.LOGIN_DELAY_BASE << (attempt.Failures - loginDelayAfter)
+		if delay <= 0 || delay > loginMaxDelay {
+			delay = loginMaxDelay
+		}
+		if next := attempt.LastFailureAt.Add(delay); now.Before(next) {
+			return next.Sub(now), ErrLoginThrottled
+		}
+	}
+	return 0, nil
+}
+
+// RecordLoginFailure counts a failed login against both the account and the
+// client IP, locking either one that crosses its threshold.
+func (svc *LoginProtectionService) RecordLoginFailure(email, ip string) {
+	svc.recordFailure(accountKey(email), svc.conf.LOGIN_LOCK_THRESHOLD)
+	svc.recordFailure(ipKey(ip), svc.conf.LOGIN_IP_THRESHOLD)
+}
+
+// RecordLoginSuccess clears the account's failure count. The IP count is
+// left alone so one valid account cannot be used to reset it.
+func (svc *LoginProtectionService) RecordLoginSuccess(email string) {
+	if err := svc.store.ResetLoginAttempts(accountKey(email)); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+	}
+}
+
+func (svc *LoginProtectionService) UnlockAccount(email string) error {
+	if err := svc.store.ResetLoginAttempts(accountKey(email)); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Account [%s] unlocked", accountKey(email)),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+func (svc *LoginProtectionService) recordFailure(key string, threshold int) {
+	attempt, err := svc.store.RecordLoginFailure(key, svc.conf.LOGIN_ATTEMPT_WINDOW)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return
+	}
+
+	if attempt.Failures < threshold {
+		return
+	}
+
+	lockedUntil := time.Now().Add(svc.conf.LOGIN_LOCK_DURATION)
+	if err := svc.store.LockLogin(key, lockedUntil); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return
+	}
+	logEntry := domain.LogMessage{
+		LogLevel: "WARNING",
+		Service:  "users",
+		Message:  fmt.Sprintf("Login for [%s] locked until %s after %d failed attempts", key, lockedUntil.Format(time.RFC3339), attempt.Failures),
+	}
+	svc.logger.LogWarning(logEntry)
+}
+
+func accountKey(email string) string {
+	return fmt.Sprintf("account:%s", strings.ToLower(strings.TrimSpace(email)))
+}
+
+func ipKey(ip string) string {
+	return fmt.Sprintf("ip:%s", ip)
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/adapters/repository/memory"
+)
+
+func newTestLoginProtection() *LoginProtectionService {
+	conf := config.Config{
+		LOGIN_ATTEMPT_WINDOW: time.Second,
+		LOGIN_DELAY_BASE:     20 * time.Millisecond,
+		LOGIN_LOCK_THRESHOLD: 5,
+		LOGIN_IP_THRESHOLD:   8,
+		LOGIN_LOCK_DURATION:  150 * time.Millisecond,
+	}
+	return NewLoginProtectionService(memory.NewLoginAttemptStore(), fakeLogger{}, conf)
+}
+
+func TestLoginProgressiveDelay(t *testing.T) {
+	svc := newTestLoginProtection()
+
+	for i := 0; i < loginDelayAfter-1; i++ {
+		svc.RecordLoginFailure("ada@example.com", "192.0.2.1")
+		if _, err := svc.CheckLoginAllowed("ada@example.com", "192.0.2.1"); err != nil {
+			t.Fatalf("after %d failures: %v", i+1, err)
+		}
+	}
+
+	// Each failure past loginDelayAfter doubles the wait.
+	for i, maxWait := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		svc.RecordLoginFailure("ada@example.com", "192.0.2.1")
+		wait, err := svc.CheckLoginAllowed("ada@example.com", "192.0.2.1")
+		if err != ErrLoginThrottled {
+			t.Fatalf("after %d failures: %v, want %v", loginDelayAfter+i, err, ErrLoginThrottled)
+		}
+		if wait <= maxWait/2 || wait > maxWait {
+			t.Errorf("after %d failures: wait %v, want about %v", loginDelayAfter+i, wait, maxWait)
+		}
+		time.Sleep(wait)
+		if _, err := svc.CheckLoginAllowed("ada@example.com", "192.0.2.1"); err != nil {
+			t.Errorf("after waiting %v: %v", wait, err)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	svc := newTestLoginProtection()
+
+	for i := 0; i < 5; i++ {
+		svc.RecordLoginFailure("Ada@Example.com ", fmt.Sprintf("192.0.2.%d", i))
+	}
+	// The account is locked from every IP, and the email is matched without
+	// regard to case or surrounding space.
+	wait, err := svc.CheckLoginAllowed("ada@example.com", "198.51.100.1")
+	if err != ErrLoginLocked {
+		t.Fatalf("locked account returned %v, want %v", err, ErrLoginLocked)
+	}
+	if wait <= 100*time.Millisecond || wait > 150*time.Millisecond {
+		t.Errorf("wait %v, want about the lock duration", wait)
+	}
+	if _, err := svc.CheckLoginAllowed("grace@example.com", "192.0.2.1"); err != nil {
+		t.Errorf("another account from one of the IPs returned %v", err)
+	}
+
+	time.Sleep(wait)
+	if _, err := svc.CheckLoginAllowed("ada@example.com", "192.0.2.1"); err != nil {
+		t.Errorf("after the lock expired: %v", err)
+	}
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	svc := newTestLoginProtection()
+
+	// One failure each on many accounts locks the IP but none of the
+	// accounts.
+	for i := 0; i < 8; i++ {
+		svc.RecordLoginFailure(fmt.Sprintf("user%d@example.com", i), "192.0.2.1")
+	}
+	if _, err := svc.CheckLoginAllowed("ada@example.com", "192.0.2.1"); err != ErrLoginLocked {
+		t.Errorf("locked IP returned %v, want %v", err, ErrLoginLocked)
+	}
+	if _, err := svc.CheckLoginAllowed("user0@example.com", "198.51.100.1"); err != nil {
+		t.Errorf("account from another IP returned %v", err)
+	}
+}
+
+func TestLoginFailuresOutsideWindow(t *testing.T) {
+	conf := config.Config{
+		LOGIN_ATTEMPT_WINDOW: 50 * time.Millisecond,
+		LOGIN_DELAY_BASE:     time.Second,
+		LOGIN_LOCK_THRESHOLD: 5,
+		LOGIN_IP_THRESHOLD:   50,
+		LOGIN_LOCK_DURATION:  time.Minute,
+	}
+	svc := NewLoginProtectionService(memory.NewLoginAttemptStore(), fakeLogger{}, conf)
+
+	for i := 0; i < 4; i++ {
+		svc.RecordLoginFailure("ada@example.com", "192.0.2.1")
+	}
+	if _, err := svc.CheckLoginAllowed("ada@example.com", "192.0.2.1"); err != ErrLoginThrottled {
+		t.Fatalf("returned %v, want %v", err, ErrLoginThrottled)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := svc.CheckLoginAllowed("ada@example.com", "192.0.2.1"); err != nil {
+		t.Errorf("failures outside the window still throttle: %v", err)
+	}
+	// Counting starts again, so the next failures do not reach the lock.
+	svc.RecordLoginFailure("ada@example.com", "192.0.2.1")
+	svc.RecordLoginFailure("ada@example.com", "192.0.2.1")
+	if _, err := svc.CheckLoginAllowed("ada@example.com", "192.0.2.1"); err != nil {
+		t.Errorf("failures after the window returned %v", err)
+	}
+}
+
+func TestLoginSuccessAndUnlockReset(t *testing.T) {
+	svc := newTestLoginProtection()
+
+	for i := 0; i < 4; i++ {
+		svc.RecordLoginFailure("ada@example.com", "192.0.2.1")
+	}
+	svc.RecordLoginSuccess("ada@example.com")
+	if _, err := svc.CheckLoginAllowed("ada@example.com", "198.51.100.1"); err != nil {
+		t.Errorf("after a successful login: %v", err)
+	}
+	// The IP keeps its count; a valid login does not clear it.
+	for i := 0; i < 4; i++ {
+		svc.RecordLoginFailure(fmt.Sprintf("user%d@example.com", i), "192.0.2.1")
+	}
+	if _, err := svc.CheckLoginAllowed("grace@example.com", "192.0.2.1"); err != ErrLoginLocked {
+		t.Errorf("IP count was reset by a successful login: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		svc.RecordLoginFailure("joan@example.com", "198.51.100.1")
+	}
+	if err := svc.UnlockAccount("joan@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CheckLoginAllowed("joan@example.com", "203.0.113.1"); err != nil {
+		t.Errorf("after unlocking: %v", err)
+	}
+}
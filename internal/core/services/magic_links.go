@@ -0,0 +1,131 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+const magicLinkPurpose = "magic_link"
+
+var ErrInvalidMagicLink = errors.New("invalid or expired sign-in link")
+
+// RequestMagicLink mails a single-use sign-in link to the account with
+// email. Unknown and unverified addresses, and addresses sent a link within
+// the cooldown, are ignored without an error, so callers cannot tell whether
+// an account exists.
+func (svc *UserManagementService) RequestMagicLink(email string) error {
+	if email == "" {
+		return nil
+	}
+	user, err := svc.repo.ReadUserWithEmail(email)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "INFO",
+			Service:  "users",
+			Message:  "Sign-in link requested for unknown email",
+		}
+		svc.logger.LogInfo(logEntry)
+		return nil
+	}
+	// Whoever registered an unverified address may still hold its password
+	// or a linked identity, so a link must not open the account.
+	if !user.EmailVerified {
+		logEntry := domain.LogMessage{
+			LogLevel: "INFO",
+			Service:  "users",
+			Message:  fmt.Sprintf("Sign-in link for user with ID [%s] not sent, email is not verified", user.UserId),
+		}
+		svc.logger.LogInfo(logEntry)
+		return nil
+	}
+
+	latest, err := svc.tokens.ReadLatestOneTimeToken(user.UserId, magicLinkPurpose)
+	if err == nil && time.Since(latest.CreatedAt) < svc.conf.MAGIC_LINK_COOLDOWN {
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  fmt.Sprintf("Sign-in link for user with ID [%s] not sent, one was sent recently", user.UserId),
+		}
+		svc.logger.LogWarning(logEntry)
+		return nil
+	}
+
+	// Only the newest link works.
+	if _, err := svc.tokens.DeleteOneTimeTokens(user.UserId, magicLinkPurpose); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	token, err := svc.createOneTimeToken(user.UserId, magicLinkPurpose, svc.conf.MAGIC_LINK_TTL)
+	if err != nil {
+		return err
+	}
+
+	loginURL := fmt.Sprintf("%s/login/magic?token=%s", svc.conf.FRONTEND_URL, url.QueryEscape(token))
+	message := domain.MailMessage{
+		To:      user.Email,
+		Subject: "Your Notelify sign-in link",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to sign in to Notelify. It expires in %s and can only be used once.\n\n%s\n\nIf you did not ask to sign in you can ignore this email.\n",
+			user.Firstname, svc.conf.MAGIC_LINK_TTL, loginURL),
+	}
+	// Mail is sent in the background so the response time does not reveal
+	// whether the account exists.
+	go svc.sendMail(message)
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Sign-in link sent to user with ID [%s]", user.UserId),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// LoginWithMagicLink uses up a sign-in link and returns its user. Links
+// only sign in to accounts whose email is verified; RequestMagicLink does not
+// send them to others, and a link issued before the address became unverified
+// is refused.
+func (svc *UserManagementService) LoginWithMagicLink(token string) (*domain.User, error) {
+	magicLink, err := svc.tokens.ConsumeOneTimeToken(hashToken(token), magicLinkPurpose)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	user, err := svc.repo.ReadUserWithId(magicLink.UserId)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+
+	if !user.EmailVerified {
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  fmt.Sprintf("Sign-in link for user with ID [%s] refused, email is not verified", user.UserId),
+		}
+		svc.logger.LogWarning(logEntry)
+		return nil, ErrInvalidMagicLink
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("User with ID [%s] signed in with a sign-in link", user.UserId),
+	}
+	svc.logger.LogInfo(logEntry)
+	return user, nil
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordsList string
+
+// maxPasswordLength bounds the work done hashing a password.
+const maxPasswordLength = 128
+
+// ErrWeakPassword is wrapped by every error Validate returns, so callers can
+// tell a rejected password apart from other failures.
+var ErrWeakPassword = errors.New("password does not meet the password policy")
+
+// PasswordPolicy decides whether a password is acceptable for a user.
+type PasswordPolicy struct {
+	minLength       int
+	commonPasswords map[string]struct{}
+}
+
+// NewPasswordPolicy builds a policy from the built-in list of common
+// passwords plus, when commonPasswordsFile is set, one password per line
+// from that file.
+func NewPasswordPolicy(minLength int, commonPasswordsFile string) (*PasswordPolicy, error) {
+	policy := PasswordPolicy{
+		minLength:       minLength,
+		commonPasswords: map[string]struct{}{},
+	}
+	if err := policy.addCommonPasswords(strings.NewReader(commonPasswordsList)); err != nil {
+		return nil, err
+	}
+
+	if commonPasswordsFile != "" {
+		file, err := os.Open(commonPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if err := policy.addCommonPasswords(file); err != nil {
+			return nil, err
+		}
+	}
+	return &policy, nil
+}
+
+// Validate checks password against the policy. user supplies the email and
+// handle the password must not contain.
+func (p *PasswordPolicy) Validate(password string, user *domain.User) error {
+	if len([]rune(password)) < p.minLength {
+		return fmt.Errorf("%w: it must be at least %d characters", ErrWeakPassword, p.minLength)
+	}
+	if len([]rune(password)) > maxPasswordLength {
+		return fmt.Errorf("%w: it must be at most %d characters", ErrWeakPassword, maxPasswordLength)
+	}
+
+	lowered := strings.ToLower(password)
+	if _, ok := p.commonPasswords[lowered]; ok {
+		return fmt.Errorf("%w: it is too common", ErrWeakPassword)
+	}
+
+	for _, detail := range personalDetails(user) {
+		if strings.Contains(lowered, detail) {
+			return fmt.Errorf("%w: it must not contain your email address or handle", ErrWeakPassword)
+		}
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) addCommonPasswords(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line != "" {
+			p.commonPasswords[line] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// personalDetails lists the lowercased parts of the user's email and handle
+// that a password must not contain. Very short parts are skipped since they
+// would reject too many unrelated passwords.
+func personalDetails(user *domain.User) []string {
+	if user == nil {
+		return nil
+	}
+	details := []string{}
+	for _, value := range []string{user.Email, user.Handle} {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		details = append(details, value)
+		if at := strings.Index(value, "@"); at > 0 {
+			details = append(details, value[:at])
+		}
+	}
+
+	filtered := []string{}
+	for _, detail := range details {
+		if len(detail) >= 3 {
+			filtered = append(filtered, detail)
+		}
+	}
+	return filtered
+}
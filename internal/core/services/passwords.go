@@ -0,0 +1,312 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+const passwordResetPurpose = "password_reset"
+
+var (
+	ErrInvalidResetToken  = errors.New("invalid or expired password reset token")
+	ErrEmptyPassword      = errors.New("password must not be empty")
+	ErrIncorrectPassword  = errors.New("current password is incorrect")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
+
+// RequestPasswordReset mails a one-time reset link to the user. It returns
+// nil whether or not the email belongs to an account, and when a link was
+// already sent within the cooldown, so callers cannot use it to discover
+// registered addresses or to flood an inbox.
+func (svc *UserManagementService) RequestPasswordReset(email string) error {
+	if email == "" {
+		return nil
+	}
+	user, err := svc.repo.ReadUserWithEmail(email)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "INFO",
+			Service:  "users",
+			Message:  "Password reset requested for unknown email",
+		}
+		svc.logger.LogInfo(logEntry)
+		return nil
+	}
+
+	latest, err := svc.tokens.ReadLatestOneTimeToken(user.UserId, passwordResetPurpose)
+	if err == nil && time.Since(latest.CreatedAt) < svc.conf.RESET_LINK_COOLDOWN {
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  fmt.Sprintf("Password reset for user with ID [%s] not sent, one was sent recently", user.UserId),
+		}
+		svc.logger.LogWarning(logEntry)
+		return nil
+	}
+
+	token, err := svc.createOneTimeToken(user.UserId, passwordResetPurpose, svc.conf.PASSWORD_RESET_TTL)
+	if err != nil {
+		return err
+	}
+
+	resetURL := fmt.Sprintf("%s/reset-password?token=%s", svc.conf.FRONTEND_URL, url.QueryEscape(token))
+	message := domain.MailMessage{
+		To:      user.Email,
+		Subject: "Reset your Notelify password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to choose a new password. It expires in %s and can only be used once.\n\n%s\n\nIf you did not ask to reset your password you can ignore this email.\n",
+			user.Firstname, svc.conf.PASSWORD_RESET_TTL, resetURL),
+	}
+	// Mail is sent in the background so the response time does not reveal
+	// whether the account exists.
+	go svc.sendMail(message)
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Password reset requested for user with ID [%s]", user.UserId),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// ResetPassword sets a new password with a reset link. The link is only used
+// up once the password passes the policy, so a rejected password can be
+// retried with the same link.
+func (svc *UserManagementService) ResetPassword(token, newPassword string) (*domain.User, error) {
+	if newPassword == "" {
+		return nil, ErrEmptyPassword
+	}
+
+	resetToken, err := svc.tokens.ReadOneTimeToken(hashToken(token), passwordResetPurpose)
+	if err != nil {
+		return nil, ErrInvalidResetToken
+	}
+
+	user, err := svc.repo.ReadUserWithId(resetToken.UserId)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+
+	hashedPassword, err := svc.hashPassword(user, newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	// Consuming is what makes the link single use, since two requests may
+	// both have read it above.
+	if _, err := svc.tokens.ConsumeOneTimeToken(hashToken(token), passwordResetPurpose); err != nil {
+		return nil, ErrInvalidResetToken
+	}
+
+	_, err = svc.repo.UpdateUserPassword(user.UserId, hashedPassword)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+
+	// Any other reset links that are still out there must not work anymore.
+	_, err = svc.tokens.DeleteOneTimeTokens(user.UserId, passwordResetPurpose)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Password for user with ID [%s] reset successfuly", user.UserId),
+	}
+	svc.logger.LogInfo(logEntry)
+	return user, nil
+}
+
+// Authenticate checks an email and password pair. When the stored hash was
+// made with an older algorithm or weaker parameters it is replaced with a
+// fresh one while the plain password is at hand.
+func (svc *UserManagementService) Authenticate(email, password string) (*domain.User, error) {
+	user, err := svc.repo.ReadUserWithEmail(email)
+	if err != nil || user.Password == "" {
+		// Hash anyway so unknown emails take as long as wrong passwords.
+		svc.hasher.Hash(password)
+		return nil, ErrInvalidCredentials
+	}
+
+	match, needsRehash, err := svc.hasher.Verify(password, user.Password)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, ErrInvalidCredentials
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		svc.rehashPassword(user.UserId, password)
+	}
+	user.Password = ""
+	return user, nil
+}
+
+// SetPassword changes the user's password. Accounts that already have one
+// must confirm it; accounts created through an OAuth provider can set their
+// first password without it.
+func (svc *UserManagementService) SetPassword(user_id, currentPassword, newPassword string) error {
+	if newPassword == "" {
+		return ErrEmptyPassword
+	}
+
+	user, err := svc.repo.ReadUserWithId(user_id)
+	if err != nil {
+		return err
+	}
+
+	existingPassword, err := svc.repo.ReadUserPassword(user_id)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	if existingPassword != "" {
+		match, _, err := svc.hasher.Verify(currentPassword, existingPassword)
+		if err != nil || !match {
+			return ErrIncorrectPassword
+		}
+	}
+
+	hashedPassword, err := svc.hashPassword(user, newPassword)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.repo.UpdateUserPassword(user_id, hashedPassword)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Password for user with ID [%s] set successfuly", user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// hashPassword checks password against the password policy and returns its
+// hash for storage.
+func (svc *UserManagementService) hashPassword(user *domain.User, password string) (string, error) {
+	if err := svc.passwords.Validate(password, user); err != nil {
+		return "", err
+	}
+
+	hashedPassword, err := svc.hasher.Hash(password)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return "", err
+	}
+	return hashedPassword, nil
+}
+
+// rehashPassword upgrades a stored hash after a successful login. Failures
+// are only logged since the old hash still works.
+func (svc *UserManagementService) rehashPassword(user_id, password string) {
+	hashedPassword, err := svc.hasher.Hash(password)
+	if err == nil {
+		_, err = svc.repo.UpdateUserPassword(user_id, hashedPassword)
+	}
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  fmt.Sprintf("Failed to upgrade password hash for user with ID [%s]: %s", user_id, err.Error()),
+		}
+		svc.logger.LogError(logEntry)
+		return
+	}
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Password hash for user with ID [%s] upgraded", user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+}
+
+// createOneTimeToken stores the hash of a new random token and returns the
+// token itself for mailing.
+func (svc *UserManagementService) createOneTimeToken(user_id, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	oneTimeToken := domain.OneTimeToken{
+		TokenHash: hashToken(token),
+		UserId:    user_id,
+		Purpose:   purpose,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	_, err = svc.tokens.CreateOneTimeToken(&oneTimeToken)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return "", err
+	}
+	return token, nil
+}
+
+func (svc *UserManagementService) sendMail(message domain.MailMessage) {
+	if err := svc.mailer.SendMail(message); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  fmt.Sprintf("Failed to send mail: %s", err.Error()),
+		}
+		svc.logger.LogError(logEntry)
+	}
+}
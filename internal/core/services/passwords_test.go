@@ -0,0 +1,201 @@
+package services
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+func (repo fakeUserRepository) ReadUserWithEmail(email string) (*domain.User, error) {
+	for _, user := range repo.users {
+		if user.Email == email {
+			// A copy, so callers clearing the hash do not clear the store.
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (repo fakeUserRepository) UpdateUserPassword(user_id, password string) (string, error) {
+	repo.users[user_id].Password = password
+	return "", nil
+}
+
+// fakeOneTimeTokenRepository keeps one-time tokens in memory, keyed by hash.
+type fakeOneTimeTokenRepository struct {
+	tokens map[string]*domain.OneTimeToken
+	used   map[string]bool
+}
+
+func newFakeOneTimeTokenRepository() fakeOneTimeTokenRepository {
+	return fakeOneTimeTokenRepository{tokens: map[string]*domain.OneTimeToken{}, used: map[string]bool{}}
+}
+
+func (repo fakeOneTimeTokenRepository) CreateOneTimeToken(token *domain.OneTimeToken) (*domain.OneTimeToken, error) {
+	repo.tokens[token.TokenHash] = token
+	return token, nil
+}
+
+func (repo fakeOneTimeTokenRepository) ReadOneTimeToken(token_hash, purpose string) (*domain.OneTimeToken, error) {
+	token, ok := repo.tokens[token_hash]
+	if !ok || token.Purpose != purpose || repo.used[token_hash] || time.Now().After(token.ExpiresAt) {
+		return nil, errors.New("token not found")
+	}
+	return token, nil
+}
+
+func (repo fakeOneTimeTokenRepository) ConsumeOneTimeToken(token_hash, purpose string) (*domain.OneTimeToken, error) {
+	token, err := repo.ReadOneTimeToken(token_hash, purpose)
+	if err != nil {
+		return nil, err
+	}
+	repo.used[token_hash] = true
+	return token, nil
+}
+
+func (repo fakeOneTimeTokenRepository) ReadLatestOneTimeToken(user_id, purpose string) (*domain.OneTimeToken, error) {
+	var latest *domain.OneTimeToken
+	for _, token := range repo.tokens {
+		if token.UserId == user_id && token.Purpose == purpose && (latest == nil || token.CreatedAt.After(latest.CreatedAt)) {
+			latest = token
+		}
+	}
+	if latest == nil {
+		return nil, errors.New("token not found")
+	}
+	return latest, nil
+}
+
+func (repo fakeOneTimeTokenRepository) DeleteOneTimeTokens(user_id, purpose string) (string, error) {
+	for token_hash, token := range repo.tokens {
+		if token.UserId == user_id && token.Purpose == purpose {
+			delete(repo.tokens, token_hash)
+		}
+	}
+	return "", nil
+}
+
+// fakeMailer hands sent messages to the test.
+type fakeMailer chan domain.MailMessage
+
+func (mailer fakeMailer) SendMail(message domain.MailMessage) error {
+	mailer <- message
+	return nil
+}
+
+func newPasswordTestService(t *testing.T, users map[string]*domain.User, tokens fakeOneTimeTokenRepository, mailer fakeMailer, conf config.Config) *UserManagementService {
+	t.Helper()
+	policy, err := NewPasswordPolicy(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher, err := NewPasswordHashService("bcrypt", Argon2Params{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewUserManagementService(fakeUserRepository{users: users}, tokens, nil, nil, mailer, fakeLogger{}, policy, hasher, nil, nil, conf)
+}
+
+func TestRequestPasswordResetCooldown(t *testing.T) {
+	users := map[string]*domain.User{"user-1": {UserId: "user-1", Firstname: "Ada", Email: "ada@example.com"}}
+	tokens := newFakeOneTimeTokenRepository()
+	mailer := make(fakeMailer, 10)
+	conf := config.Config{
+		FRONTEND_URL:        "http://localhost:3000",
+		PASSWORD_RESET_TTL:  time.Hour,
+		RESET_LINK_COOLDOWN: 100 * time.Millisecond,
+	}
+	svc := newPasswordTestService(t, users, tokens, mailer, conf)
+
+	for _, email := range []string{"ada@example.com", "ada@example.com", "nobody@example.com", ""} {
+		if err := svc.RequestPasswordReset(email); err != nil {
+			t.Errorf("reset for %q returned %v", email, err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(mailer) != 1 || len(tokens.tokens) != 1 {
+		t.Fatalf("sent %d messages and stored %d links, want 1 each", len(mailer), len(tokens.tokens))
+	}
+	if message := <-mailer; message.To != "ada@example.com" {
+		t.Errorf("reset mailed to %s", message.To)
+	}
+
+	time.Sleep(conf.RESET_LINK_COOLDOWN)
+	if err := svc.RequestPasswordReset("ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-mailer:
+	case <-time.After(time.Second):
+		t.Errorf("no reset mail after the cooldown")
+	}
+}
+
+func TestResetPasswordLinks(t *testing.T) {
+	users := map[string]*domain.User{"user-1": {UserId: "user-1", Firstname: "Ada", Email: "ada@example.com"}}
+	tokens := newFakeOneTimeTokenRepository()
+	mailer := make(fakeMailer, 10)
+	conf := config.Config{
+		FRONTEND_URL:        "http://localhost:3000",
+		PASSWORD_RESET_TTL:  time.Hour,
+		RESET_LINK_COOLDOWN: time.Minute,
+	}
+	svc := newPasswordTestService(t, users, tokens, mailer, conf)
+
+	if err := svc.RequestPasswordReset("ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	var message domain.MailMessage
+	select {
+	case message = <-mailer:
+	case <-time.After(time.Second):
+		t.Fatal("no reset mail sent")
+	}
+	match := regexp.MustCompile(`/reset-password\?token=(\S+)`).FindStringSubmatch(message.Body)
+	if match == nil {
+		t.Fatalf("no reset link in %q", message.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	older, err := svc.createOneTimeToken("user-1", passwordResetPurpose, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := svc.createOneTimeToken("user-1", passwordResetPurpose, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.ResetPassword(expired, "a long new password"); err != ErrInvalidResetToken {
+		t.Errorf("expired link returned %v, want %v", err, ErrInvalidResetToken)
+	}
+	// A password rejected by the policy leaves the link usable.
+	if _, err := svc.ResetPassword(token, "ada-example-password"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("password containing the email returned %v, want %v", err, ErrWeakPassword)
+	}
+	if _, err := svc.ResetPassword(token, "a long new password"); err != nil {
+		t.Fatalf("reset returned %v", err)
+	}
+	if !strings.HasPrefix(users["user-1"].Password, "$2a$") {
+		t.Errorf("password stored as %q", users["user-1"].Password)
+	}
+	if _, err := svc.Authenticate("ada@example.com", "a long new password"); err != nil {
+		t.Errorf("signing in with the new password returned %v", err)
+	}
+
+	if _, err := svc.ResetPassword(token, "another long password"); err != ErrInvalidResetToken {
+		t.Errorf("reused link returned %v, want %v", err, ErrInvalidResetToken)
+	}
+	if _, err := svc.ResetPassword(older, "another long password"); err != ErrInvalidResetToken {
+		t.Errorf("other link after a reset returned %v, want %v", err, ErrInvalidResetToken)
+	}
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+	"github.com/google/uuid"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token, which tells
+// them apart from JWTs and makes leaked tokens easy to scan for.
+const PersonalAccessTokenPrefix = "ntl_pat_"
+
+// lastUsedResolution limits how often a token's last-used time is written.
+const lastUsedResolution = time.Minute
+
+var (
+	ErrInvalidPersonalAccessToken = errors.New("invalid personal access token")
+	ErrPersonalAccessTokenMissing = errors.New("personal access token not found")
+	ErrInvalidTokenExpiry         = errors.New("token expiry is out of range")
+	ErrScopeNotGranted            = errors.New("scope is not granted by your roles")
+	ErrScopeNotAllowed            = errors.New("scope cannot be granted to a personal access token")
+)
+
+type PersonalAccessTokenManagementService struct {
+	repo       ports.PersonalAccessTokenRepository
+	roles      ports.RoleService
+	logger     ports.LoggingService
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+func NewPersonalAccessTokenManagementService(repo ports.PersonalAccessTokenRepository, roles ports.RoleService, logger ports.LoggingService, defaultTTL, maxTTL time.Duration) *PersonalAccessTokenManagementService {
+	svc := PersonalAccessTokenManagementService{
+		repo:       repo,
+		roles:      roles,
+		logger:     logger,
+		defaultTTL: defaultTTL,
+		maxTTL:     maxTTL,
+	}
+	return &svc
+}
+
+// CreateToken issues a token that expires after ttl, or the default
+// lifetime when ttl is zero. The token is returned once; only its hash is
+// stored. Every scope must be one of domain.PersonalAccessTokenScopes and,
+// apart from ScopeProfile, a permission the user's roles grant.
+func (svc *PersonalAccessTokenManagementService) CreateToken(user_id, name string, scopes []string, ttl time.Duration) (*domain.PersonalAccessToken, string, error) {
+	if ttl == 0 {
+		ttl = svc.defaultTTL
+	}
+	if ttl < 0 || ttl > svc.maxTTL {
+		return nil, "", ErrInvalidTokenExpiry
+	}
+
+	roles, err := svc.roles.ReadUserRoles(user_id)
+	if err != nil {
+		return nil, "", err
+	}
+	granted := []string{}
+	for _, scope := range scopes {
+		if containsString(granted, scope) {
+			continue
+		}
+		if !containsString(domain.PersonalAccessTokenScopes, scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrScopeNotAllowed, scope)
+		}
+		if scope != domain.ScopeProfile {
+			allowed, err := svc.roles.HasPermission(roles, scope)
+			if err != nil {
+				return nil, "", err
+			}
+			if !allowed {
+				return nil, "", fmt.Errorf("%w: %s", ErrScopeNotGranted, scope)
+			}
+		}
+		granted = append(granted, scope)
+	}
+
+	secret, err := generateToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	tokenString := PersonalAccessTokenPrefix + secret
+
+	now := time.Now()
+	token := domain.PersonalAccessToken{
+		TokenId:   uuid.New().String(),
+		UserId:    user_id,
+		Name:      name,
+		Scopes:    granted,
+		TokenHash: hashToken(tokenString),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	created, err := svc.repo.CreatePersonalAccessToken(&token)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, "", err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Personal access token [%s] created for user with ID [%s]", created.TokenId, user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+	return created, tokenString, nil
+}
+
+func (svc *PersonalAccessTokenManagementService) ReadTokens(user_id string) ([]domain.PersonalAccessToken, error) {
+	tokens, err := svc.repo.ReadUserPersonalAccessTokens(user_id)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (svc *PersonalAccessTokenManagementService) RevokeToken(user_id, token_id string) error {
+	deleted, err := svc.repo.DeletePersonalAccessToken(user_id, token_id)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	if !deleted {
+		return ErrPersonalAccessTokenMissing
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Personal access token [%s] revoked for user with ID [%s]", token_id, user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// RevokeUserTokens deletes every token the user holds. It is called when the
+// account may have been taken over, such as on a password reset.
+func (svc *PersonalAccessTokenManagementService) RevokeUserTokens(user_id string) error {
+	if _, err := svc.repo.DeleteUserPersonalAccessTokens(user_id); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Personal access tokens revoked for user with ID [%s]", user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// AuthenticateToken returns the stored token matching tokenString and
+// records that it was used. Scopes outside domain.PersonalAccessTokenScopes,
+// which older tokens may carry, are dropped.
+func (svc *PersonalAccessTokenManagementService) AuthenticateToken(tokenString string) (*domain.PersonalAccessToken, error) {
+	if !strings.HasPrefix(tokenString, PersonalAccessTokenPrefix) {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+	token, err := svc.repo.ReadPersonalAccessTokenWithHash(hashToken(tokenString))
+	if err != nil {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+
+	now := time.Now()
+	if now.After(token.ExpiresAt) {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+
+	if now.Sub(token.LastUsedAt) >= lastUsedResolution {
+		if _, err := svc.repo.UpdatePersonalAccessTokenLastUsed(token.TokenId, now); err != nil {
+			logEntry := domain.LogMessage{
+				LogLevel: "ERROR",
+				Service:  "users",
+				Message:  err.Error(),
+			}
+			svc.logger.LogError(logEntry)
+		}
+		token.LastUsedAt = now
+	}
+
+	scopes := []string{}
+	for _, scope := range token.Scopes {
+		if containsString(domain.PersonalAccessTokenScopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	token.Scopes = scopes
+	return token, nil
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// fakePersonalAccessTokenRepository keeps tokens in memory, keyed by hash.
+type fakePersonalAccessTokenRepository struct {
+	tokens map[string]*domain.PersonalAccessToken
+}
+
+func (repo fakePersonalAccessTokenRepository) CreatePersonalAccessToken(token *domain.PersonalAccessToken) (*domain.PersonalAccessToken, error) {
+	repo.tokens[token.TokenHash] = token
+	return token, nil
+}
+
+func (repo fakePersonalAccessTokenRepository) ReadPersonalAccessTokenWithHash(token_hash string) (*domain.PersonalAccessToken, error) {
+	token, ok := repo.tokens[token_hash]
+	if !ok {
+		return nil, errors.New("token not found")
+	}
+	found := *token
+	return &found, nil
+}
+
+func (repo fakePersonalAccessTokenRepository) ReadUserPersonalAccessTokens(user_id string) ([]domain.PersonalAccessToken, error) {
+	tokens := []domain.PersonalAccessToken{}
+	for _, token := range repo.tokens {
+		if token.UserId == user_id {
+			tokens = append(tokens, *token)
+		}
+	}
+	return tokens, nil
+}
+
+func (repo fakePersonalAccessTokenRepository) DeletePersonalAccessToken(user_id, token_id string) (bool, error) {
+	for token_hash, token := range repo.tokens {
+		if token.UserId == user_id && token.TokenId == token_id {
+			delete(repo.tokens, token_hash)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (repo fakePersonalAccessTokenRepository) DeleteUserPersonalAccessTokens(user_id string) (string, error) {
+	for token_hash, token := range repo.tokens {
+		if token.UserId == user_id {
+			delete(repo.tokens, token_hash)
+		}
+	}
+	return "", nil
+}
+
+func (repo fakePersonalAccessTokenRepository) UpdatePersonalAccessTokenLastUsed(token_id string, lastUsedAt time.Time) (string, error) {
+	return "", nil
+}
+
+func newPersonalAccessTokenTestService() (*PersonalAccessTokenManagementService, fakePersonalAccessTokenRepository) {
+	repo := fakePersonalAccessTokenRepository{tokens: map[string]*domain.PersonalAccessToken{}}
+	roles := NewRoleManagementService(fakeRoleRepository{userRoles: map[string][]string{
+		"author-1": {domain.RoleAuthor},
+		"admin-1":  {domain.RoleAdmin},
+	}}, fakeLogger{})
+	return NewPersonalAccessTokenManagementService(repo, roles, fakeLogger{}, time.Hour, time.Hour*24), repo
+}
+
+func TestCreatePersonalAccessTokenScopes(t *testing.T) {
+	svc, _ := newPersonalAccessTokenTestService()
+
+	tests := []struct {
+		user_id string
+		scopes  []string
+		err     error
+	}{
+		{"author-1", []string{domain.ScopeProfile, domain.PermissionWriteArticles}, nil},
+		{"author-1", []string{domain.PermissionModerateArticles}, ErrScopeNotGranted},
+		{"author-1", []string{"articles:delete"}, ErrScopeNotAllowed},
+		{"admin-1", []string{domain.PermissionReadUsers, domain.PermissionModerateArticles}, nil},
+		// Admins hold these permissions, but tokens can never carry them.
+		{"admin-1", []string{domain.PermissionAdmin}, ErrScopeNotAllowed},
+		{"admin-1", []string{domain.ScopeProfile, domain.PermissionManageUsers}, ErrScopeNotAllowed},
+	}
+	for _, tt := range tests {
+		token, _, err := svc.CreateToken(tt.user_id, "cli", tt.scopes, 0)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s with %v returned %v, want %v", tt.user_id, tt.scopes, err, tt.err)
+			continue
+		}
+		if err == nil && len(token.Scopes) != len(tt.scopes) {
+			t.Errorf("%s with %v created scopes %v", tt.user_id, tt.scopes, token.Scopes)
+		}
+	}
+}
+
+func TestAuthenticatePersonalAccessTokenDropsDisallowedScopes(t *testing.T) {
+	svc, repo := newPersonalAccessTokenTestService()
+	tokenString := PersonalAccessTokenPrefix + "legacy"
+	repo.tokens[hashToken(tokenString)] = &domain.PersonalAccessToken{
+		TokenId:   "pat-1",
+		UserId:    "admin-1",
+		Scopes:    []string{domain.PermissionAdmin, domain.ScopeProfile, domain.PermissionManageUsers},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	token, err := svc.AuthenticateToken(tokenString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token.Scopes) != 1 || token.Scopes[0] != domain.ScopeProfile {
+		t.Errorf("token authenticated with scopes %v, want [%s]", token.Scopes, domain.ScopeProfile)
+	}
+}
+
+func TestRevokeUserPersonalAccessTokens(t *testing.T) {
+	svc, _ := newPersonalAccessTokenTestService()
+	_, first, err := svc.CreateToken("author-1", "cli", []string{domain.ScopeProfile}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := svc.CreateToken("author-1", "ci", []string{domain.PermissionWriteArticles}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := svc.CreateToken("admin-1", "cli", []string{domain.ScopeProfile}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.RevokeUserTokens("author-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tokenString := range []string{first, second} {
+		if _, err := svc.AuthenticateToken(tokenString); err != ErrInvalidPersonalAccessToken {
+			t.Errorf("revoked token returned %v, want %v", err, ErrInvalidPersonalAccessToken)
+		}
+	}
+	if _, err := svc.AuthenticateToken(other); err != nil {
+		t.Errorf("another user's token returned %v", err)
+	}
+}
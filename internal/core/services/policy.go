@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+	"github.com/google/uuid"
+)
+
+var ErrForbidden = errors.New("not allowed to act on this user")
+
+// AuthorizationPolicy decides whether an actor may act on a user account.
+// Users may act on their own account; users whose roles grant
+// PermissionManageUsers may act on anyone's, except that only admins may act
+// on an admin. Denials and overrides are recorded in the audit log.
+type AuthorizationPolicy struct {
+	roles  ports.RoleService
+	audit  ports.AuditRepository
+	logger ports.LoggingService
+}
+
+func NewAuthorizationPolicy(roles ports.RoleService, audit ports.AuditRepository, logger ports.LoggingService) *AuthorizationPolicy {
+	policy := AuthorizationPolicy{
+		roles:  roles,
+		audit:  audit,
+		logger: logger,
+	}
+	return &policy
+}
+
+// AuthorizeUserAction returns ErrForbidden unless actor may perform action
+// on the user with target_id.
+func (p *AuthorizationPolicy) AuthorizeUserAction(actor *domain.Actor, action, target_id string) error {
+	if actor == nil || actor.UserId == "" {
+		p.record("", action, target_id, domain.AuditOutcomeDenied, "not authenticated")
+		return ErrForbidden
+	}
+	if actor.UserId == target_id {
+		return nil
+	}
+
+	canManage, err := p.roles.HasPermission(actor.Roles, domain.PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	if !canManage {
+		p.record(actor.UserId, action, target_id, domain.AuditOutcomeDenied, "not the account owner")
+		return ErrForbidden
+	}
+
+	targetRoles, err := p.roles.ReadUserRoles(target_id)
+	if err != nil {
+		return err
+	}
+	targetIsAdmin, err := p.roles.HasPermission(targetRoles, domain.PermissionAdmin)
+	if err != nil {
+		return err
+	}
+	if targetIsAdmin {
+		actorIsAdmin, err := p.roles.HasPermission(actor.Roles, domain.PermissionAdmin)
+		if err != nil {
+			return err
+		}
+		if !actorIsAdmin {
+			p.record(actor.UserId, action, target_id, domain.AuditOutcomeDenied, "target is an admin")
+			return ErrForbidden
+		}
+	}
+
+	p.record(actor.UserId, action, target_id, domain.AuditOutcomeOverride, fmt.Sprintf("granted by %s", domain.PermissionManageUsers))
+	return nil
+}
+
+// AuthorizeImpersonation returns ErrForbidden unless admin may sign in as
+// the user with target_id. Only admins may impersonate, and never themselves
+// or another admin.
+func (p *AuthorizationPolicy) AuthorizeImpersonation(admin *domain.Actor, target_id, reason string) error {
+	if admin == nil || admin.UserId == "" {
+		p.record("", domain.AuditActionImpersonate, target_id, domain.AuditOutcomeDenied, "not authenticated")
+		return ErrForbidden
+	}
+	isAdmin, err := p.roles.HasPermission(admin.Roles, domain.PermissionAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		p.record(admin.UserId, domain.AuditActionImpersonate, target_id, domain.AuditOutcomeDenied, "not an admin")
+		return ErrForbidden
+	}
+	if admin.UserId == target_id {
+		p.record(admin.UserId, domain.AuditActionImpersonate, target_id, domain.AuditOutcomeDenied, "cannot impersonate yourself")
+		return ErrForbidden
+	}
+
+	targetRoles, err := p.roles.ReadUserRoles(target_id)
+	if err != nil {
+		return err
+	}
+	targetIsAdmin, err := p.roles.HasPermission(targetRoles, domain.PermissionAdmin)
+	if err != nil {
+		return err
+	}
+	if targetIsAdmin {
+		p.record(admin.UserId, domain.AuditActionImpersonate, target_id, domain.AuditOutcomeDenied, "target is an admin")
+		return ErrForbidden
+	}
+
+	p.record(admin.UserId, domain.AuditActionImpersonate, target_id, domain.AuditOutcomeAllowed, reason)
+	logEntry := domain.LogMessage{
+		LogLevel: "WARNING",
+		Service:  "users",
+		Message:  fmt.Sprintf("User with ID [%s] is impersonating user with ID [%s]: %s", admin.UserId, target_id, reason),
+	}
+	p.logger.LogWarning(logEntry)
+	return nil
+}
+
+// RecordImpersonatedRequest audits a request made by admin_id while signed
+// in as user_id. request describes it, such as "GET /users/v1/userinfo 200".
+func (p *AuthorizationPolicy) RecordImpersonatedRequest(admin_id, user_id, request string) {
+	p.record(admin_id, domain.AuditActionImpersonatedRequest, user_id, domain.AuditOutcomeAllowed, request)
+}
+
+// record writes an audit event. A failure to write it is logged but does
+// not change the decision.
+func (p *AuthorizationPolicy) record(actor_id, action, target_id, outcome, reason string) {
+	event := domain.AuditEvent{
+		EventId:   uuid.New().String(),
+		ActorId:   actor_id,
+		Action:    action,
+		TargetId:  target_id,
+		Outcome:   outcome,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if _, err := p.audit.CreateAuditEvent(&event); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		p.logger.LogError(logEntry)
+	}
+
+	if outcome == domain.AuditOutcomeDenied {
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  fmt.Sprintf("User with ID [%s] denied %s on user with ID [%s]: %s", actor_id, action, target_id, reason),
+		}
+		p.logger.LogWarning(logEntry)
+	}
+}
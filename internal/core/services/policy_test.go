@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// fakeAuditRepository records the audit events written to it.
+type fakeAuditRepository struct {
+	events *[]domain.AuditEvent
+}
+
+func (repo fakeAuditRepository) CreateAuditEvent(event *domain.AuditEvent) (*domain.AuditEvent, error) {
+	*repo.events = append(*repo.events, *event)
+	return event, nil
+}
+
+func TestAuthorizeUserAction(t *testing.T) {
+	roles := NewRoleManagementService(fakeRoleRepository{userRoles: map[string][]string{
+		"moderator-1": {domain.RoleModerator},
+		"admin-1":     {domain.RoleAdmin},
+		"admin-2":     {domain.RoleAdmin},
+	}}, fakeLogger{})
+
+	tests := []struct {
+		name      string
+		actor     *domain.Actor
+		target_id string
+		err       error
+		// event is the audit event expected, or nil when none is written.
+		event *domain.AuditEvent
+	}{
+		{
+			name:      "owner",
+			actor:     &domain.Actor{UserId: "user-1", Roles: []string{domain.RoleUser}},
+			target_id: "user-1",
+		},
+		{
+			name:      "non-owner",
+			actor:     &domain.Actor{UserId: "user-2", Roles: []string{domain.RoleUser}},
+			target_id: "user-1",
+			err:       ErrForbidden,
+			event:     &domain.AuditEvent{ActorId: "user-2", Outcome: domain.AuditOutcomeDenied, Reason: "not the account owner"},
+		},
+		{
+			name:      "moderator on a user",
+			actor:     &domain.Actor{UserId: "moderator-1", Roles: []string{domain.RoleModerator}},
+			target_id: "user-1",
+			event:     &domain.AuditEvent{ActorId: "moderator-1", Outcome: domain.AuditOutcomeOverride, Reason: "granted by " + domain.PermissionManageUsers},
+		},
+		{
+			name:      "moderator on an admin",
+			actor:     &domain.Actor{UserId: "moderator-1", Roles: []string{domain.RoleModerator}},
+			target_id: "admin-1",
+			err:       ErrForbidden,
+			event:     &domain.AuditEvent{ActorId: "moderator-1", Outcome: domain.AuditOutcomeDenied, Reason: "target is an admin"},
+		},
+		{
+			name:      "admin on an admin",
+			actor:     &domain.Actor{UserId: "admin-2", Roles: []string{domain.RoleAdmin}},
+			target_id: "admin-1",
+			event:     &domain.AuditEvent{ActorId: "admin-2", Outcome: domain.AuditOutcomeOverride, Reason: "granted by " + domain.PermissionManageUsers},
+		},
+		{
+			name:      "unauthenticated",
+			target_id: "user-1",
+			err:       ErrForbidden,
+			event:     &domain.AuditEvent{ActorId: "", Outcome: domain.AuditOutcomeDenied, Reason: "not authenticated"},
+		},
+	}
+	for _, tt := range tests {
+		events := []domain.AuditEvent{}
+		policy := NewAuthorizationPolicy(roles, fakeAuditRepository{events: &events}, fakeLogger{})
+
+		if err := policy.AuthorizeUserAction(tt.actor, domain.AuditActionUpdateUser, tt.target_id); err != tt.err {
+			t.Errorf("%s: AuthorizeUserAction returned %v, want %v", tt.name, err, tt.err)
+		}
+
+		if tt.event == nil {
+			if len(events) != 0 {
+				t.Errorf("%s: wrote audit events %+v, want none", tt.name, events)
+			}
+			continue
+		}
+		if len(events) != 1 {
+			t.Errorf("%s: wrote %d audit events, want 1", tt.name, len(events))
+			continue
+		}
+		event := events[0]
+		if event.ActorId != tt.event.ActorId || event.Outcome != tt.event.Outcome || event.Reason != tt.event.Reason {
+			t.Errorf("%s: audit event actor %q outcome %q reason %q, want %q %q %q", tt.name, event.ActorId, event.Outcome, event.Reason, tt.event.ActorId, tt.event.Outcome, tt.event.Reason)
+		}
+		if event.Action != domain.AuditActionUpdateUser || event.TargetId != tt.target_id || event.EventId == "" || event.CreatedAt.IsZero() {
+			t.Errorf("%s: audit event %+v", tt.name, event)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// encryptAccessToken replaces the provider access token on user with its
+// encrypted form. Without a configured key the token is dropped rather than
+// stored in plain text.
+func (svc *UserManagementService) encryptAccessToken(user *domain.User) error {
+	if user.AccessToken == "" {
+		return nil
+	}
+
+	encrypted, err := svc.encrypter.Encrypt(user.AccessToken)
+	if errors.Is(err, ErrEncryptionKeyNotSet) {
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  "Provider access token discarded: " + err.Error(),
+		}
+		svc.logger.LogWarning(logEntry)
+		user.AccessToken = ""
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	user.AccessToken = encrypted
+	return nil
+}
+
+// ReencryptProviderTokens encrypts stored provider access tokens that are
+// still in plain text or sealed with a retired key, and returns how many
+// were updated.
+func (svc *UserManagementService) ReencryptProviderTokens() (int, error) {
+	accessTokens, err := svc.repo.ReadUserAccessTokens()
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for user_id, stored := range accessTokens {
+		if !svc.encrypter.NeedsReencryption(stored) {
+			continue
+		}
+		accessToken, err := svc.encrypter.Decrypt(stored)
+		if err != nil {
+			return updated, fmt.Errorf("user [%s]: %w", user_id, err)
+		}
+		encrypted, err := svc.encrypter.Encrypt(accessToken)
+		if err != nil {
+			return updated, err
+		}
+		if _, err := svc.repo.UpdateUserAccessToken(user_id, encrypted); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Re-encrypted %d provider access tokens", updated),
+	}
+	svc.logger.LogInfo(logEntry)
+	return updated, nil
+}
+
+// ReencryptTOTPSecrets encrypts stored TOTP secrets that are still in plain
+// text or sealed with a retired key, and returns how many were updated.
+func (svc *UserManagementService) ReencryptTOTPSecrets() (int, error) {
+	secrets, err := svc.mfa.ReadUserTOTPSecrets()
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for user_id, stored := range secrets {
+		if !svc.encrypter.NeedsReencryption(stored) {
+			continue
+		}
+		secret, err := svc.encrypter.Decrypt(stored)
+		if err != nil {
+			return updated, fmt.Errorf("user [%s]: %w", user_id, err)
+		}
+		encrypted, err := svc.encrypter.Encrypt(secret)
+		if err != nil {
+			return updated, err
+		}
+		if _, err := svc.mfa.ReplaceUserTOTPSecret(user_id, encrypted); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Re-encrypted %d TOTP secrets", updated),
+	}
+	svc.logger.LogInfo(logEntry)
+	return updated, nil
+}
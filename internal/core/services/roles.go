@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+)
+
+// roleCacheTTL is how long role definitions are cached before they are read
+// from the database again.
+const roleCacheTTL = time.Minute
+
+var (
+	ErrUnknownRole = errors.New("unknown role")
+	ErrLastAdmin   = errors.New("cannot remove the last admin")
+)
+
+type RoleManagementService struct {
+	repo   ports.RoleRepository
+	logger ports.LoggingService
+
+	mu       sync.RWMutex
+	roles    map[string]domain.Role
+	loadedAt time.Time
+}
+
+func NewRoleManagementService(repo ports.RoleRepository, logger ports.LoggingService) *RoleManagementService {
+	svc := RoleManagementService{
+		repo:   repo,
+		logger: logger,
+	}
+	return &svc
+}
+
+func (svc *RoleManagementService) ReadRoles() ([]domain.Role, error) {
+	roles, err := svc.repo.ReadRoles()
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+	return roles, nil
+}
+
+// ReadUserRoles returns the names of the user's roles. Users who have not
+// been assigned a role have the default user role.
+func (svc *RoleManagementService) ReadUserRoles(user_id string) ([]string, error) {
+	roles, err := svc.repo.ReadUserRoles(user_id)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return []string{domain.RoleUser}, nil
+	}
+	return roles, nil
+}
+
+func (svc *RoleManagementService) AssignRole(user_id, role string) error {
+	definitions, err := svc.definitions()
+	if err != nil {
+		return err
+	}
+	if _, ok := definitions[role]; !ok {
+		return ErrUnknownRole
+	}
+
+	if _, err := svc.repo.AssignUserRole(user_id, role); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Role [%s] assigned to user with ID [%s]", role, user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// RemoveRole takes a role away from the user. The last admin cannot be
+// removed, so the service is never left without one.
+func (svc *RoleManagementService) RemoveRole(user_id, role string) error {
+	if role == domain.RoleAdmin {
+		roles, err := svc.repo.ReadUserRoles(user_id)
+		if err != nil {
+			return err
+		}
+		admins, err := svc.repo.CountUsersWithRole(domain.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if admins <= 1 && containsString(roles, domain.RoleAdmin) {
+			return ErrLastAdmin
+		}
+	}
+
+	if _, err := svc.repo.RemoveUserRole(user_id, role); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Role [%s] removed from user with ID [%s]", role, user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// HasPermission reports whether any of roles grants permission.
+func (svc *RoleManagementService) HasPermission(roles []string, permission string) (bool, error) {
+	definitions, err := svc.definitions()
+	if err != nil {
+		return false, err
+	}
+	for _, name := range roles {
+		if containsString(definitions[name].Permissions, permission) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// definitions returns the roles keyed by name, reloading them once the
+// cached copy is older than roleCacheTTL.
+func (svc *RoleManagementService) definitions() (map[string]domain.Role, error) {
+	svc.mu.RLock()
+	if svc.roles != nil && time.Since(svc.loadedAt) < roleCacheTTL {
+		defer svc.mu.RUnlock()
+		return svc.roles, nil
+	}
+	svc.mu.RUnlock()
+
+	roles, err := svc.ReadRoles()
+	if err != nil {
+		return nil, err
+	}
+	definitions := make(map[string]domain.Role, len(roles))
+	for _, role := range roles {
+		definitions[role.Name] = role
+	}
+
+	svc.mu.Lock()
+	svc.roles = definitions
+	svc.loadedAt = time.Now()
+	svc.mu.Unlock()
+	return definitions, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
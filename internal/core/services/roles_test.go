@@ -0,0 +1,108 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// fakeRoleRepository serves the default roles and keeps role assignments in
+// memory.
+type fakeRoleRepository struct {
+	userRoles map[string][]string
+}
+
+func (repo fakeRoleRepository) ReadRoles() ([]domain.Role, error) {
+	return domain.DefaultRoles, nil
+}
+
+func (repo fakeRoleRepository) ReadUserRoles(user_id string) ([]string, error) {
+	return repo.userRoles[user_id], nil
+}
+
+func (repo fakeRoleRepository) AssignUserRole(user_id, role string) (string, error) {
+	repo.userRoles[user_id] = append(repo.userRoles[user_id], role)
+	return "", nil
+}
+
+func (repo fakeRoleRepository) RemoveUserRole(user_id, role string) (string, error) {
+	roles := []string{}
+	for _, r := range repo.userRoles[user_id] {
+		if r != role {
+			roles = append(roles, r)
+		}
+	}
+	repo.userRoles[user_id] = roles
+	return "", nil
+}
+
+func (repo fakeRoleRepository) CountUsersWithRole(role string) (int, error) {
+	count := 0
+	for _, roles := range repo.userRoles {
+		if containsString(roles, role) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func TestRolePermissions(t *testing.T) {
+	svc := NewRoleManagementService(fakeRoleRepository{userRoles: map[string][]string{}}, fakeLogger{})
+
+	tests := []struct {
+		roles      []string
+		permission string
+		allowed    bool
+	}{
+		{[]string{domain.RoleUser}, domain.PermissionWriteArticles, false},
+		{[]string{domain.RoleAuthor}, domain.PermissionWriteArticles, true},
+		{[]string{domain.RoleAuthor}, domain.PermissionManageUsers, false},
+		{[]string{domain.RoleModerator}, domain.PermissionManageUsers, true},
+		{[]string{domain.RoleModerator}, domain.PermissionAdmin, false},
+		{[]string{domain.RoleAdmin}, domain.PermissionAdmin, true},
+		{[]string{domain.RoleUser, domain.RoleAuthor}, domain.PermissionWriteArticles, true},
+		{[]string{"unknown"}, domain.PermissionWriteArticles, false},
+		{nil, domain.PermissionReadUsers, false},
+	}
+	for _, tt := range tests {
+		allowed, err := svc.HasPermission(tt.roles, tt.permission)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("HasPermission(%v, %s) = %v, want %v", tt.roles, tt.permission, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestRoleAssignment(t *testing.T) {
+	repo := fakeRoleRepository{userRoles: map[string][]string{}}
+	svc := NewRoleManagementService(repo, fakeLogger{})
+
+	if roles, err := svc.ReadUserRoles("user-1"); err != nil || len(roles) != 1 || roles[0] != domain.RoleUser {
+		t.Errorf("roles of a user with none assigned = %v, %v, want [%s]", roles, err, domain.RoleUser)
+	}
+	if err := svc.AssignRole("user-1", "superuser"); err != ErrUnknownRole {
+		t.Errorf("assigning an unknown role returned %v, want %v", err, ErrUnknownRole)
+	}
+
+	if err := svc.AssignRole("admin-1", domain.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RemoveRole("admin-1", domain.RoleAdmin); err != ErrLastAdmin {
+		t.Errorf("removing the last admin returned %v, want %v", err, ErrLastAdmin)
+	}
+
+	if err := svc.AssignRole("admin-2", domain.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RemoveRole("admin-1", domain.RoleAdmin); err != nil {
+		t.Errorf("removing one of two admins returned %v", err)
+	}
+	if err := svc.RemoveRole("admin-2", domain.RoleAdmin); err != ErrLastAdmin {
+		t.Errorf("removing the remaining admin returned %v, want %v", err, ErrLastAdmin)
+	}
+	if roles, _ := svc.ReadUserRoles("admin-2"); !containsString(roles, domain.RoleAdmin) {
+		t.Errorf("last admin lost the admin role, has %v", roles)
+	}
+}
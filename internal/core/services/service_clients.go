@@ -0,0 +1,224 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// ServiceClientSecretPrefix starts every service client secret.
+const ServiceClientSecretPrefix = "ntl_svc_"
+
+// serviceTokenUse is the token_use claim of service tokens. Authorize only
+// accepts access tokens, so a service token cannot act as a user.
+const serviceTokenUse = "service"
+
+// serviceTokenRefreshMargin is how long before expiry a cached outbound
+// token is replaced.
+const serviceTokenRefreshMargin = time.Minute
+
+var (
+	ErrInvalidClient       = errors.New("invalid client credentials")
+	ErrInvalidScope        = errors.New("scope is not allowed for this client")
+	ErrInvalidServiceToken = errors.New("invalid service token")
+)
+
+type ServiceClientManagementService struct {
+	repo     ports.ServiceClientRepository
+	keys     ports.KeyService
+	logger   ports.LoggingService
+	clientId string
+	tokenTTL time.Duration
+
+	mu     sync.Mutex
+	cached map[string]*domain.ServiceToken
+}
+
+func NewServiceClientManagementService(repo ports.ServiceClientRepository, keys ports.KeyService, logger ports.LoggingService, clientId string, tokenTTL time.Duration) *ServiceClientManagementService {
+	svc := ServiceClientManagementService{
+		repo:     repo,
+		keys:     keys,
+		logger:   logger,
+		clientId: clientId,
+		tokenTTL: tokenTTL,
+		cached:   map[string]*domain.ServiceToken{},
+	}
+	return &svc
+}
+
+// RegisterClient creates a service client and returns its secret. The
+// secret is returned once; only its hash is stored.
+func (svc *ServiceClientManagementService) RegisterClient(name string, scopes []string) (*domain.ServiceClient, string, error) {
+	for _, scope := range scopes {
+		if !containsString(domain.ServiceScopes, scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+
+	secret, err := generateToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret = ServiceClientSecretPrefix + secret
+
+	client := domain.ServiceClient{
+		ClientId:   uuid.New().String(),
+		Name:       name,
+		SecretHash: hashToken(secret),
+		Scopes:     scopes,
+		CreatedAt:  time.Now(),
+	}
+	created, err := svc.repo.CreateServiceClient(&client)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, "", err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Service client [%s] registered with ID [%s]", name, created.ClientId),
+	}
+	svc.logger.LogInfo(logEntry)
+	return created, secret, nil
+}
+
+// IssueToken implements the client credentials grant. An empty scopes list
+// requests every scope the client is registered with.
+func (svc *ServiceClientManagementService) IssueToken(client_id, secret string, scopes []string) (*domain.ServiceToken, error) {
+	client, err := svc.repo.ReadServiceClient(client_id)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  fmt.Sprintf("Rejected client credentials for service client [%s]", client_id),
+		}
+		svc.logger.LogWarning(logEntry)
+		return nil, ErrInvalidClient
+	}
+
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !containsString(client.Scopes, scope) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	return svc.signToken(client.ClientId, scopes)
+}
+
+// VerifyToken checks a token from IssueToken and returns its client and
+// scopes.
+func (svc *ServiceClientManagementService) VerifyToken(tokenString string) (*domain.ServiceToken, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := svc.keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.PublicKey, nil
+	})
+	if err != nil {
+		return nil, ErrInvalidServiceToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["token_use"] != serviceTokenUse {
+		return nil, ErrInvalidServiceToken
+	}
+
+	client_id, _ := claims["client_id"].(string)
+	scope, _ := claims["scope"].(string)
+	exp, _ := claims["exp"].(float64)
+	serviceToken := domain.ServiceToken{
+		AccessToken: tokenString,
+		ClientId:    client_id,
+		Scopes:      strings.Fields(scope),
+		ExpiresAt:   time.Unix(int64(exp), 0),
+	}
+	return &serviceToken, nil
+}
+
+// ServiceToken returns a token for this service to present to other
+// Notelify services. Tokens are reused until they are about to expire.
+func (svc *ServiceClientManagementService) ServiceToken(scopes ...string) (string, error) {
+	key := strings.Join(scopes, " ")
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if token, ok := svc.cached[key]; ok && time.Until(token.ExpiresAt) > serviceTokenRefreshMargin {
+		return token.AccessToken, nil
+	}
+
+	token, err := svc.signToken(svc.clientId, scopes)
+	if err != nil {
+		return "", err
+	}
+	svc.cached[key] = token
+	return token.AccessToken, nil
+}
+
+func (svc *ServiceClientManagementService) signToken(client_id string, scopes []string) (*domain.ServiceToken, error) {
+	key, err := svc.keys.SigningKey()
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+	method := jwt.GetSigningMethod(key.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unexpected signing method: %v", key.Algorithm)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(svc.tokenTTL)
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"sub":       client_id,
+		"client_id": client_id,
+		"scope":     strings.Join(scopes, " "),
+		"token_use": serviceTokenUse,
+		"jti":       uuid.New().String(),
+		"iat":       now.Unix(),
+		"exp":       expiresAt.Unix(),
+	})
+	token.Header["kid"] = key.KeyId
+
+	tokenString, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+
+	serviceToken := domain.ServiceToken{
+		AccessToken: tokenString,
+		ClientId:    client_id,
+		Scopes:      scopes,
+		ExpiresAt:   expiresAt,
+	}
+	return &serviceToken, nil
+}
@@ -7,61 +7,101 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/AntonyIS/notelify-users-service/config"
 	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
 	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type UserManagementService struct {
-	repo   ports.UserRepository
-	logger ports.LoggingService
+	repo       ports.UserRepository
+	tokens     ports.OneTimeTokenRepository
+	mfa        ports.MFARepository
+	identities ports.IdentityRepository
+	mailer     ports.Mailer
+	logger     ports.LoggingService
+	passwords  *PasswordPolicy
+	hasher     ports.PasswordHasher
+	encrypter  ports.SecretEncrypter
+	policy     *AuthorizationPolicy
+	conf       config.Config
 }
 
 type loggingManagementService struct {
 	loggerURL string
 }
 
-func NewUserManagementService(repo ports.UserRepository, logger ports.LoggingService) *UserManagementService {
+func NewUserManagementService(repo ports.UserRepository, tokens ports.OneTimeTokenRepository, mfa ports.MFARepository, identities ports.IdentityRepository, mailer ports.Mailer, logger ports.LoggingService, passwords *PasswordPolicy, hasher ports.PasswordHasher, encrypter ports.SecretEncrypter, policy *AuthorizationPolicy, conf config.Config) *UserManagementService {
 	svc := UserManagementService{
-		repo:   repo,
-		logger: logger,
+		repo:       repo,
+		tokens:     tokens,
+		mfa:        mfa,
+		identities: identities,
+		mailer:     mailer,
+		logger:     logger,
+		passwords:  passwords,
+		hasher:     hasher,
+		encrypter:  encrypter,
+		policy:     policy,
+		conf:       conf,
 	}
 	return &svc
 }
 
+// CreateUser signs up a user with a password.
 func (svc *UserManagementService) CreateUser(user *domain.User) (*domain.User, error) {
+	// Provider ids are only set by LoginWithIdentity, never by the client.
+	user.GitHubId = ""
+	user.LinkedInId = ""
+	return svc.createUser(user, false)
+}
+
+// createUser stores a new user. Users signing up through an identity
+// provider, external, have no password until they choose to set one.
+func (svc *UserManagementService) createUser(user *domain.User, external bool) (*domain.User, error) {
 	// Assign new user with a unique id
 
 	user.UserId = uuid.New().String()
 
-	// hash user password
-	if user.Password == "" {
-		user.Password = "pass"
+	user.Handle = fmt.Sprintf(`%s@notelify`, user.Firstname)
+
+	if user.Password == "" && !external {
+		return nil, ErrEmptyPassword
 	}
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
-	if err != nil {
+	if user.Password != "" {
+		hashedPassword, err := svc.hashPassword(user, user.Password)
 		if err != nil {
-			logEntry := domain.LogMessage{
-				LogLevel: "ERROR",
-				Service:  "users",
-				Message:  err.Error(),
-			}
-			svc.logger.LogError(logEntry)
 			return nil, err
 		}
+		user.Password = hashedPassword
+	}
+	if err := svc.encryptAccessToken(user); err != nil {
 		return nil, err
 	}
-	user.Password = string(hashedPassword)
-	user.Handle = fmt.Sprintf(`%s@notelify`, user.Firstname)
 	logEntry := domain.LogMessage{
 		LogLevel: "INFO",
 		Service:  "users",
 		Message:  fmt.Sprintf("User with ID [%s] created successfuly", user.UserId),
 	}
 	svc.logger.LogInfo(logEntry)
-	
-	return svc.repo.CreateUser(user)
+
+	newUser, err := svc.repo.CreateUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if newUser.Email != "" && !newUser.EmailVerified {
+		if err := svc.sendVerificationEmail(newUser); err != nil {
+			logEntry := domain.LogMessage{
+				LogLevel: "ERROR",
+				Service:  "users",
+				Message:  err.Error(),
+			}
+			svc.logger.LogError(logEntry)
+		}
+	}
+	newUser.Password = ""
+	return newUser, nil
 }
 
 func (svc *UserManagementService) ReadUserWithId(user_id string) (*domain.User, error) {
@@ -166,8 +206,23 @@ func (svc *UserManagementService) ReadUsers() ([]domain.User, error) {
 	return users, nil
 }
 
-func (svc *UserManagementService) UpdateUser(user *domain.User) (*domain.User, error) {
-	user, err := svc.repo.UpdateUser(user)
+func (svc *UserManagementService) UpdateUser(actor *domain.Actor, user *domain.User) (*domain.User, error) {
+	if err := svc.policy.AuthorizeUserAction(actor, domain.AuditActionUpdateUser, user.UserId); err != nil {
+		return nil, err
+	}
+
+	if _, err := svc.repo.UpdateUser(user); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+	// Return the stored user rather than the input, which may hold fields
+	// UpdateUser does not save.
+	user, err := svc.repo.ReadUserWithId(user.UserId)
 	if err != nil {
 		logEntry := domain.LogMessage{
 			LogLevel: "ERROR",
@@ -183,10 +238,15 @@ func (svc *UserManagementService) UpdateUser(user *domain.User) (*domain.User, e
 		Message:  fmt.Sprintf("User with ID [%s] updated successfuly", user.UserId),
 	}
 	svc.logger.LogInfo(logEntry)
+	user.Password = ""
 	return user, nil
 }
 
-func (svc *UserManagementService) DeleteUser(user_id string) (string, error) {
+func (svc *UserManagementService) DeleteUser(actor *domain.Actor, user_id string) (string, error) {
+	if err := svc.policy.AuthorizeUserAction(actor, domain.AuditActionDeleteUser, user_id); err != nil {
+		return "", err
+	}
+
 	message, err := svc.repo.DeleteUser(user_id)
 	if err != nil {
 		logEntry := domain.LogMessage{
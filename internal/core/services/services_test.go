@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+// UpdateUser saves only the profile fields, like the postgres query.
+func (repo fakeUserRepository) UpdateUser(user *domain.User) (*domain.User, error) {
+	stored := repo.users[user.UserId]
+	stored.Firstname = user.Firstname
+	stored.Lastname = user.Lastname
+	stored.Handle = user.Handle
+	stored.About = user.About
+	stored.ProfileImage = user.ProfileImage
+	return user, nil
+}
+
+func TestUpdateUserReturnsStoredUser(t *testing.T) {
+	users := map[string]*domain.User{"user-1": {UserId: "user-1", Firstname: "Ada", Email: "ada@example.com"}}
+	roles := NewRoleManagementService(fakeRoleRepository{userRoles: map[string][]string{}}, fakeLogger{})
+	policy := NewAuthorizationPolicy(roles, fakeAuditRepository{events: &[]domain.AuditEvent{}}, fakeLogger{})
+	svc := NewUserManagementService(fakeUserRepository{users: users}, nil, nil, nil, nil, fakeLogger{}, nil, nil, nil, policy, config.Config{})
+
+	actor := &domain.Actor{UserId: "user-1", Roles: []string{domain.RoleUser}}
+	user, err := svc.UpdateUser(actor, &domain.User{UserId: "user-1", Firstname: "Grace", Email: "grace@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Firstname != "Grace" || user.Email != "ada@example.com" || user.EmailVerified {
+		t.Errorf("returned %+v, want the stored user", *user)
+	}
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+)
+
+var ErrSessionMissing = errors.New("session not found")
+
+// SessionManagementService records where users are signed in. A session
+// stays active until it is revoked or its refresh token expires unused.
+type SessionManagementService struct {
+	repo       ports.SessionRepository
+	users      ports.UserRepository
+	tokens     ports.TokenService
+	notifier   ports.LoginNotifier
+	logger     ports.LoggingService
+	sessionTTL time.Duration
+}
+
+// NewSessionManagementService creates the service. notifier may be nil, in
+// which case new-device logins are not reported.
+func NewSessionManagementService(repo ports.SessionRepository, users ports.UserRepository, tokens ports.TokenService, notifier ports.LoginNotifier, logger ports.LoggingService, sessionTTL time.Duration) *SessionManagementService {
+	svc := SessionManagementService{
+		repo:       repo,
+		users:      users,
+		tokens:     tokens,
+		notifier:   notifier,
+		logger:     logger,
+		sessionTTL: sessionTTL,
+	}
+	return &svc
+}
+
+// RecordSession stores a new session. The user is notified when it is on a
+// device they have not signed in from before, unless it is their first
+// session.
+func (svc *SessionManagementService) RecordSession(user_id, session_id, userAgent, ip string) (*domain.Session, error) {
+	device := describeDevice(userAgent)
+	devices, err := svc.repo.ReadUserDevices(user_id)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+
+	now := time.Now()
+	session := domain.Session{
+		SessionId:  session_id,
+		UserId:     user_id,
+		Device:     device,
+		UserAgent:  userAgent,
+		IPAddress:  ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	created, err := svc.repo.CreateSession(&session)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+
+	newDevice := len(devices) > 0 && !containsString(devices, device)
+	if newDevice && svc.notifier != nil {
+		go svc.notifyNewDevice(created)
+	}
+	return created, nil
+}
+
+// TouchSession records that a session was used again, such as when its
+// refresh token is rotated.
+func (svc *SessionManagementService) TouchSession(session_id, ip string) {
+	if _, err := svc.repo.UpdateSessionLastSeen(session_id, ip, time.Now()); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+	}
+}
+
+func (svc *SessionManagementService) ReadSessions(user_id string) ([]domain.Session, error) {
+	sessions, err := svc.repo.ReadUserSessions(user_id, time.Now().Add(-svc.sessionTTL))
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession signs the user out on one device. Its refresh token stops
+// working and its access tokens are rejected straight away.
+func (svc *SessionManagementService) RevokeSession(user_id, session_id string) error {
+	revoked, err := svc.repo.RevokeSession(user_id, session_id)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	if !revoked {
+		return ErrSessionMissing
+	}
+	if err := svc.tokens.RevokeSessionTokens(session_id); err != nil {
+		return err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Session [%s] revoked for user with ID [%s]", session_id, user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// RevokeAllSessions signs the user out everywhere. Each active session is
+// revoked as well as the user's tokens, so RevokeSession and the logout-all
+// cutoff agree on which sessions are over.
+func (svc *SessionManagementService) RevokeAllSessions(user_id string) error {
+	if err := svc.tokens.LogoutAllSessions(user_id); err != nil {
+		return err
+	}
+	sessions, err := svc.ReadSessions(user_id)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := svc.tokens.RevokeSessionTokens(session.SessionId); err != nil {
+			return err
+		}
+	}
+	if _, err := svc.repo.RevokeUserSessions(user_id); err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	return nil
+}
+
+func (svc *SessionManagementService) notifyNewDevice(session *domain.Session) {
+	user, err := svc.users.ReadUserWithId(session.UserId)
+	if err == nil {
+		err = svc.notifier.NotifyNewDevice(user, session)
+	}
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  fmt.Sprintf("New device notification for user with ID [%s] failed: %s", session.UserId, err.Error()),
+		}
+		svc.logger.LogError(logEntry)
+		return
+	}
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("New device notification sent to user with ID [%s]", session.UserId),
+	}
+	svc.logger.LogInfo(logEntry)
+}
+
+// describeDevice names the browser and operating system in a user agent,
+// such as "Firefox on Linux". It is only meant to be recognisable to the
+// user, and is what new-device detection compares.
+func describeDevice(userAgent string) string {
+	browser := "Unknown browser"
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+
+	os := ""
+	for _, candidate := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			os = candidate.name
+			break
+		}
+	}
+
+	if os == "" {
+		return browser
+	}
+	return fmt.Sprintf("%s on %s", browser, os)
+}
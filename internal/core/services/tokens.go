@@ -0,0 +1,280 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReuse   = errors.New("refresh token reuse detected")
+)
+
+type TokenManagementService struct {
+	refreshTokens   ports.RefreshTokenRepository
+	revocations     ports.RevocationStore
+	logger          ports.LoggingService
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+}
+
+func NewTokenManagementService(refreshTokens ports.RefreshTokenRepository, revocations ports.RevocationStore, logger ports.LoggingService, accessTokenTTL, refreshTokenTTL time.Duration) *TokenManagementService {
+	svc := TokenManagementService{
+		refreshTokens:   refreshTokens,
+		revocations:     revocations,
+		logger:          logger,
+		accessTokenTTL:  accessTokenTTL,
+		refreshTokenTTL: refreshTokenTTL,
+	}
+	return &svc
+}
+
+// IssueRefreshToken starts a new token family for the user and returns the
+// opaque token. Only its hash is persisted. The family id identifies the
+// session.
+func (svc *TokenManagementService) IssueRefreshToken(user_id string) (*domain.RefreshToken, string, error) {
+	token, refreshToken, err := svc.createRefreshToken(user_id, uuid.New().String())
+	if err != nil {
+		return nil, "", err
+	}
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Refresh token issued for user with ID [%s]", user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+	return token, refreshToken, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. Presenting a token that was already rotated revokes the whole
+// family, since it means the token has leaked.
+func (svc *TokenManagementService) RotateRefreshToken(refreshToken string) (*domain.RefreshToken, string, error) {
+	token, err := svc.refreshTokens.ReadRefreshTokenWithHash(hashToken(refreshToken))
+	if err != nil {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	if token.Revoked || time.Now().After(token.ExpiresAt) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	marked := false
+	if !token.Used {
+		marked, err = svc.refreshTokens.MarkRefreshTokenUsed(token.TokenId)
+		if err != nil {
+			logEntry := domain.LogMessage{
+				LogLevel: "ERROR",
+				Service:  "users",
+				Message:  err.Error(),
+			}
+			svc.logger.LogError(logEntry)
+			return nil, "", err
+		}
+	}
+
+	if !marked {
+		if _, err := svc.refreshTokens.RevokeRefreshTokenFamily(token.FamilyId); err != nil {
+			logEntry := domain.LogMessage{
+				LogLevel: "ERROR",
+				Service:  "users",
+				Message:  err.Error(),
+			}
+			svc.logger.LogError(logEntry)
+		}
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  fmt.Sprintf("Refresh token reuse detected for user with ID [%s], token family [%s] revoked", token.UserId, token.FamilyId),
+		}
+		svc.logger.LogWarning(logEntry)
+		return nil, "", ErrRefreshTokenReuse
+	}
+
+	return svc.createRefreshToken(token.UserId, token.FamilyId)
+}
+
+// RevokeRefreshToken revokes the family of a refresh token held by user_id.
+// Tokens belonging to anyone else are treated as unknown.
+func (svc *TokenManagementService) RevokeRefreshToken(user_id, refreshToken string) error {
+	token, err := svc.refreshTokens.ReadRefreshTokenWithHash(hashToken(refreshToken))
+	if err != nil {
+		return ErrInvalidRefreshToken
+	}
+	if token.UserId != user_id {
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  fmt.Sprintf("User with ID [%s] tried to revoke a refresh token of user with ID [%s]", user_id, token.UserId),
+		}
+		svc.logger.LogWarning(logEntry)
+		return ErrInvalidRefreshToken
+	}
+	_, err = svc.refreshTokens.RevokeRefreshTokenFamily(token.FamilyId)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	return nil
+}
+
+// RevokeAccessToken blocks the access token with the given jti. The entry only
+// needs to outlive the token itself.
+func (svc *TokenManagementService) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	err := svc.revocations.RevokeToken(jti, expiresAt)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Access token [%s] revoked successfuly", jti),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+// RevokeSessionTokens revokes the refresh token family of a session and
+// blocks the access tokens already issued to it.
+func (svc *TokenManagementService) RevokeSessionTokens(session_id string) error {
+	_, err := svc.refreshTokens.RevokeRefreshTokenFamily(session_id)
+	if err == nil {
+		err = svc.revocations.RevokeToken(session_id, time.Now().Add(svc.accessTokenTTL))
+	}
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked checks the token's jti, its session and the user's
+// logout-all time. Tokens issued before sessions were tracked have no
+// session_id.
+func (svc *TokenManagementService) IsAccessTokenRevoked(jti, session_id, user_id string, issuedAt time.Time) (bool, error) {
+	revoked, err := svc.revocations.IsTokenRevoked(jti)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	if session_id != "" {
+		revoked, err := svc.revocations.IsTokenRevoked(session_id)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	revokedAt, err := svc.revocations.ReadUserTokensRevokedAt(user_id)
+	if err != nil {
+		return false, err
+	}
+	if revokedAt.IsZero() {
+		return false, nil
+	}
+	// issuedAt has millisecond precision, so only tokens issued after the
+	// cutoff, such as by a re-login, stay valid.
+	return !issuedAt.After(revokedAt), nil
+}
+
+// LogoutAllSessions invalidates every access token issued to the user so far
+// and revokes all of their refresh tokens.
+func (svc *TokenManagementService) LogoutAllSessions(user_id string) error {
+	now := time.Now()
+	err := svc.revocations.RevokeUserTokens(user_id, now, now.Add(svc.accessTokenTTL))
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+
+	_, err = svc.refreshTokens.RevokeUserRefreshTokens(user_id)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return err
+	}
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("All sessions for user with ID [%s] logged out successfuly", user_id),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
+
+func (svc *TokenManagementService) createRefreshToken(user_id, family_id string) (*domain.RefreshToken, string, error) {
+	refreshToken, err := generateToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	token := domain.RefreshToken{
+		TokenId:   uuid.New().String(),
+		FamilyId:  family_id,
+		UserId:    user_id,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: now.Add(svc.refreshTokenTTL),
+		CreatedAt: now,
+	}
+
+	created, err := svc.refreshTokens.CreateRefreshToken(&token)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, "", err
+	}
+	return created, refreshToken, nil
+}
+
+// generateToken returns n random bytes encoded for use in URLs and headers.
+func generateToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
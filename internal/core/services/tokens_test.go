@@ -0,0 +1,239 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/adapters/repository/memory"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+type fakeLogger struct{}
+
+func (fakeLogger) SendLog(domain.LogMessage)    {}
+func (fakeLogger) LogDebug(domain.LogMessage)   {}
+func (fakeLogger) LogInfo(domain.LogMessage)    {}
+func (fakeLogger) LogWarning(domain.LogMessage) {}
+func (fakeLogger) LogError(domain.LogMessage)   {}
+
+// fakeRefreshTokenRepository keeps refresh tokens in memory. Like the
+// postgres query, MarkRefreshTokenUsed only succeeds for one caller.
+type fakeRefreshTokenRepository struct {
+	mu     *sync.Mutex
+	tokens map[string]*domain.RefreshToken
+}
+
+func newFakeRefreshTokenRepository() fakeRefreshTokenRepository {
+	return fakeRefreshTokenRepository{mu: &sync.Mutex{}, tokens: map[string]*domain.RefreshToken{}}
+}
+
+func (repo fakeRefreshTokenRepository) CreateRefreshToken(token *domain.RefreshToken) (*domain.RefreshToken, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	stored := *token
+	repo.tokens[token.TokenId] = &stored
+	return token, nil
+}
+
+func (repo fakeRefreshTokenRepository) ReadRefreshTokenWithHash(token_hash string) (*domain.RefreshToken, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, token := range repo.tokens {
+		if token.TokenHash == token_hash {
+			found := *token
+			return &found, nil
+		}
+	}
+	return nil, errors.New("refresh token not found")
+}
+
+func (repo fakeRefreshTokenRepository) MarkRefreshTokenUsed(token_id string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	token, ok := repo.tokens[token_id]
+	if !ok || token.Used || token.Revoked {
+		return false, nil
+	}
+	token.Used = true
+	return true, nil
+}
+
+func (repo fakeRefreshTokenRepository) RevokeRefreshTokenFamily(family_id string) (string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, token := range repo.tokens {
+		if token.FamilyId == family_id {
+			token.Revoked = true
+		}
+	}
+	return "", nil
+}
+
+func (repo fakeRefreshTokenRepository) RevokeUserRefreshTokens(user_id string) (string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	for _, token := range repo.tokens {
+		if token.UserId == user_id {
+			token.Revoked = true
+		}
+	}
+	return "", nil
+}
+
+func TestLogoutAllSessionsCutoff(t *testing.T) {
+	store := memory.NewRevocationStore()
+	svc := NewTokenManagementService(newFakeRefreshTokenRepository(), store, fakeLogger{}, time.Minute, time.Hour)
+	if err := svc.LogoutAllSessions("user-1"); err != nil {
+		t.Fatal(err)
+	}
+	cutoff, err := store.ReadUserTokensRevokedAt("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		revoked  bool
+	}{
+		{"issued a second before", cutoff.Add(-time.Second), true},
+		{"issued earlier in the same second", cutoff.Add(-time.Millisecond), true},
+		{"issued at the cutoff", cutoff, true},
+		{"issued just after", cutoff.Add(time.Millisecond), false},
+		{"issued a second after", cutoff.Add(time.Second), false},
+	}
+	for _, tt := range tests {
+		revoked, err := svc.IsAccessTokenRevoked("jti-"+tt.name, "", "user-1", tt.issuedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != tt.revoked {
+			t.Errorf("%s: revoked = %v, want %v", tt.name, revoked, tt.revoked)
+		}
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	repo := newFakeRefreshTokenRepository()
+	svc := NewTokenManagementService(repo, memory.NewRevocationStore(), fakeLogger{}, time.Minute, time.Hour)
+
+	issued, first, err := svc.IssueRefreshToken("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, second, err := svc.RotateRefreshToken(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || rotated.TokenId == issued.TokenId {
+		t.Errorf("rotation returned the same token")
+	}
+	if rotated.FamilyId != issued.FamilyId || rotated.UserId != "user-1" {
+		t.Errorf("rotated token is in family %s for %s, want %s for user-1", rotated.FamilyId, rotated.UserId, issued.FamilyId)
+	}
+	_, third, err := svc.RotateRefreshToken(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Presenting a rotated token again means it leaked, so the whole family
+	// is revoked, including the newest token.
+	if _, _, err := svc.RotateRefreshToken(first); err != ErrRefreshTokenReuse {
+		t.Errorf("reused token returned %v, want %v", err, ErrRefreshTokenReuse)
+	}
+	if _, _, err := svc.RotateRefreshToken(third); err != ErrInvalidRefreshToken {
+		t.Errorf("newest token after reuse returned %v, want %v", err, ErrInvalidRefreshToken)
+	}
+
+	// Other sessions are not affected.
+	_, other, err := svc.IssueRefreshToken("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := svc.RotateRefreshToken(other); err != nil {
+		t.Errorf("token from another session returned %v", err)
+	}
+
+	if _, _, err := svc.RotateRefreshToken("not-a-token"); err != ErrInvalidRefreshToken {
+		t.Errorf("unknown token returned %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestRotateExpiredRefreshToken(t *testing.T) {
+	repo := newFakeRefreshTokenRepository()
+	svc := NewTokenManagementService(repo, memory.NewRevocationStore(), fakeLogger{}, time.Minute, 10*time.Millisecond)
+
+	_, refreshToken, err := svc.IssueRefreshToken("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, _, err := svc.RotateRefreshToken(refreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("expired token returned %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestRotateRefreshTokenConcurrently(t *testing.T) {
+	repo := newFakeRefreshTokenRepository()
+	svc := NewTokenManagementService(repo, memory.NewRevocationStore(), fakeLogger{}, time.Minute, time.Hour)
+
+	_, refreshToken, err := svc.IssueRefreshToken("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 10
+	errs := make(chan error, attempts)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, _, err := svc.RotateRefreshToken(refreshToken)
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else if err != ErrRefreshTokenReuse && err != ErrInvalidRefreshToken {
+			t.Errorf("concurrent rotation returned %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d concurrent rotations succeeded, want 1", succeeded)
+	}
+}
+
+func TestRevokeRefreshTokenChecksOwner(t *testing.T) {
+	repo := newFakeRefreshTokenRepository()
+	svc := NewTokenManagementService(repo, memory.NewRevocationStore(), fakeLogger{}, time.Minute, time.Hour)
+
+	_, refreshToken, err := svc.IssueRefreshToken("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RevokeRefreshToken("user-2", refreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("revoking another user's token returned %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	_, refreshToken, err = svc.RotateRefreshToken(refreshToken)
+	if err != nil {
+		t.Fatalf("token revoked by another user: %v", err)
+	}
+
+	if err := svc.RevokeRefreshToken("user-1", refreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := svc.RotateRefreshToken(refreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("revoked token returned %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/config"
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+	"github.com/AntonyIS/notelify-users-service/internal/core/ports"
+)
+
+type fakeUserRepository struct {
+	ports.UserRepository
+	users map[string]*domain.User
+}
+
+func (repo fakeUserRepository) ReadUserWithId(user_id string) (*domain.User, error) {
+	if user, ok := repo.users[user_id]; ok {
+		return user, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+// fakeMFARepository keeps TOTP state on the users it shares with a
+// fakeUserRepository, and behaves like the postgres queries.
+type fakeMFARepository struct {
+	users         map[string]*domain.User
+	secrets       map[string]string
+	lastSteps     map[string]int64
+	recoveryCodes map[string]map[string]bool
+}
+
+func newFakeMFARepository(users map[string]*domain.User) fakeMFARepository {
+	return fakeMFARepository{
+		users:         users,
+		secrets:       map[string]string{},
+		lastSteps:     map[string]int64{},
+		recoveryCodes: map[string]map[string]bool{},
+	}
+}
+
+func (repo fakeMFARepository) UpdateUserTOTP(user_id, secret string, enabled bool) (string, error) {
+	if repo.secrets[user_id] != secret {
+		repo.lastSteps[user_id] = 0
+	}
+	repo.secrets[user_id] = secret
+	repo.users[user_id].TOTPEnabled = enabled
+	return "", nil
+}
+
+func (repo fakeMFARepository) ReadUserTOTPSecret(user_id string) (string, error) {
+	return repo.secrets[user_id], nil
+}
+
+func (repo fakeMFARepository) ReadUserTOTPSecrets() (map[string]string, error) {
+	return repo.secrets, nil
+}
+
+func (repo fakeMFARepository) ReplaceUserTOTPSecret(user_id, secret string) (string, error) {
+	repo.secrets[user_id] = secret
+	return "", nil
+}
+
+func (repo fakeMFARepository) UpdateUserTOTPLastStep(user_id string, step int64) (bool, error) {
+	if repo.lastSteps[user_id] >= step {
+		return false, nil
+	}
+	repo.lastSteps[user_id] = step
+	return true, nil
+}
+
+func (repo fakeMFARepository) CreateRecoveryCodes(user_id string, code_hashes []string) (string, error) {
+	repo.recoveryCodes[user_id] = map[string]bool{}
+	for _, code_hash := range code_hashes {
+		repo.recoveryCodes[user_id][code_hash] = false
+	}
+	return "", nil
+}
+
+func (repo fakeMFARepository) ConsumeRecoveryCode(user_id, code_hash string) (bool, error) {
+	used, ok := repo.recoveryCodes[user_id][code_hash]
+	if !ok || used {
+		return false, nil
+	}
+	repo.recoveryCodes[user_id][code_hash] = true
+	return true, nil
+}
+
+func (repo fakeMFARepository) DeleteRecoveryCodes(user_id string) (string, error) {
+	delete(repo.recoveryCodes, user_id)
+	return "", nil
+}
+
+func newTestEncrypter(t *testing.T, ids ...string) *EnvelopeEncryptionService {
+	t.Helper()
+	keys := []string{}
+	for _, id := range ids {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, id+":"+base64.StdEncoding.EncodeToString(key))
+	}
+	encrypter, err := NewEnvelopeEncryptionService(strings.Join(keys, ","), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypter
+}
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// Test vectors from RFC 6238 appendix B for SHA-1. The RFC uses eight
+	// digits; six digit codes are the last six.
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if code := totpCode(key, tt.unix/totpPeriod); code != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestTOTPEnrollmentAndVerification(t *testing.T) {
+	// Keep the whole test inside one time step.
+	if left := totpPeriod - time.Now().Unix()%totpPeriod; left < 3 {
+		time.Sleep(time.Duration(left) * time.Second)
+	}
+
+	users := map[string]*domain.User{"user-1": {UserId: "user-1", Email: "ada@example.com"}}
+	mfa := newFakeMFARepository(users)
+	conf := config.Config{TOTP_ISSUER: "Notelify"}
+	svc := NewUserManagementService(fakeUserRepository{users: users}, nil, mfa, nil, nil, fakeLogger{}, nil, nil, newTestEncrypter(t, "k1"), nil, conf)
+
+	enrollment, err := svc.EnrollTOTP("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := mfa.secrets["user-1"]; !strings.HasPrefix(stored, encryptedValuePrefix) || strings.Contains(stored, enrollment.Secret) {
+		t.Fatalf("secret stored as %q, want it encrypted", stored)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := time.Now().Unix() / totpPeriod
+
+	// A code from the previous step is inside the skew window.
+	recoveryCodes, err := svc.ConfirmTOTP("user-1", totpCode(key, current-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveryCodes) != recoveryCodeCount || !users["user-1"].TOTPEnabled {
+		t.Fatalf("confirm returned %d recovery codes, enabled %v", len(recoveryCodes), users["user-1"].TOTPEnabled)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		err  error
+	}{
+		{"replayed code", totpCode(key, current-1), ErrInvalidTOTPCode},
+		{"code outside the skew window", totpCode(key, current+2), ErrInvalidTOTPCode},
+		{"current code", totpCode(key, current), nil},
+		{"current code again", totpCode(key, current), ErrInvalidTOTPCode},
+		{"recovery code", strings.ToLower(recoveryCodes[0]), nil},
+		{"used recovery code", recoveryCodes[0], ErrInvalidTOTPCode},
+		{"unknown recovery code", "AAAAA-AAAAA", ErrInvalidTOTPCode},
+	}
+	for _, tt := range tests {
+		if err := svc.VerifyTOTP("user-1", tt.code); err != tt.err {
+			t.Errorf("%s: VerifyTOTP returned %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestTOTPEnrollmentRequiresEncryptionKey(t *testing.T) {
+	users := map[string]*domain.User{"user-1": {UserId: "user-1"}}
+	mfa := newFakeMFARepository(users)
+	encrypter, err := NewEnvelopeEncryptionService("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewUserManagementService(fakeUserRepository{users: users}, nil, mfa, nil, nil, fakeLogger{}, nil, nil, encrypter, nil, config.Config{})
+
+	if _, err := svc.EnrollTOTP("user-1"); err != ErrEncryptionKeyNotSet {
+		t.Errorf("EnrollTOTP returned %v, want %v", err, ErrEncryptionKeyNotSet)
+	}
+	if _, ok := mfa.secrets["user-1"]; ok {
+		t.Errorf("secret stored without an encryption key")
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/AntonyIS/notelify-users-service/internal/core/domain"
+)
+
+const emailVerificationPurpose = "email_verification"
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+
+func (svc *UserManagementService) VerifyEmail(token string) (*domain.User, error) {
+	verificationToken, err := svc.tokens.ConsumeOneTimeToken(hashToken(token), emailVerificationPurpose)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	user, err := svc.repo.ReadUserWithId(verificationToken.UserId)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+
+	_, err = svc.repo.UpdateUserEmailVerified(user.UserId, true)
+	if err != nil {
+		logEntry := domain.LogMessage{
+			LogLevel: "ERROR",
+			Service:  "users",
+			Message:  err.Error(),
+		}
+		svc.logger.LogError(logEntry)
+		return nil, err
+	}
+	user.EmailVerified = true
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Email for user with ID [%s] verified successfuly", user.UserId),
+	}
+	svc.logger.LogInfo(logEntry)
+	return user, nil
+}
+
+// ResendVerificationEmail sends a fresh verification link unless one was sent
+// within the configured cooldown. Unknown and already verified addresses and
+// requests inside the cooldown are ignored without an error, so callers
+// cannot tell whether an account exists.
+func (svc *UserManagementService) ResendVerificationEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	user, err := svc.repo.ReadUserWithEmail(email)
+	if err != nil || user.EmailVerified {
+		return nil
+	}
+
+	latest, err := svc.tokens.ReadLatestOneTimeToken(user.UserId, emailVerificationPurpose)
+	if err == nil && time.Since(latest.CreatedAt) < svc.conf.EMAIL_VERIFY_COOLDOWN {
+		logEntry := domain.LogMessage{
+			LogLevel: "WARNING",
+			Service:  "users",
+			Message:  fmt.Sprintf("Verification email for user with ID [%s] not sent, one was sent recently", user.UserId),
+		}
+		svc.logger.LogWarning(logEntry)
+		return nil
+	}
+
+	return svc.sendVerificationEmail(user)
+}
+
+func (svc *UserManagementService) sendVerificationEmail(user *domain.User) error {
+	token, err := svc.createOneTimeToken(user.UserId, emailVerificationPurpose, svc.conf.EMAIL_VERIFY_TTL)
+	if err != nil {
+		return err
+	}
+
+	verifyURL := fmt.Sprintf("%s/verify-email?token=%s", svc.conf.FRONTEND_URL, url.QueryEscape(token))
+	message := domain.MailMessage{
+		To:      user.Email,
+		Subject: "Verify your Notelify email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %s.\n\n%s\n",
+			user.Firstname, svc.conf.EMAIL_VERIFY_TTL, verifyURL),
+	}
+	go svc.sendMail(message)
+
+	logEntry := domain.LogMessage{
+		LogLevel: "INFO",
+		Service:  "users",
+		Message:  fmt.Sprintf("Verification email sent to user with ID [%s]", user.UserId),
+	}
+	svc.logger.LogInfo(logEntry)
+	return nil
+}
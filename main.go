@@ -1,9 +1,34 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/AntonyIS/notelify-users-service/cmd"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reencrypt-tokens":
+			cmd.ReencryptProviderTokens()
+			return
+		case "grant-role":
+			if len(os.Args) != 4 {
+				fmt.Println("usage: notelify-users-service grant-role <email> <role>")
+				os.Exit(2)
+			}
+			cmd.GrantRole(os.Args[2], os.Args[3])
+			return
+		case "register-client":
+			if len(os.Args) != 4 {
+				fmt.Println("usage: notelify-users-service register-client <name> <scope,...>")
+				os.Exit(2)
+			}
+			cmd.RegisterServiceClient(os.Args[2], strings.Split(os.Args[3], ","))
+			return
+		}
+	}
 	cmd.RunService()
 }